@@ -3,47 +3,159 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"backend/internal/config"
 	"backend/internal/database"
 	"backend/internal/handler"
+	"backend/internal/lti"
+	"backend/internal/metrics"
+	"backend/internal/notify"
+	"backend/internal/oidc"
+	"backend/internal/safehttp"
+	"backend/internal/scheduler"
+	"backend/internal/webhook"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
+	startedAt := time.Now()
+
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 	log.Println("Configuration loaded successfully")
 
+	logOptions := &slog.HandlerOptions{Level: cfg.LogLevel}
+	var logger *slog.Logger
+	if cfg.LogFormat == "text" {
+		logger = slog.New(slog.NewTextHandler(os.Stdout, logOptions))
+	} else {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, logOptions))
+	}
+	// Route the standard log package (used during startup) through the same handler
+	slog.SetDefault(logger)
+
 	db, err := database.Connect(cfg.MongoURI, cfg.DB_Name)
 	if err != nil {
 		log.Fatalf("Could not connect to the database: %v", err)
 	}
 
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Without delivery nothing is queued: a nil service drops notifications and offers no channel
+	var notifier *notify.Service
+	if cfg.NotificationsEnabled {
+		notifier = notify.New(db, logger, notifiers(cfg, logger))
+		go notifier.Run(ctx)
+	}
+
+	// Without delivery no event is queued: Emit on a nil dispatcher does nothing
+	var webhooks *webhook.Dispatcher
+	if cfg.WebhooksEnabled {
+		// Instructors and admins choose webhook URLs, so they must not reach internal addresses
+		webhooks = webhook.New(db, logger, safehttp.NewClient(15*time.Second))
+		go webhooks.Run(ctx)
+	}
+
+	// The scheduler also purges deleted classes and warns at-risk students, so it always runs
+	sched := scheduler.New(db, logger, cfg.SchedulerInterval, cfg.AutoSessionWindow)
+	sched.AutoSessions = cfg.SchedulerEnabled
+	sched.Notify = notifier
+	sched.Webhooks = webhooks
+	go sched.Run(ctx)
+
+	apiHandler := &handler.APIHandler{
+		DB:         db,
+		JWT_Secret: cfg.JWT_Secret,
+		Logger:     logger,
+		Version:    version,
+		StartedAt:  startedAt,
+
+		QueryTimeout:     cfg.DBQueryTimeout,
+		AggregateTimeout: cfg.DBAggregateTimeout,
+
+		MaxDevices:      cfg.MaxDevicesPerUser,
+		RequireDeviceID: cfg.RequireDeviceID,
+
+		Notify:   notifier,
+		Webhooks: webhooks,
+
+		PublicURL:      cfg.PublicURL,
+		LTIRedirectURL: cfg.LTIRedirectURL,
+
+		OIDCAppRedirectURLs: cfg.OIDCAppRedirectURLs,
+		OIDCJITProvisioning: cfg.OIDCJITProvisioning,
+		OIDCTrustEmail:      cfg.OIDCTrustEmail,
+
+		PasswordLoginDisabled: !cfg.PasswordLoginEnabled,
+
+		AuditRetention: cfg.AuditRetention,
+	}
+	if cfg.LTIPlatformsFile != "" {
+		apiHandler.LTI, err = ltiTool(cfg, logger)
+		if err != nil {
+			log.Fatalf("Could not set up LTI: %v", err)
+		}
+	}
+	if cfg.OIDCIssuer != "" {
+		apiHandler.OIDC = oidc.New(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, cfg.OIDCScopes, &http.Client{Timeout: 10 * time.Second})
+		logger.Info("Single sign-on enabled", "issuer", cfg.OIDCIssuer, "callback_url", cfg.OIDCRedirectURL, "jit_provisioning", cfg.OIDCJITProvisioning)
+	}
+	if !cfg.PasswordLoginEnabled {
+		logger.Info("Password registration and login disabled")
+	}
+
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	if cfg.TrustProxyHeaders {
+		r.Use(middleware.RealIP)
+	}
+	r.Use(handler.RequestID)
+	r.Use(apiHandler.RequestLogger)
+	r.Use(metrics.Middleware)
 	r.Use(middleware.Recoverer)
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", handler.RequestIDHeader},
+		ExposedHeaders:   []string{"Link", handler.RequestIDHeader, handler.NextCursorHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
-	apiHandler := &handler.APIHandler{
-		DB:         db,
-		JWT_Secret: cfg.JWT_Secret,
-	}
+	metrics.RegisterActiveSessions(db)
+	r.Handle("/metrics", promhttp.Handler())
+
+	r.Get("/healthz", apiHandler.Healthz)
+	r.Get("/readyz", apiHandler.Readyz)
+
+	// LTI 1.3 endpoints, called by LMS platforms and browsers rather than the app
+	r.Get("/lti/jwks", apiHandler.LTIJWKS)
+	r.Get("/lti/login", apiHandler.LTILogin)
+	r.Post("/lti/login", apiHandler.LTILogin)
+	r.Post("/lti/launch", apiHandler.LTILaunch)
+
+	// OpenID Connect single sign-on, visited by the browser
+	r.Get("/auth/oidc/login", apiHandler.OIDCLogin)
+	r.Get("/auth/oidc/callback", apiHandler.OIDCCallback)
 
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("API is running!"))
@@ -54,29 +166,215 @@ func main() {
 		// Public routes - No middleware needed
 		r.Post("/register", apiHandler.Register)
 		r.Post("/login", apiHandler.Login)
+		r.Get("/auth/methods", apiHandler.GetAuthMethods)
+
+		// Kiosks register themselves, then authenticate with their own credential
+		r.Post("/kiosk/register", apiHandler.RegisterKiosk)
+		r.Group(func(r chi.Router) {
+			r.Use(apiHandler.KioskMiddleware)
+			r.Get("/kiosk", apiHandler.GetKiosk)
+			r.Post("/kiosk/pairing-code", apiHandler.RequestKioskPairingCode)
+			r.Get("/kiosk/qr", apiHandler.GetKioskQR)
+		})
 
 		// Protected routes - Group them and apply the middleware
 		r.Group(func(r chi.Router) {
 			r.Use(apiHandler.AuthMiddleware)
 
-			// Classroom Routes
-			r.Post("/classes", apiHandler.CreateClass)
-			r.Get("/classes", apiHandler.GetMyClasses)
-			r.Post("/classes/join", apiHandler.JoinClass)
+			// Routes machine clients may call with an API key holding the scope
+			r.With(apiHandler.ScopeMiddleware(database.ScopeClassesRead)).Get("/classes", apiHandler.GetMyClasses)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeClassesRead)).Get("/classes/{classID}/meetings", apiHandler.GetUpcomingMeetings)
 
-			r.Post("/classes/{classID}/leave", apiHandler.LeaveClass)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeSessionsWrite)).Post("/classes/{classID}/attendance-session", apiHandler.CreateAttendanceSession)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeSessionsWrite)).Get("/classes/{classID}/attendance-session", apiHandler.GetActiveAttendanceSession)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeSessionsWrite)).Post("/classes/{classID}/lectures", apiHandler.StartLecture)
 
-			r.Post("/classes/{classID}/attendance-session", apiHandler.CreateAttendanceSession)
-			r.Post("/attendance/mark", apiHandler.MarkAttendance)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeAttendanceRead)).Get("/classes/{classID}/attendance", apiHandler.GetClassAttendance)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeAttendanceRead)).Get("/classes/{classID}/lectures", apiHandler.GetClassLectures)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeAttendanceRead)).Get("/classes/{classID}/lectures/{lectureID}/roll-call", apiHandler.GetRollCall)
 
-			r.Get("/classes/{classID}/attendance", apiHandler.GetClassAttendance)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeAttendanceWrite)).Post("/classes/{classID}/lectures/{lectureID}/roll-call", apiHandler.SubmitRollCall)
+			r.With(apiHandler.ScopeMiddleware(database.ScopeAttendanceWrite)).Post("/classes/{classID}/lectures/{lectureID}/excuses", apiHandler.ExcuseAbsence)
 
-			r.Get("/attendance/history", apiHandler.GetMyAttendanceHistory)
+			// Everything else needs a signed-in user
+			r.Group(func(r chi.Router) {
+				r.Use(apiHandler.SessionMiddleware)
+
+				// Profile Routes
+				r.Get("/me", apiHandler.GetMe)
+				r.Put("/me", apiHandler.UpdateMe)
+				r.Delete("/me", apiHandler.DeleteMe)
+				r.Get("/me/export", apiHandler.ExportMe)
+				r.Put("/me/password", apiHandler.ChangePassword)
+				r.Post("/me/email", apiHandler.RequestEmailChange)
+				r.Post("/me/email/verify", apiHandler.VerifyEmailChange)
+				r.Post("/me/identities", apiHandler.LinkIdentity)
+
+				r.Get("/me/notifications", apiHandler.GetNotificationPrefs)
+				r.Put("/me/notifications", apiHandler.UpdateNotificationPrefs)
+				r.Post("/me/push-tokens", apiHandler.AddPushToken)
+				r.Delete("/me/push-tokens", apiHandler.RemovePushToken)
+
+				r.Post("/me/api-keys", apiHandler.CreateAPIKey)
+				r.Get("/me/api-keys", apiHandler.GetAPIKeys)
+				r.Delete("/me/api-keys/{keyID}", apiHandler.RevokeAPIKey)
+
+				// Term Routes
+				r.Post("/terms", apiHandler.CreateTerm)
+				r.Get("/terms", apiHandler.GetTerms)
+
+				// Classroom Routes
+				r.Post("/classes", apiHandler.CreateClass)
+				r.Post("/classes/join", apiHandler.JoinClass)
+
+				r.Put("/classes/{classID}", apiHandler.UpdateClass)
+				r.Delete("/classes/{classID}", apiHandler.DeleteClass)
+				r.Post("/classes/{classID}/restore", apiHandler.RestoreClass)
+				r.Post("/classes/{classID}/transfer", apiHandler.TransferClass)
+				r.Post("/classes/{classID}/leave", apiHandler.LeaveClass)
+				r.Post("/classes/{classID}/archive", apiHandler.ArchiveClass)
+				r.Post("/classes/{classID}/unarchive", apiHandler.UnarchiveClass)
+
+				r.Put("/classes/{classID}/schedule", apiHandler.UpdateClassSchedule)
+
+				r.Post("/attendance/mark", apiHandler.MarkAttendance)
+				r.Post("/attendance/mark/beacon", apiHandler.MarkAttendanceWithBeacon)
+
+				r.Post("/classes/{classID}/kiosks", apiHandler.PairKiosk)
+				r.Get("/classes/{classID}/kiosks", apiHandler.GetClassKiosks)
+				r.Delete("/classes/{classID}/kiosks/{kioskID}", apiHandler.UnpairKiosk)
+
+				r.Get("/attendance/history", apiHandler.GetMyAttendanceHistory)
+				r.Get("/me/attendance/summary", apiHandler.GetMyAttendanceSummary)
+
+				// Analytics Routes
+				r.Get("/analytics/classes", apiHandler.GetClassCohorts)
+				r.Get("/classes/{classID}/analytics/trend", apiHandler.GetAttendanceTrend)
+				r.Get("/classes/{classID}/analytics/heatmap", apiHandler.GetAttendanceHeatmap)
+				r.Get("/classes/{classID}/analytics/arrivals", apiHandler.GetArrivalDistribution)
+
+				// Device Routes
+				r.Get("/me/devices", apiHandler.GetMyDevices)
+				r.Delete("/classes/{classID}/students/{studentID}/devices", apiHandler.ResetStudentDevices)
+				r.Get("/classes/{classID}/device-flags", apiHandler.GetSharedDeviceFlags)
+
+				r.Get("/classes/{classID}/attendance/suspicious", apiHandler.GetSuspiciousAttendance)
+
+				// Webhook Routes
+				r.Post("/classes/{classID}/webhooks", apiHandler.CreateWebhook)
+				r.Get("/classes/{classID}/webhooks", apiHandler.GetWebhooks)
+				r.Put("/classes/{classID}/webhooks/{webhookID}", apiHandler.UpdateWebhook)
+				r.Delete("/classes/{classID}/webhooks/{webhookID}", apiHandler.DeleteWebhook)
+				r.Get("/classes/{classID}/webhooks/{webhookID}/deliveries", apiHandler.GetWebhookDeliveries)
+				r.Post("/classes/{classID}/webhooks/{webhookID}/deliveries/{deliveryID}/replay", apiHandler.ReplayWebhookDelivery)
+
+				r.Post("/classes/{classID}/lti/grades", apiHandler.PushLTIGrades)
+
+				// Organization Routes
+				r.Get("/org", apiHandler.GetMyOrganization)
+				r.Put("/org", apiHandler.UpdateOrganization)
+				r.Get("/org/members", apiHandler.GetOrganizationMembers)
+				r.Put("/org/members/{userID}/role", apiHandler.SetOrganizationRole)
+
+				r.Post("/org/webhooks", apiHandler.CreateWebhook)
+				r.Get("/org/webhooks", apiHandler.GetWebhooks)
+				r.Put("/org/webhooks/{webhookID}", apiHandler.UpdateWebhook)
+				r.Delete("/org/webhooks/{webhookID}", apiHandler.DeleteWebhook)
+				r.Get("/org/webhooks/{webhookID}/deliveries", apiHandler.GetWebhookDeliveries)
+				r.Post("/org/webhooks/{webhookID}/deliveries/{deliveryID}/replay", apiHandler.ReplayWebhookDelivery)
+
+				r.Post("/org/beacons", apiHandler.CreateBeacon)
+				r.Get("/org/beacons", apiHandler.GetBeacons)
+				r.Delete("/org/beacons/{beaconID}", apiHandler.DeleteBeacon)
+
+				// Platform operators
+				r.Route("/admin", func(r chi.Router) {
+					r.Use(apiHandler.AdminMiddleware)
+					r.Get("/users", apiHandler.AdminSearchUsers)
+					r.Get("/users/{userID}", apiHandler.AdminGetUser)
+					r.Post("/users/{userID}/disable", apiHandler.AdminDisableUser)
+					r.Post("/users/{userID}/enable", apiHandler.AdminEnableUser)
+					r.Post("/users/{userID}/password", apiHandler.AdminResetPassword)
+					r.Post("/users/{userID}/impersonate", apiHandler.AdminImpersonateUser)
+					r.Get("/classes", apiHandler.AdminGetClasses)
+					r.Get("/classes/{classID}", apiHandler.AdminGetClass)
+					r.Get("/classes/{classID}/attendance", apiHandler.AdminGetClassAttendance)
+					r.Get("/stats", apiHandler.AdminGetStats)
+					r.Get("/audit", apiHandler.AdminGetAuditLog)
+				})
+			})
 		})
 	})
 
-	log.Printf("Server starting on port %s...", cfg.ServerPort)
-	if err := http.ListenAndServe(":"+cfg.ServerPort, r); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{Addr: ":" + cfg.ServerPort, Handler: r}
+	go func() {
+		logger.Info("Server starting", "port", cfg.ServerPort, "version", version)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Failed to start server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("Shutdown signal received", "delay", cfg.ShutdownDelay, "timeout", cfg.ShutdownTimeout)
+	apiHandler.BeginShutdown()
+	// Keep serving while readiness fails so load balancers can take this replica out of rotation
+	time.Sleep(cfg.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Graceful shutdown failed", "error", err)
+	}
+	if err := db.Client().Disconnect(shutdownCtx); err != nil {
+		logger.Error("Failed to disconnect from MongoDB", "error", err)
+	}
+	logger.Info("Server stopped")
+}
+
+// notifiers builds the notification channels the configuration enables.
+func notifiers(cfg *config.Config, logger *slog.Logger) map[string]notify.Notifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+	channels := map[string]notify.Notifier{
+		// Users choose this URL, so it must not reach internal addresses
+		notify.ChannelWebhook: &notify.WebhookNotifier{Client: safehttp.NewClient(10 * time.Second)},
+	}
+	if cfg.SMTPHost != "" {
+		channels[notify.ChannelEmail] = &notify.SMTPNotifier{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+		}
+	}
+	if cfg.ExpoPushEnabled {
+		channels[notify.ChannelPush] = &notify.ExpoNotifier{URL: notify.ExpoPushURL, AccessToken: cfg.ExpoAccessToken, Client: client}
+	}
+	if cfg.NotificationSink == "log" {
+		for _, channel := range notify.Channels {
+			channels[channel] = &notify.LogNotifier{Logger: logger, Channel: channel}
+		}
+	}
+	return channels
+}
+
+// ltiTool loads the registered LMS platforms and the tool's signing key.
+func ltiTool(cfg *config.Config, logger *slog.Logger) (*lti.Tool, error) {
+	platforms, err := lti.LoadPlatforms(cfg.LTIPlatformsFile)
+	if err != nil {
+		return nil, err
+	}
+	var key *lti.Key
+	if cfg.LTIPrivateKeyFile != "" {
+		key, err = lti.LoadKey(cfg.LTIPrivateKeyFile)
+	} else {
+		logger.Warn("LTI_PRIVATE_KEY_FILE not set, using a throwaway key; grade pushes break after a restart")
+		key, err = lti.GenerateKey()
+	}
+	if err != nil {
+		return nil, err
 	}
+	logger.Info("LTI enabled", "platforms", len(platforms), "key_id", key.ID, "launch_url", cfg.PublicURL+"/lti/launch")
+	return lti.NewTool(platforms, key, &http.Client{Timeout: 15 * time.Second}), nil
 }
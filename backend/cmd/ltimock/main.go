@@ -0,0 +1,302 @@
+// File: cmd/ltimock/main.go
+
+// Command ltimock is a minimal LTI 1.3 platform for trying the tool locally. It launches the
+// tool as a fixed instructor or one of two learners of a single course, and accepts the grades
+// the tool posts back, printing them and listing them at /scores.
+//
+//	go run ./cmd/ltimock -tool http://localhost:3000
+//
+// It prints the platform registration to put in the tool's LTI_PLATFORMS_FILE.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/jwks"
+	"backend/internal/lti"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	clientID     = "ltimock-client"
+	deploymentID = "ltimock-deployment"
+	contextID    = "course-101"
+)
+
+type mockUser struct {
+	Subject string
+	Name    string
+	Email   string
+	Role    string
+}
+
+var users = map[string]mockUser{
+	"teacher": {Subject: "u-teacher", Name: "Terry Teacher", Email: "teacher@ltimock.test", Role: lti.RoleInstructor},
+	"alice":   {Subject: "u-alice", Name: "Alice Learner", Email: "alice@ltimock.test", Role: lti.RoleLearner},
+	"bob":     {Subject: "u-bob", Name: "Bob Learner", Email: "bob@ltimock.test", Role: lti.RoleLearner},
+}
+
+type platform struct {
+	issuer  string
+	toolURL string
+	key     *lti.Key
+
+	mu        sync.Mutex
+	tokens    map[string]time.Time // Access token -> expiry
+	lineItems []lti.LineItem
+	scores    map[string][]lti.Score // By line item ID
+}
+
+func main() {
+	addr := flag.String("addr", ":4000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:4000", "platform issuer, the URL the tool and browser reach this mock at")
+	toolURL := flag.String("tool", "http://localhost:3000", "base URL of the tool (the API's PUBLIC_URL)")
+	flag.Parse()
+
+	key, err := lti.GenerateKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := &platform{
+		issuer:  strings.TrimSuffix(*issuer, "/"),
+		toolURL: strings.TrimSuffix(*toolURL, "/"),
+		key:     key,
+		tokens:  map[string]time.Time{},
+		scores:  map[string][]lti.Score{},
+	}
+
+	registration, _ := json.MarshalIndent([]lti.Platform{{
+		Name:          "ltimock",
+		Issuer:        p.issuer,
+		ClientID:      clientID,
+		DeploymentIDs: []string{deploymentID},
+		AuthLoginURL:  p.issuer + "/auth",
+		AuthTokenURL:  p.issuer + "/token",
+		KeysetURL:     p.issuer + "/jwks",
+	}}, "", "  ")
+	fmt.Printf("Platform registration for LTI_PLATFORMS_FILE:\n%s\n\nOpen %s to launch the tool.\n", registration, p.issuer)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", p.home)
+	mux.HandleFunc("GET /start", p.start)
+	mux.HandleFunc("/auth", p.authorize)
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.key.JWKS())
+	})
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /lineitems", p.requireToken(p.listLineItems))
+	mux.HandleFunc("POST /lineitems", p.requireToken(p.createLineItem))
+	mux.HandleFunc("POST /lineitems/{id}/scores", p.requireToken(p.postScore))
+	mux.HandleFunc("GET /scores", p.listScores)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+var homePage = template.Must(template.New("home").Parse(`<!doctype html>
+<title>LTI mock platform</title>
+<h1>Course 101</h1>
+<ul>{{range $hint, $u := .}}<li><a href="/start?user={{$hint}}">Launch as {{$u.Name}}</a></li>{{end}}</ul>
+<p><a href="/scores">Scores posted by the tool</a></p>`))
+
+func (p *platform) home(w http.ResponseWriter, r *http.Request) {
+	homePage.Execute(w, users)
+}
+
+// start sends the browser to the tool's login initiation, as an LMS does when a link is clicked.
+func (p *platform) start(w http.ResponseWriter, r *http.Request) {
+	hint := r.URL.Query().Get("user")
+	if _, ok := users[hint]; !ok {
+		http.Error(w, "unknown user", http.StatusBadRequest)
+		return
+	}
+	q := url.Values{
+		"iss":               {p.issuer},
+		"login_hint":        {hint},
+		"target_link_uri":   {p.toolURL + "/lti/launch"},
+		"lti_message_hint":  {"resource-1"},
+		"client_id":         {clientID},
+		"lti_deployment_id": {deploymentID},
+	}
+	http.Redirect(w, r, p.toolURL+"/lti/login?"+q.Encode(), http.StatusFound)
+}
+
+var launchForm = template.Must(template.New("launch").Parse(`<!doctype html>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="id_token" value="{{.IDToken}}">
+<input type="hidden" name="state" value="{{.State}}">
+<noscript><button>Continue</button></noscript>
+</form>`))
+
+// authorize answers the tool's authentication request with a signed id_token, auto-posted to
+// the tool's launch URL.
+func (p *platform) authorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("client_id") != clientID || r.Form.Get("response_type") != "id_token" || r.Form.Get("scope") != "openid" {
+		http.Error(w, "invalid authentication request", http.StatusBadRequest)
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	if redirectURI != p.toolURL+"/lti/launch" {
+		http.Error(w, "unregistered redirect_uri "+redirectURI, http.StatusBadRequest)
+		return
+	}
+	user, ok := users[r.Form.Get("login_hint")]
+	if !ok {
+		http.Error(w, "unknown login_hint", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                 p.issuer,
+		"sub":                 user.Subject,
+		"aud":                 clientID,
+		"iat":                 now.Unix(),
+		"exp":                 now.Add(5 * time.Minute).Unix(),
+		"nonce":               r.Form.Get("nonce"),
+		"name":                user.Name,
+		"email":               user.Email,
+		lti.ClaimMessageType:  lti.MessageResourceLink,
+		lti.ClaimVersion:      "1.3.0",
+		lti.ClaimDeploymentID: deploymentID,
+		lti.ClaimTargetLink:   redirectURI,
+		lti.ClaimRoles:        []string{user.Role},
+		lti.ClaimContext: map[string]interface{}{
+			"id":    contextID,
+			"label": "CS101",
+			"title": "Introduction to Computer Science",
+			"type":  []string{"http://purl.imsglobal.org/vocab/lis/v2/course#CourseOffering"},
+		},
+		lti.ClaimResourceLink: map[string]string{"id": "resource-1", "title": "Attendance"},
+		lti.ClaimAGSEndpoint: map[string]interface{}{
+			"scope":     []string{lti.ScopeLineItem, lti.ScopeScore},
+			"lineitems": p.issuer + "/lineitems",
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.key.ID
+	idToken, err := token.SignedString(p.key.Private)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	launchForm.Execute(w, map[string]string{"Action": redirectURI, "IDToken": idToken, "State": r.Form.Get("state")})
+}
+
+// token issues an access token for a client assertion signed with a key from the tool's JWKS.
+func (p *platform) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("grant_type") != "client_credentials" {
+		http.Error(w, `{"error": "unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+	keys, err := jwks.Fetch(r.Context(), http.DefaultClient, p.toolURL+"/lti/jwks")
+	if err != nil {
+		http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+		log.Printf("token: fetch tool keys: %v", err)
+		return
+	}
+	_, err = jwt.Parse(r.Form.Get("client_assertion"),
+		func(t *jwt.Token) (interface{}, error) {
+			for _, k := range keys.Keys {
+				if k.KeyID == t.Header["kid"] {
+					return k.PublicKey()
+				}
+			}
+			return nil, fmt.Errorf("unknown kid %v", t.Header["kid"])
+		},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(clientID),
+		jwt.WithSubject(clientID),
+		jwt.WithAudience(p.issuer+"/token"),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		log.Printf("token: rejected client assertion: %v", err)
+		http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	value, _ := auth.GenerateSecureToken(16)
+	p.mu.Lock()
+	p.tokens[value] = time.Now().Add(time.Hour)
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": value,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"scope":        r.Form.Get("scope"),
+	})
+}
+
+func (p *platform) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		p.mu.Lock()
+		expiry, ok := p.tokens[value]
+		p.mu.Unlock()
+		if !ok || time.Now().After(expiry) {
+			http.Error(w, "invalid access token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (p *platform) listLineItems(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/vnd.ims.lis.v2.lineitemcontainer+json")
+	json.NewEncoder(w).Encode(p.lineItems)
+}
+
+func (p *platform) createLineItem(w http.ResponseWriter, r *http.Request) {
+	var item lti.LineItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil || item.Label == "" || item.ScoreMaximum <= 0 {
+		http.Error(w, "invalid line item", http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	item.ID = fmt.Sprintf("%s/lineitems/%d", p.issuer, len(p.lineItems)+1)
+	p.lineItems = append(p.lineItems, item)
+	p.mu.Unlock()
+	log.Printf("Line item created: %s (%s)", item.ID, item.Label)
+
+	w.Header().Set("Content-Type", "application/vnd.ims.lis.v2.lineitem+json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+func (p *platform) postScore(w http.ResponseWriter, r *http.Request) {
+	var score lti.Score
+	if err := json.NewDecoder(r.Body).Decode(&score); err != nil || score.UserID == "" {
+		http.Error(w, "invalid score", http.StatusBadRequest)
+		return
+	}
+	id := fmt.Sprintf("%s/lineitems/%s", p.issuer, r.PathValue("id"))
+	p.mu.Lock()
+	p.scores[id] = append(p.scores[id], score)
+	p.mu.Unlock()
+	log.Printf("Score for %s on %s: %.2f/%.0f (%s)", score.UserID, id, score.ScoreGiven, score.ScoreMaximum, score.Comment)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *platform) listScores(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.scores)
+}
@@ -0,0 +1,244 @@
+// File: cmd/orgctl/main.go
+
+// Command orgctl sets up organizations for platform operators. It reads the same configuration
+// as the API server.
+//
+//	go run ./cmd/orgctl create -slug cs -name "Computer Science" -domains cs.example.edu
+//	go run ./cmd/orgctl domains -slug cs -domains cs.example.edu,cse.example.edu
+//	go run ./cmd/orgctl admin -slug cs -email head@cs.example.edu
+//	go run ./cmd/orgctl operator -email ops@example.edu
+//	go run ./cmd/orgctl list
+//
+// "domains" replaces the email domains an organization claims; a domain routes new users with
+// that domain to the organization, so only operators assign them. "admin" makes an existing member of the organization one of its admins, who can then manage
+// the rest through the API. "operator" makes an existing user a platform administrator, with
+// access to /api/admin across all organizations; -revoke takes it away.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"backend/internal/config"
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	db, err := database.Connect(cfg.MongoURI, cfg.DB_Name)
+	if err != nil {
+		log.Fatalf("Could not connect to the database: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	switch cmd {
+	case "create":
+		err = create(ctx, db, args)
+	case "domains":
+		err = setDomains(ctx, db, args)
+	case "admin":
+		err = admin(ctx, db, args)
+	case "operator":
+		err = operator(ctx, db, args)
+	case "list":
+		err = list(ctx, db)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: orgctl create -slug SLUG -name NAME [-domains a.edu,b.edu] [-required-percent N]")
+	fmt.Fprintln(os.Stderr, "       orgctl domains -slug SLUG -domains a.edu,b.edu")
+	fmt.Fprintln(os.Stderr, "       orgctl admin -slug SLUG -email EMAIL")
+	fmt.Fprintln(os.Stderr, "       orgctl operator -email EMAIL [-revoke]")
+	fmt.Fprintln(os.Stderr, "       orgctl list")
+	os.Exit(2)
+}
+
+func create(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	slug := fs.String("slug", "", "short unique name users register with")
+	name := fs.String("name", "", "display name")
+	domains := fs.String("domains", "", "comma-separated email domains members must use; also routes new users here")
+	required := fs.Int("required-percent", 0, "default minimum attendance of new classes")
+	fs.Parse(args)
+	if *slug == "" || *name == "" {
+		return fmt.Errorf("-slug and -name are required")
+	}
+
+	org := database.Organization{
+		ID:   primitive.NewObjectID(),
+		Name: *name,
+		Slug: strings.ToLower(*slug),
+		Settings: database.OrganizationSettings{
+			RequiredPercent:     *required,
+			AllowedEmailDomains: []string{},
+		},
+		CreatedAt: time.Now(),
+	}
+	org.Settings.AllowedEmailDomains = parseDomains(*domains)
+	if len(org.Settings.AllowedEmailDomains) > 0 {
+		count, err := db.Collection("organizations").CountDocuments(ctx, bson.M{"settings.allowed_email_domains": bson.M{"$in": org.Settings.AllowedEmailDomains}})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("an email domain is already claimed by another organization")
+		}
+	}
+	if _, err := db.Collection("organizations").InsertOne(ctx, org); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("organization %q already exists or an email domain is already claimed", org.Slug)
+		}
+		return err
+	}
+	fmt.Printf("Created organization %s (%s)\n", org.Slug, org.ID.Hex())
+	return nil
+}
+
+func setDomains(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := flag.NewFlagSet("domains", flag.ExitOnError)
+	slug := fs.String("slug", "", "organization")
+	domains := fs.String("domains", "", "comma-separated email domains members must use; empty removes them all")
+	fs.Parse(args)
+	if *slug == "" {
+		return fmt.Errorf("-slug is required")
+	}
+
+	list := parseDomains(*domains)
+	result, err := db.Collection("organizations").UpdateOne(ctx,
+		bson.M{"slug": *slug},
+		bson.M{"$set": bson.M{"settings.allowed_email_domains": list}},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("an email domain is already claimed by another organization")
+		}
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no organization %q", *slug)
+	}
+	fmt.Printf("%s now claims domains %s\n", *slug, strings.Join(list, ","))
+	return nil
+}
+
+// parseDomains splits a comma-separated list of email domains, lower-cased and without
+// duplicates or a leading "@".
+func parseDomains(s string) []string {
+	domains := []string{}
+	for _, domain := range strings.Split(s, ",") {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain == "" {
+			continue
+		}
+		duplicate := false
+		for _, d := range domains {
+			duplicate = duplicate || d == domain
+		}
+		if !duplicate {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func admin(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	slug := fs.String("slug", "", "organization")
+	email := fs.String("email", "", "member to make an admin")
+	fs.Parse(args)
+	if *slug == "" || *email == "" {
+		return fmt.Errorf("-slug and -email are required")
+	}
+
+	var org database.Organization
+	if err := db.Collection("organizations").FindOne(ctx, bson.M{"slug": *slug}).Decode(&org); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("no organization %q", *slug)
+		}
+		return err
+	}
+	result, err := db.Collection("users").UpdateOne(ctx,
+		bson.M{"email": *email, "organization_id": org.ID},
+		bson.M{"$set": bson.M{"org_role": database.OrgRoleAdmin}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no member of %s with email %s", org.Slug, *email)
+	}
+	fmt.Printf("%s is now an admin of %s\n", *email, org.Slug)
+	return nil
+}
+
+func operator(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := flag.NewFlagSet("operator", flag.ExitOnError)
+	email := fs.String("email", "", "user to make a platform administrator")
+	revoke := fs.Bool("revoke", false, "remove platform administrator access instead")
+	fs.Parse(args)
+	if *email == "" {
+		return fmt.Errorf("-email is required")
+	}
+
+	update := bson.M{"$set": bson.M{"platform_admin": true}}
+	if *revoke {
+		update = bson.M{"$unset": bson.M{"platform_admin": ""}}
+	}
+	result, err := db.Collection("users").UpdateOne(ctx, bson.M{"email": *email}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no user with email %s", *email)
+	}
+	if *revoke {
+		fmt.Printf("%s is no longer a platform administrator\n", *email)
+	} else {
+		fmt.Printf("%s is now a platform administrator\n", *email)
+	}
+	return nil
+}
+
+func list(ctx context.Context, db *mongo.Database) error {
+	cursor, err := db.Collection("organizations").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var orgs []database.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		members, err := db.Collection("users").CountDocuments(ctx, bson.M{"organization_id": org.ID})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-16s %-32s members=%d domains=%s required=%d%%\n",
+			org.Slug, org.Name, members, strings.Join(org.Settings.AllowedEmailDomains, ","), org.Settings.RequiredPercent)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+// File: internal/auth/beacon.go
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// BeaconSignature is what a beacon emits at t (unix seconds) to prove a phone was near it then:
+// the hex HMAC-SHA256, keyed with the beacon's secret, of "<unix seconds>.<beacon ID>".
+func BeaconSignature(secret, beaconID string, t int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(t, 10)))
+	mac.Write([]byte("."))
+	mac.Write([]byte(beaconID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyBeaconSignature reports whether signature is the beacon's signature for t. Callers must
+// also check that t is recent.
+func VerifyBeaconSignature(secret, beaconID string, t int64, signature string) bool {
+	expected := BeaconSignature(secret, beaconID, t)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
@@ -11,16 +11,25 @@ import (
 // Claims struct now correctly includes the Name field
 type Claims struct {
 	UserID string `json:"user_id"`
-	Name   string `json:"name"` // The user's full name
+	Name   string `json:"name"`   // The user's full name
+	OrgID  string `json:"org_id"` // The organization the user belongs to
+
+	// Set on tokens a platform operator obtained to act as the user for support
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
+	// When the user signed in, to judge whether they did so recently enough for sensitive changes
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT function now correctly accepts the user's name
-func GenerateJWT(userID string, name string, jwtSecret string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateJWT issues the token a user receives when they sign in, with the user's name.
+func GenerateJWT(userID string, name string, orgID string, jwtSecret string) (string, error) {
+	now := time.Now()
+	expirationTime := now.Add(24 * time.Hour)
 	claims := &Claims{
-		UserID: userID,
-		Name:   name, // Set the name in the claims
+		UserID:   userID,
+		Name:     name, // Set the name in the claims
+		OrgID:    orgID,
+		AuthTime: jwt.NewNumericDate(now),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
@@ -28,3 +37,18 @@ func GenerateJWT(userID string, name string, jwtSecret string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(jwtSecret))
 }
+
+// GenerateImpersonationJWT issues a token acting as the user on behalf of an operator, valid for ttl.
+func GenerateImpersonationJWT(userID, name, orgID, impersonatorID, jwtSecret string, ttl time.Duration) (string, error) {
+	claims := &Claims{
+		UserID:         userID,
+		Name:           name,
+		OrgID:          orgID,
+		ImpersonatorID: impersonatorID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(jwtSecret))
+}
@@ -2,14 +2,26 @@
 
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"time"
+
+	"backend/internal/metrics"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 func HashPassword(password string) (string, error) {
+	defer observeBcrypt("hash", time.Now())
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	return string(bytes), err
 }
 
 func CheckPasswordHash(password, hash string) bool {
+	defer observeBcrypt("compare", time.Now())
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+func observeBcrypt(operation string, start time.Time) {
+	metrics.BcryptDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,38 @@
+// File: internal/auth/token.go
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+)
+
+// GenerateSecureToken creates a random, URL-safe string from length random bytes.
+func GenerateSecureToken(length int) (string, error) {
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// HashToken returns the SHA-256 digest of a token, so tokens can be stored without being usable.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GeneratePIN creates a random numeric code of the given number of digits, keeping leading zeros.
+func GeneratePIN(digits int) (string, error) {
+	pin := make([]byte, digits)
+	for i := range pin {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		pin[i] = byte('0' + n.Int64())
+	}
+	return string(pin), nil
+}
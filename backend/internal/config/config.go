@@ -1,8 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,6 +17,78 @@ type Config struct {
 	MongoURI   string
 	DB_Name    string
 	JWT_Secret string
+
+	LogLevel  slog.Level
+	LogFormat string // "json" or "text"
+
+	// Automatic session opening from class timetables. The scheduler runs its other jobs, such as
+	// purging deleted classes, whatever SchedulerEnabled says.
+	SchedulerEnabled  bool
+	SchedulerInterval time.Duration
+	AutoSessionWindow time.Duration
+
+	// Deadlines for MongoDB calls made while serving a request
+	DBQueryTimeout     time.Duration
+	DBAggregateTimeout time.Duration
+
+	// Device binding: each account may mark attendance from at most MaxDevicesPerUser devices
+	// (0 disables binding). RequireDeviceID rejects scans from clients that send no device ID.
+	MaxDevicesPerUser int
+	RequireDeviceID   bool
+
+	// Notifications: delivery runs when NotificationsEnabled. Email needs SMTPHost; push goes
+	// through Expo. NotificationSink "log" logs every notification instead of sending it.
+	NotificationsEnabled bool
+	NotificationSink     string
+	SMTPHost             string
+	SMTPPort             string
+	SMTPUsername         string
+	SMTPPassword         string
+	SMTPFrom             string
+	ExpoPushEnabled      bool
+	ExpoAccessToken      string
+
+	// WebhooksEnabled runs delivery of class events to registered webhooks.
+	WebhooksEnabled bool
+
+	// LTI 1.3: the tool is enabled when LTIPlatformsFile lists the registered LMS platforms.
+	// LTIPrivateKeyFile holds the PEM key grade service requests are signed with; without it a
+	// throwaway key is generated, which only suits development. PublicURL is this server's
+	// externally visible base URL; LTIRedirectURL the app page launches hand the token to.
+	LTIPlatformsFile  string
+	LTIPrivateKeyFile string
+	LTIRedirectURL    string
+	PublicURL         string
+
+	// OpenID Connect single sign-on is enabled when OIDCIssuer is set. OIDCRedirectURL is the
+	// callback registered with the provider (default PublicURL + "/auth/oidc/callback");
+	// OIDCAppRedirectURLs are the app pages a login may return to. OIDCJITProvisioning creates
+	// accounts for unknown users, otherwise only existing accounts can sign in. OIDCTrustEmail
+	// treats emails of ID tokens without an email_verified claim as verified.
+	OIDCIssuer          string
+	OIDCClientID        string
+	OIDCClientSecret    string
+	OIDCRedirectURL     string
+	OIDCScopes          []string
+	OIDCAppRedirectURLs []string
+	OIDCJITProvisioning bool
+	OIDCTrustEmail      bool
+
+	// PasswordLoginEnabled allows email and password registration and login; turn it off for
+	// deployments that sign in through SSO or an LMS only.
+	PasswordLoginEnabled bool
+
+	// AuditRetention is how long audit log entries are kept; 0 keeps them forever. A change
+	// applies to entries written afterwards.
+	AuditRetention time.Duration
+
+	// Take the client address from X-Forwarded-For / X-Real-IP; only enable behind a proxy that sets them
+	TrustProxyHeaders bool
+
+	// Graceful shutdown: readiness fails for ShutdownDelay before the listener closes,
+	// then in-flight requests get up to ShutdownTimeout to finish
+	ShutdownDelay   time.Duration
+	ShutdownTimeout time.Duration
 }
 
 func LoadConfig() (*Config, error) {
@@ -24,6 +101,95 @@ func LoadConfig() (*Config, error) {
 		MongoURI:   getEnv("MONGO_URI", ""),
 		DB_Name:    getEnv("DB_NAME", ""),
 		JWT_Secret: getEnv("JWT_SECRET", ""),
+
+		LogFormat: getEnv("LOG_FORMAT", "json"),
+
+		SchedulerEnabled: getEnv("SCHEDULER_ENABLED", "true") == "true",
+
+		RequireDeviceID: getEnv("REQUIRE_DEVICE_ID", "false") == "true",
+
+		TrustProxyHeaders: getEnv("TRUST_PROXY_HEADERS", "false") == "true",
+
+		NotificationsEnabled: getEnv("NOTIFICATIONS_ENABLED", "true") == "true",
+		NotificationSink:     getEnv("NOTIFICATION_SINK", ""),
+		SMTPHost:             getEnv("SMTP_HOST", ""),
+		SMTPPort:             getEnv("SMTP_PORT", "587"),
+		SMTPUsername:         getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:         getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:             getEnv("SMTP_FROM", ""),
+		ExpoPushEnabled:      getEnv("EXPO_PUSH_ENABLED", "true") == "true",
+		ExpoAccessToken:      getEnv("EXPO_ACCESS_TOKEN", ""),
+
+		WebhooksEnabled: getEnv("WEBHOOKS_ENABLED", "true") == "true",
+
+		LTIPlatformsFile:  getEnv("LTI_PLATFORMS_FILE", ""),
+		LTIPrivateKeyFile: getEnv("LTI_PRIVATE_KEY_FILE", ""),
+		LTIRedirectURL:    getEnv("LTI_REDIRECT_URL", ""),
+		PublicURL:         strings.TrimSuffix(getEnv("PUBLIC_URL", ""), "/"),
+
+		OIDCIssuer:          getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:        getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:    getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:     getEnv("OIDC_REDIRECT_URL", ""),
+		OIDCScopes:          strings.Fields(getEnv("OIDC_SCOPES", "openid email profile")),
+		OIDCAppRedirectURLs: strings.Fields(strings.ReplaceAll(getEnv("OIDC_APP_REDIRECT_URLS", ""), ",", " ")),
+		OIDCJITProvisioning: getEnv("OIDC_JIT_PROVISIONING", "true") == "true",
+		OIDCTrustEmail:      getEnv("OIDC_TRUST_EMAIL", "false") == "true",
+
+		PasswordLoginEnabled: getEnv("PASSWORD_LOGIN_ENABLED", "true") == "true",
+	}
+
+	var err error
+	if err = cfg.LogLevel.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+	if cfg.LogFormat != "json" && cfg.LogFormat != "text" {
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q, expected json or text", cfg.LogFormat)
+	}
+	if cfg.SchedulerInterval, err = time.ParseDuration(getEnv("SCHEDULER_INTERVAL", "30s")); err != nil {
+		return nil, fmt.Errorf("invalid SCHEDULER_INTERVAL: %w", err)
+	}
+	if cfg.MaxDevicesPerUser, err = strconv.Atoi(getEnv("MAX_DEVICES_PER_USER", "2")); err != nil || cfg.MaxDevicesPerUser < 0 {
+		return nil, fmt.Errorf("invalid MAX_DEVICES_PER_USER %q", getEnv("MAX_DEVICES_PER_USER", "2"))
+	}
+	auditRetentionDays, err := strconv.Atoi(getEnv("AUDIT_RETENTION_DAYS", "365"))
+	if err != nil || auditRetentionDays < 0 {
+		return nil, fmt.Errorf("invalid AUDIT_RETENTION_DAYS %q", getEnv("AUDIT_RETENTION_DAYS", "365"))
+	}
+	cfg.AuditRetention = time.Duration(auditRetentionDays) * 24 * time.Hour
+	if cfg.DBQueryTimeout, err = time.ParseDuration(getEnv("DB_QUERY_TIMEOUT", "5s")); err != nil {
+		return nil, fmt.Errorf("invalid DB_QUERY_TIMEOUT: %w", err)
+	}
+	if cfg.DBAggregateTimeout, err = time.ParseDuration(getEnv("DB_AGGREGATE_TIMEOUT", "15s")); err != nil {
+		return nil, fmt.Errorf("invalid DB_AGGREGATE_TIMEOUT: %w", err)
+	}
+	if cfg.ShutdownDelay, err = time.ParseDuration(getEnv("SHUTDOWN_DELAY", "5s")); err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_DELAY: %w", err)
+	}
+	if cfg.ShutdownTimeout, err = time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "15s")); err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+	}
+	if cfg.AutoSessionWindow, err = time.ParseDuration(getEnv("AUTO_SESSION_WINDOW", "15m")); err != nil {
+		return nil, fmt.Errorf("invalid AUTO_SESSION_WINDOW: %w", err)
+	}
+
+	if cfg.NotificationSink != "" && cfg.NotificationSink != "log" {
+		return nil, fmt.Errorf("invalid NOTIFICATION_SINK %q, expected log or empty", cfg.NotificationSink)
+	}
+	if cfg.SMTPHost != "" && cfg.SMTPFrom == "" {
+		return nil, fmt.Errorf("SMTP_FROM must be set when SMTP_HOST is")
+	}
+
+	if cfg.PublicURL == "" {
+		cfg.PublicURL = "http://localhost:" + cfg.ServerPort
+	}
+	if cfg.OIDCIssuer != "" {
+		if cfg.OIDCClientID == "" {
+			return nil, fmt.Errorf("OIDC_CLIENT_ID must be set when OIDC_ISSUER is")
+		}
+		if cfg.OIDCRedirectURL == "" {
+			cfg.OIDCRedirectURL = cfg.PublicURL + "/auth/oidc/callback"
+		}
 	}
 
 	if cfg.MongoURI == "" || cfg.DB_Name == "" || cfg.JWT_Secret == "" {
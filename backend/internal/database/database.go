@@ -4,10 +4,13 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"backend/internal/metrics"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -18,20 +21,187 @@ import (
 //        Data Models (Structs)
 // ==================================
 
+// PINFailureWindow is how long a mistyped attendance PIN counts against a student's attempts.
+const PINFailureWindow = 10 * time.Minute
+
+// ClassroomRestoreWindow is how long a deleted classroom can be restored before it is purged.
+const ClassroomRestoreWindow = 30 * 24 * time.Hour
+
+// WebhookDeliveryRetention is how long webhook deliveries stay in the log.
+const WebhookDeliveryRetention = 30 * 24 * time.Hour
+
+// LTILoginWindow is how long an LTI login may take between initiation and launch.
+const LTILoginWindow = 10 * time.Minute
+
+// OIDCLoginWindow is how long an OpenID Connect login may take between redirect and callback.
+const OIDCLoginWindow = 10 * time.Minute
+
+// IdentityLinkWindow is how long a user has to confirm linking an external account to their
+// account with its password.
+const IdentityLinkWindow = 15 * time.Minute
+
 type User struct {
-	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
-	Name         string               `bson:"name" json:"name"`
-	Email        string               `bson:"email" json:"email"`
-	Password     string               `bson:"password" json:"-"`
-	ClassroomIDs []primitive.ObjectID `bson:"classroom_ids" json:"classroomIds"`
+	ID                primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name              string               `bson:"name" json:"name"`
+	Email             string               `bson:"email" json:"email"`
+	Password          string               `bson:"password" json:"-"`
+	ClassroomIDs      []primitive.ObjectID `bson:"classroom_ids" json:"classroomIds"`
+	RollNumber        string               `bson:"roll_number,omitempty" json:"rollNumber"`
+	Department        string               `bson:"department,omitempty" json:"department"`
+	AvatarURL         string               `bson:"avatar_url,omitempty" json:"avatarUrl"`
+	Timezone          string               `bson:"timezone,omitempty" json:"timezone"`
+	EmailChange       *EmailChange         `bson:"email_change,omitempty" json:"-"`
+	Devices           []Device             `bson:"devices,omitempty" json:"devices"` // Devices allowed to mark attendance for this account
+	PushTokens        []string             `bson:"push_tokens,omitempty" json:"-"`
+	NotificationPrefs *NotificationPrefs   `bson:"notification_prefs,omitempty" json:"-"`
+	Identities        []Identity           `bson:"identities,omitempty" json:"-"` // External accounts the user signs in with
+	OrganizationID    primitive.ObjectID   `bson:"organization_id" json:"organizationId"`
+	OrgRole           string               `bson:"org_role,omitempty" json:"orgRole,omitempty"`
+	PlatformAdmin     bool                 `bson:"platform_admin,omitempty" json:"platformAdmin,omitempty"` // Operates the deployment through /api/admin
+	DisabledAt        *time.Time           `bson:"disabled_at,omitempty" json:"disabledAt,omitempty"`       // Disabled accounts cannot sign in or use their tokens
+	DisabledReason    string               `bson:"disabled_reason,omitempty" json:"disabledReason,omitempty"`
+}
+
+// Organization roles
+const (
+	OrgRoleAdmin = "admin" // Manages the organization's settings, members and webhooks
+)
+
+// Organization is a department or college sharing the deployment. It owns its users and
+// classrooms; nothing is visible across organizations.
+type Organization struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name      string               `bson:"name" json:"name"`
+	Slug      string               `bson:"slug" json:"slug"` // Chosen at registration
+	Settings  OrganizationSettings `bson:"settings" json:"settings"`
+	CreatedAt time.Time            `bson:"created_at" json:"createdAt"`
+}
+
+// OrganizationSettings holds options set by an organization's admins.
+type OrganizationSettings struct {
+	RequiredPercent     int      `bson:"required_percent" json:"requiredPercent"`          // Default minimum attendance of new classes; 0 means none
+	AllowedEmailDomains []string `bson:"allowed_email_domains" json:"allowedEmailDomains"` // Domains members' emails must be in; empty allows any
+}
+
+// Identity providers
+const (
+	ProviderLTI  = "lti"
+	ProviderOIDC = "oidc"
+)
+
+// Identity links an account of an external identity provider, such as an LMS or a single
+// sign-on provider, to a user.
+type Identity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Issuer   string    `bson:"issuer" json:"issuer"`
+	Subject  string    `bson:"subject" json:"subject"`
+	LinkedAt time.Time `bson:"linked_at" json:"linkedAt"`
+}
+
+// NotificationPrefs selects how a user is notified. Users without saved preferences get
+// email and push notifications for every event.
+type NotificationPrefs struct {
+	Channels    []string `bson:"channels" json:"channels"`
+	MutedEvents []string `bson:"muted_events" json:"mutedEvents"`
+	WebhookURL  string   `bson:"webhook_url,omitempty" json:"webhookUrl"`
+}
+
+// Device is a phone bound to an account the first time it marks attendance.
+type Device struct {
+	ID           string    `bson:"id" json:"id"`
+	RegisteredAt time.Time `bson:"registered_at" json:"registeredAt"`
+	LastSeenAt   time.Time `bson:"last_seen_at" json:"lastSeenAt"`
+}
+
+// EmailChange is a pending email address awaiting verification.
+type EmailChange struct {
+	NewEmail  string    `bson:"new_email"`
+	TokenHash string    `bson:"token_hash"` // SHA-256 of the token sent to the new address
+	ExpiresAt time.Time `bson:"expires_at"`
 }
 
 type Classroom struct {
-	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
-	Name         string               `bson:"name" json:"name"`
-	Code         string               `bson:"code" json:"code"`
-	InstructorID primitive.ObjectID   `bson:"instructor_id" json:"instructorId"`
-	StudentIDs   []primitive.ObjectID `bson:"student_ids" json:"studentIds"`
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name           string               `bson:"name" json:"name"`
+	Code           string               `bson:"code" json:"code"`
+	InstructorID   primitive.ObjectID   `bson:"instructor_id" json:"instructorId"`
+	StudentIDs     []primitive.ObjectID `bson:"student_ids" json:"studentIds"`
+	JoinedAt       map[string]time.Time `bson:"joined_at,omitempty" json:"-"` // When each student joined, by hex user ID; missing for students enrolled before it was recorded
+	Schedule       *ClassSchedule       `bson:"schedule,omitempty" json:"schedule,omitempty"`
+	TermID         *primitive.ObjectID  `bson:"term_id,omitempty" json:"termId,omitempty"`
+	ArchivedAt     *time.Time           `bson:"archived_at,omitempty" json:"archivedAt,omitempty"` // Archived classes are read-only
+	DeletedAt      *time.Time           `bson:"deleted_at,omitempty" json:"deletedAt,omitempty"`   // Soft-deleted classes can be restored for a while
+	Settings       ClassroomSettings    `bson:"settings" json:"settings"`
+	LTI            *LTILink             `bson:"lti,omitempty" json:"lti,omitempty"` // Set for classes created from an LMS course
+	OrganizationID primitive.ObjectID   `bson:"organization_id" json:"organizationId"`
+}
+
+// LTILink ties a classroom to an LMS course (an LTI context) and its gradebook column.
+type LTILink struct {
+	Issuer         string     `bson:"issuer" json:"issuer"`
+	ClientID       string     `bson:"client_id" json:"clientId"`
+	DeploymentID   string     `bson:"deployment_id" json:"deploymentId"`
+	ContextID      string     `bson:"context_id" json:"contextId"`
+	LineItemsURL   string     `bson:"line_items_url,omitempty" json:"-"`
+	LineItemURL    string     `bson:"line_item_url,omitempty" json:"-"`
+	GradesPushedAt *time.Time `bson:"grades_pushed_at,omitempty" json:"gradesPushedAt,omitempty"`
+}
+
+// ClassroomSettings holds instructor-controlled options. Zero values keep the default behaviour.
+type ClassroomSettings struct {
+	JoinLocked       bool      `bson:"join_locked" json:"joinLocked"`                // Reject new students joining by code
+	LateAfterMinutes int       `bson:"late_after_minutes" json:"lateAfterMinutes"`   // Scans this long after a lecture starts count as late; 0 disables
+	RequiredPercent  int       `bson:"required_percent" json:"requiredPercent"`      // Minimum attendance students must keep; 0 means none
+	Geofence         *Geofence `bson:"geofence,omitempty" json:"geofence,omitempty"` // Scans reported from outside it are flagged
+	Room             string    `bson:"room,omitempty" json:"room,omitempty"`         // Where the class meets; students check in with its beacons
+}
+
+// Geofence is a circle around the classroom.
+type Geofence struct {
+	Latitude     float64 `bson:"latitude" json:"latitude"`
+	Longitude    float64 `bson:"longitude" json:"longitude"`
+	RadiusMeters float64 `bson:"radius_meters" json:"radiusMeters"`
+}
+
+// GeoPoint is a location reported by the scanning phone.
+type GeoPoint struct {
+	Latitude       float64 `bson:"latitude" json:"latitude"`
+	Longitude      float64 `bson:"longitude" json:"longitude"`
+	AccuracyMeters float64 `bson:"accuracy_meters,omitempty" json:"accuracyMeters,omitempty"`
+}
+
+type Term struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name           string             `bson:"name" json:"name"`
+	Start          time.Time          `bson:"start" json:"start"`
+	End            time.Time          `bson:"end" json:"end"`
+	CreatedBy      primitive.ObjectID `bson:"created_by" json:"createdBy"`
+	OrganizationID primitive.ObjectID `bson:"organization_id" json:"organizationId"`
+}
+
+// ScheduleRule describes one weekly recurring meeting of a class.
+// StartTime and EndTime are wall-clock times ("15:04") in the schedule's timezone.
+type ScheduleRule struct {
+	Weekday   time.Weekday `bson:"weekday" json:"weekday"`
+	StartTime string       `bson:"start_time" json:"startTime"`
+	EndTime   string       `bson:"end_time" json:"endTime"`
+	Room      string       `bson:"room" json:"room"`
+}
+
+// ClassSchedule is the timetable of a classroom. Dates use the "2006-01-02" layout.
+type ClassSchedule struct {
+	Timezone  string         `bson:"timezone" json:"timezone"`
+	TermStart string         `bson:"term_start" json:"termStart"`
+	TermEnd   string         `bson:"term_end" json:"termEnd"`
+	Holidays  []string       `bson:"holidays" json:"holidays"`
+	Rules     []ScheduleRule `bson:"rules" json:"rules"`
+}
+
+// Meeting is a single concrete occurrence of a ScheduleRule.
+type Meeting struct {
+	Start time.Time `bson:"start" json:"start"`
+	End   time.Time `bson:"end" json:"end"`
+	Room  string    `bson:"room" json:"room"`
 }
 
 type AttendanceSession struct {
@@ -39,21 +209,114 @@ type AttendanceSession struct {
 	Token       string             `bson:"token"`
 	ClassroomID primitive.ObjectID `bson:"classroom_id"`
 	CreatedAt   time.Time          `bson:"created_at"`
+	ExpiresAt   time.Time          `bson:"expires_at"`        // Removed by the TTL index once passed
+	Meeting     *Meeting           `bson:"meeting,omitempty"` // Scheduled meeting the session was opened in, if any
+	Automatic   bool               `bson:"automatic,omitempty"`
+	LectureID   primitive.ObjectID `bson:"lecture_id,omitempty"`
+	LectureAt   time.Time          `bson:"lecture_started_at,omitempty"` // Copied from the lecture to judge lateness
+	PIN         string             `bson:"pin,omitempty"`                // Numeric code students can type instead of scanning
+	KioskID     primitive.ObjectID `bson:"kiosk_id,omitempty"`           // Kiosk that opened the session to display it
+}
+
+// Lecture is one held class meeting. QR sessions rotate every few seconds, so every session
+// opened for the same class in quick succession (or for the same scheduled meeting) belongs
+// to one lecture, which is what attendance percentages are counted against.
+type Lecture struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ClassroomID    primitive.ObjectID `bson:"classroom_id" json:"classroomId"`
+	StartedAt      time.Time          `bson:"started_at" json:"startedAt"`
+	LastActivityAt time.Time          `bson:"last_activity_at" json:"lastActivityAt"` // Last time a session was opened for it
+	Meeting        *Meeting           `bson:"meeting,omitempty" json:"meeting,omitempty"`
+	ClosedAt       *time.Time         `bson:"closed_at,omitempty" json:"closedAt,omitempty"` // Set once no further session can join it
+	// PreviousID is, for unscheduled lectures, the class's unscheduled lecture before this one, or
+	// the class's ID for its first. It is unique per class, so concurrent sessions start one lecture.
+	PreviousID *primitive.ObjectID `bson:"previous_id,omitempty" json:"-"`
+}
+
+// Webhook is an endpoint an instructor registered to receive a class's events.
+type Webhook struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ClassroomID    primitive.ObjectID `bson:"classroom_id,omitempty" json:"classroomId,omitempty"`       // Set for webhooks of one class
+	OrganizationID primitive.ObjectID `bson:"organization_id,omitempty" json:"organizationId,omitempty"` // Set for webhooks receiving every class of an organization
+	URL            string             `bson:"url" json:"url"`
+	Events         []string           `bson:"events" json:"events"`
+	Secret         string             `bson:"secret" json:"-"` // Signs payloads; only returned when the webhook is created
+	Active         bool               `bson:"active" json:"active"`
+	CreatedBy      primitive.ObjectID `bson:"created_by" json:"createdBy"`
+	CreatedAt      time.Time          `bson:"created_at" json:"createdAt"`
 }
 
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook. Payload holds the exact body,
+// so retries and replays send the same bytes.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	WebhookID      primitive.ObjectID  `bson:"webhook_id" json:"webhookId"`
+	ClassroomID    primitive.ObjectID  `bson:"classroom_id" json:"classroomId"`
+	EventID        primitive.ObjectID  `bson:"event_id" json:"eventId"` // Shared by a delivery and its replays
+	Event          string              `bson:"event" json:"event"`
+	Payload        string              `bson:"payload" json:"payload"`
+	Status         string              `bson:"status" json:"status"`
+	Attempts       int                 `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time           `bson:"next_attempt_at" json:"nextAttemptAt"`
+	LockedUntil    *time.Time          `bson:"locked_until,omitempty" json:"-"`
+	ResponseStatus int                 `bson:"response_status,omitempty" json:"responseStatus,omitempty"`
+	LastError      string              `bson:"last_error,omitempty" json:"lastError,omitempty"`
+	ReplayOf       *primitive.ObjectID `bson:"replay_of,omitempty" json:"replayOf,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"createdAt"`
+	DeliveredAt    *time.Time          `bson:"delivered_at,omitempty" json:"deliveredAt,omitempty"`
+}
+
+// Attendance record statuses. Records written before statuses existed have none and count as present.
+const (
+	StatusPresent = "present"
+	StatusLate    = "late"
+	StatusExcused = "excused"
+)
+
 type AttendanceRecord struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty"`
 	UserID      primitive.ObjectID `bson:"user_id"`
 	ClassroomID primitive.ObjectID `bson:"classroom_id"`
-	SessionID   primitive.ObjectID `bson:"session_id"` // NEW: Link to the session
+	SessionID   primitive.ObjectID `bson:"session_id,omitempty"` // Empty for excused absences
+	LectureID   primitive.ObjectID `bson:"lecture_id,omitempty"`
+	Status      string             `bson:"status,omitempty"`
+	DeviceID    string             `bson:"device_id,omitempty"`
+	IPAddress   string             `bson:"ip,omitempty"`
+	Location    *GeoPoint          `bson:"location,omitempty"`
+	Method      string             `bson:"method,omitempty"`    // How the mark was made; empty on excuses and records that predate methods (QR)
+	BeaconID    primitive.ObjectID `bson:"beacon_id,omitempty"` // Beacon observed, for MethodBeacon
+	Flags       []string           `bson:"flags,omitempty"`     // Reasons the mark looks suspicious, see FlagSharedDevice
 	Timestamp   time.Time          `bson:"timestamp"`
 }
 
+// Attendance record methods.
+const (
+	MethodQR       = "qr"
+	MethodPIN      = "pin"
+	MethodRollCall = "roll_call"
+	MethodBeacon   = "beacon"
+)
+
+// Attendance record flags.
+const (
+	FlagSharedDevice = "shared_device" // The same device marked attendance for another account in the lecture
+)
+
 type StudentAttendanceHistory struct {
 	ID            primitive.ObjectID `bson:"_id" json:"id"`
 	UserID        primitive.ObjectID `bson:"user_id" json:"userId"`
 	ClassroomID   primitive.ObjectID `bson:"classroom_id" json:"classroomId"`
 	Timestamp     time.Time          `bson:"timestamp" json:"timestamp"`
+	Status        string             `bson:"status" json:"status"`
+	Method        string             `bson:"method" json:"method"`
 	ClassroomInfo struct {
 		Name string `bson:"name" json:"subjectName"`
 		Code string `bson:"code" json:"subjectCode"`
@@ -65,17 +328,244 @@ type ClassAttendanceSummary struct {
 	Name          string             `bson:"name" json:"name"`
 	Email         string             `bson:"email" json:"email"`
 	AttendedCount int                `bson:"attendedCount" json:"attendedCount"`
+	FlaggedCount  int                `bson:"flaggedCount" json:"flaggedCount"` // Marks carrying a flag such as FlagSharedDevice
+	QRCount       int                `bson:"qrCount" json:"qrCount"`           // Attended counts by method
+	PINCount      int                `bson:"pinCount" json:"pinCount"`
+	RollCallCount int                `bson:"rollCallCount" json:"rollCallCount"`
+	BeaconCount   int                `bson:"beaconCount" json:"beaconCount"`
+}
+
+// RollCallEntry is one enrolled student on a lecture's roll call, with their mark if any.
+type RollCallEntry struct {
+	UserID     primitive.ObjectID `bson:"_id" json:"userId"`
+	Name       string             `bson:"name" json:"name"`
+	Email      string             `bson:"email" json:"email"`
+	RollNumber string             `bson:"roll_number" json:"rollNumber"`
+	Status     string             `bson:"status" json:"status"` // Empty when absent
+	Method     string             `bson:"method" json:"method"`
+}
+
+// SharedDeviceFlag lists the accounts one device marked attendance for in a single lecture.
+type SharedDeviceFlag struct {
+	LectureID primitive.ObjectID `bson:"lecture_id" json:"lectureId"`
+	StartedAt time.Time          `bson:"started_at" json:"startedAt"`
+	DeviceID  string             `bson:"device_id" json:"deviceId"`
+	Students  []struct {
+		UserID    primitive.ObjectID `bson:"user_id" json:"userId"`
+		Name      string             `bson:"name" json:"name"`
+		Email     string             `bson:"email" json:"email"`
+		Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+	} `bson:"students" json:"students"`
+}
+
+// StudentClassSummary is a student's attendance standing in one class, counted in lectures.
+type StudentClassSummary struct {
+	ClassroomID     primitive.ObjectID `json:"classroomId"`
+	Name            string             `json:"subjectName"`
+	Code            string             `json:"subjectCode"`
+	Held            int                `json:"held"`
+	Attended        int                `json:"attended"` // Present, late and excused
+	Late            int                `json:"late"`
+	Excused         int                `json:"excused"`
+	Percentage      *float64           `json:"percentage"` // Null until a lecture has been held
+	CurrentStreak   int                `json:"currentStreak"`
+	RequiredPercent int                `json:"requiredPercent"`
+	CanMiss         *int               `json:"canMiss"` // Null when the class has no requirement
+}
+
+// AttendanceTrendPoint is the attendance of one lecture, or of all lectures in one week.
+// Rate is attended over lectures times currently enrolled students; excused absences are not attended.
+type AttendanceTrendPoint struct {
+	Start     time.Time           `bson:"start" json:"start"`
+	LectureID *primitive.ObjectID `bson:"lecture_id,omitempty" json:"lectureId,omitempty"`
+	Lectures  int                 `bson:"lectures" json:"lectures"`
+	Attended  int                 `bson:"attended" json:"attended"`
+	Late      int                 `bson:"late" json:"late"`
+	Rate      float64             `bson:"-" json:"rate"`
+}
+
+// AttendanceHeatmapCell aggregates the lectures that started on one weekday (0 = Sunday) and hour.
+type AttendanceHeatmapCell struct {
+	Weekday  int     `bson:"weekday" json:"weekday"`
+	Hour     int     `bson:"hour" json:"hour"`
+	Lectures int     `bson:"lectures" json:"lectures"`
+	Attended int     `bson:"attended" json:"attended"`
+	Rate     float64 `bson:"-" json:"rate"`
+}
+
+// ArrivalBucket counts scans that arrived between FromMinutes (inclusive) and ToMinutes (exclusive)
+// after their lecture started. A nil bound is open-ended.
+type ArrivalBucket struct {
+	FromMinutes *int `json:"fromMinutes"`
+	ToMinutes   *int `json:"toMinutes"`
+	Count       int  `json:"count"`
+}
+
+// AuditEntry records who changed what. Before and After are snapshots of the changed fields.
+// Entries are only updated when a user deletes their account, which replaces their ID with a
+// pseudonym and drops their client details and account snapshots. They are removed by the TTL
+// index once ExpiresAt passes.
+type AuditEntry struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	At             time.Time           `bson:"at" json:"at"`
+	ActorID        primitive.ObjectID  `bson:"actor_id,omitempty" json:"actorId,omitempty"`
+	ImpersonatorID *primitive.ObjectID `bson:"impersonator_id,omitempty" json:"impersonatorId,omitempty"` // Operator acting as ActorID
+	APIKeyID       *primitive.ObjectID `bson:"api_key_id,omitempty" json:"apiKeyId,omitempty"`            // Set when ActorID acted through an API key
+	OrganizationID primitive.ObjectID  `bson:"organization_id,omitempty" json:"organizationId,omitempty"`
+	Action         string              `bson:"action" json:"action"`
+	TargetType     string              `bson:"target_type" json:"targetType"`
+	TargetID       primitive.ObjectID  `bson:"target_id" json:"targetId"`
+	ClassroomID    *primitive.ObjectID `bson:"classroom_id,omitempty" json:"classroomId,omitempty"` // Class the target belongs to, if any
+	IP             string              `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent      string              `bson:"user_agent,omitempty" json:"userAgent,omitempty"`
+	RequestID      string              `bson:"request_id,omitempty" json:"requestId,omitempty"`
+	Before         interface{}         `bson:"before,omitempty" json:"before,omitempty"`
+	After          interface{}         `bson:"after,omitempty" json:"after,omitempty"`
+	ExpiresAt      *time.Time          `bson:"expires_at,omitempty" json:"expiresAt,omitempty"` // Unset when entries are kept forever
+}
+
+// API key scopes. A key can only call the endpoints its scopes cover, and only as far as its
+// owner could.
+const (
+	ScopeClassesRead     = "classes:read"
+	ScopeAttendanceRead  = "attendance:read"
+	ScopeAttendanceWrite = "attendance:write"
+	ScopeSessionsWrite   = "sessions:write"
+)
+
+// APIScopes lists every scope a key may be granted.
+var APIScopes = []string{ScopeClassesRead, ScopeAttendanceRead, ScopeAttendanceWrite, ScopeSessionsWrite}
+
+// APIKey lets a machine client, such as a registrar's nightly sync, act as the user who created
+// it. Only a hash of the key is stored; Prefix is its public part, used to find it.
+type APIKey struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"userId"`
+	OrganizationID primitive.ObjectID `bson:"organization_id" json:"organizationId"`
+	Name           string             `bson:"name" json:"name"`
+	Prefix         string             `bson:"prefix" json:"prefix"`
+	KeyHash        string             `bson:"key_hash" json:"-"`
+	Scopes         []string           `bson:"scopes" json:"scopes"`
+	CreatedAt      time.Time          `bson:"created_at" json:"createdAt"`
+	ExpiresAt      *time.Time         `bson:"expires_at,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt     *time.Time         `bson:"last_used_at,omitempty" json:"lastUsedAt,omitempty"` // Updated at most once a minute
+	LastUsedIP     string             `bson:"last_used_ip,omitempty" json:"lastUsedIp,omitempty"`
+	RevokedAt      *time.Time         `bson:"revoked_at,omitempty" json:"revokedAt,omitempty"`
+}
+
+// KioskPairingTTL is how long a kiosk's pairing code works. Kiosks not paired by then are
+// removed, and must register again.
+const KioskPairingTTL = 10 * time.Minute
+
+// Kiosk is a fixed display, such as a tablet at a classroom door, that shows a class's rotating
+// QR code with no one signed in. It registers itself and shows a pairing code, which an
+// instructor enters to pair it with one of their classes. It authenticates with a credential
+// of which only a hash is stored; Prefix is its public part, used to find it.
+type Kiosk struct {
+	ID               primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Name             string              `bson:"name" json:"name"`
+	Prefix           string              `bson:"prefix" json:"-"`
+	CredentialHash   string              `bson:"credential_hash" json:"-"`
+	PairingCode      string              `bson:"pairing_code,omitempty" json:"-"`
+	PairingExpiresAt *time.Time          `bson:"pairing_expires_at,omitempty" json:"-"` // Removed by the TTL index once passed
+	ClassroomID      *primitive.ObjectID `bson:"classroom_id,omitempty" json:"classroomId,omitempty"`
+	OrganizationID   *primitive.ObjectID `bson:"organization_id,omitempty" json:"organizationId,omitempty"`
+	Room             string              `bson:"room,omitempty" json:"room,omitempty"`
+	PairedBy         *primitive.ObjectID `bson:"paired_by,omitempty" json:"pairedBy,omitempty"`
+	PairedAt         *time.Time          `bson:"paired_at,omitempty" json:"pairedAt,omitempty"`
+	CreatedAt        time.Time           `bson:"created_at" json:"createdAt"`
+	LastSeenAt       *time.Time          `bson:"last_seen_at,omitempty" json:"lastSeenAt,omitempty"` // Updated at most once a minute
+}
+
+// Beacon kinds.
+const (
+	BeaconBLE = "ble"
+	BeaconNFC = "nfc"
+)
+
+// Beacon is a BLE beacon or NFC tag fixed in a room. It signs what it emits with a secret it
+// shares with the server, so a phone can prove it was in the room (see auth.BeaconSignature).
+type Beacon struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID primitive.ObjectID `bson:"organization_id" json:"organizationId"`
+	BeaconID       string             `bson:"beacon_id" json:"beaconId"` // Identifier the hardware emits
+	Kind           string             `bson:"kind" json:"kind"`
+	Room           string             `bson:"room" json:"room"` // Matched against the room in class settings
+	Secret         string             `bson:"secret" json:"-"`  // Provisioned into the beacon; only returned when it is registered
+	CreatedBy      primitive.ObjectID `bson:"created_by" json:"createdBy"`
+	CreatedAt      time.Time          `bson:"created_at" json:"createdAt"`
+}
+
+// SystemStats summarizes the whole deployment for platform operators.
+type SystemStats struct {
+	Organizations int64 `json:"organizations"`
+	Users         struct {
+		Total    int64 `json:"total"`
+		Disabled int64 `json:"disabled"`
+	} `json:"users"`
+	Classrooms struct {
+		Active   int64 `json:"active"`
+		Archived int64 `json:"archived"`
+		Deleted  int64 `json:"deleted"`
+	} `json:"classrooms"`
+	Lectures          int64 `json:"lectures"`
+	OpenSessions      int64 `json:"openSessions"`
+	AttendanceRecords struct {
+		Total   int64 `json:"total"`
+		LastDay int64 `json:"lastDay"`
+	} `json:"attendanceRecords"`
+	PendingNotifications     int64 `json:"pendingNotifications"`
+	PendingWebhookDeliveries int64 `json:"pendingWebhookDeliveries"`
+	FailedWebhookDeliveries  int64 `json:"failedWebhookDeliveries"`
+}
+
+// ClassCohort compares one of an instructor's classes with the others.
+type ClassCohort struct {
+	ClassroomID primitive.ObjectID  `bson:"_id" json:"classroomId"`
+	Name        string              `bson:"name" json:"name"`
+	Code        string              `bson:"code" json:"code"`
+	TermID      *primitive.ObjectID `bson:"term_id,omitempty" json:"termId,omitempty"`
+	Students    int                 `bson:"students" json:"students"`
+	Lectures    int                 `bson:"lectures" json:"lectures"`
+	Attended    int                 `bson:"attended" json:"attended"`
+	Late        int                 `bson:"late" json:"late"`
+	Rate        float64             `bson:"-" json:"rate"`
 }
 
 // ==================================
 //       Database Connection
 // ==================================
 
+// RequiredIndexes lists, per collection, the indexes Connect creates and the API relies on.
+var RequiredIndexes = map[string][]string{
+	"attendance_sessions": {"expires_at_1", "classroom_id_1_meeting.start_1", "classroom_id_1_pin_1"},
+	"attendance_records":  {"user_id_1_lecture_id_1", "lecture_id_1_device_id_1"},
+	"lectures":            {"classroom_id_1_started_at_-1", "classroom_id_1_meeting.start_1", "classroom_id_1_previous_id_1", "last_activity_at_1"},
+	"pin_failures":        {"at_1"},
+	"notification_outbox": {"status_1_next_attempt_at_1", "sent_at_1"},
+	"at_risk":             {"classroom_id_1_user_id_1"},
+	"webhooks":            {"classroom_id_1", "organization_id_1"},
+	"webhook_deliveries":  {"status_1_next_attempt_at_1", "webhook_id_1__id_-1", "created_at_1"},
+	"users":               {"identities.issuer_1_identities.subject_1", "organization_id_1__id_1"},
+	"classrooms":          {"organization_id_1_code_1_deleted_at_1", "lti.issuer_1_lti.context_id_1"},
+	"lti_logins":          {"created_at_1"},
+	"oidc_logins":         {"created_at_1"},
+	"identity_links":      {"created_at_1"},
+	"organizations":       {"slug_1", "settings.allowed_email_domains_unique"},
+	"api_keys":            {"prefix_1", "user_id_1__id_-1"},
+	"kiosks":              {"prefix_1", "pairing_code_1", "pairing_expires_at_1", "classroom_id_1"},
+	"beacons":             {"organization_id_1_beacon_id_1", "organization_id_1_room_1"},
+	"audit_log":           {"expires_at_1", "actor_id_1__id_-1", "target_id_1__id_-1", "classroom_id_1__id_-1", "organization_id_1__id_-1"},
+}
+
 func Connect(uri, dbName string) (*mongo.Database, error) {
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-	clientOptions := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
+	clientOptions := options.Client().
+		ApplyURI(uri).
+		SetServerAPIOptions(serverAPI).
+		SetMonitor(metrics.MongoMonitor())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	client, err := mongo.Connect(ctx, clientOptions)
@@ -91,31 +581,368 @@ func Connect(uri, dbName string) (*mongo.Database, error) {
 	db := client.Database(dbName)
 
 	// --- Ensure TTL Index for Attendance Sessions ---
+	// Sessions carry their own expiry so scheduled sessions can stay open longer than QR sessions.
 	sessionsCollection := db.Collection("attendance_sessions")
+	if _, err := sessionsCollection.Indexes().DropOne(ctx, "created_at_1"); err != nil && !isIndexNotFound(err) {
+		return nil, fmt.Errorf("failed to drop legacy TTL index for attendance_sessions: %w", err)
+	}
 	ttlIndex := mongo.IndexModel{
-		Keys:    bson.D{{Key: "created_at", Value: 1}},
-		Options: options.Index().SetExpireAfterSeconds(60),
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
 	}
-	_, err = sessionsCollection.Indexes().CreateOne(context.Background(), ttlIndex)
+	_, err = sessionsCollection.Indexes().CreateOne(ctx, ttlIndex)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TTL index for attendance_sessions: %w", err)
 	}
 	log.Println("TTL index for 'attendance_sessions' collection ensured.")
 
-	// --- NEW: Ensure Unique Index for Attendance Records ---
+	// --- Ensure one automatic session per scheduled meeting ---
+	meetingIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "classroom_id", Value: 1},
+			{Key: "meeting.start", Value: 1},
+		},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"automatic": true}),
+	}
+	_, err = sessionsCollection.Indexes().CreateOne(ctx, meetingIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create meeting index for attendance_sessions: %w", err)
+	}
+
+	// --- Ensure Unique Index for Attendance Records ---
+	// One record per student and lecture. This replaces the per-session index, which let a
+	// student scan every rotated QR code of the same lecture, and which excused absences
+	// (recorded without a session) would collide on.
 	recordsCollection := db.Collection("attendance_records")
+	if _, err := recordsCollection.Indexes().DropOne(ctx, "user_id_1_session_id_1"); err != nil && !isIndexNotFound(err) {
+		return nil, fmt.Errorf("failed to drop legacy unique index for attendance_records: %w", err)
+	}
 	uniqueIndex := mongo.IndexModel{
 		Keys: bson.D{
 			{Key: "user_id", Value: 1},
-			{Key: "session_id", Value: 1},
+			{Key: "lecture_id", Value: 1},
 		},
-		Options: options.Index().SetUnique(true),
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"lecture_id": bson.M{"$exists": true}}),
 	}
-	_, err = recordsCollection.Indexes().CreateOne(context.Background(), uniqueIndex)
+	_, err = recordsCollection.Indexes().CreateOne(ctx, uniqueIndex)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create unique index for attendance_records: %w", err)
 	}
 	log.Println("Unique index for 'attendance_records' collection ensured.")
 
+	// --- Ensure Index for shared-device lookups ---
+	deviceIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "lecture_id", Value: 1},
+			{Key: "device_id", Value: 1},
+		},
+		Options: options.Index().
+			SetPartialFilterExpression(bson.M{"device_id": bson.M{"$exists": true}}),
+	}
+	_, err = recordsCollection.Indexes().CreateOne(ctx, deviceIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device index for attendance_records: %w", err)
+	}
+
+	// --- Ensure Index for PIN lookups ---
+	pinIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "classroom_id", Value: 1},
+			{Key: "pin", Value: 1},
+		},
+		Options: options.Index().
+			SetPartialFilterExpression(bson.M{"pin": bson.M{"$exists": true}}),
+	}
+	_, err = sessionsCollection.Indexes().CreateOne(ctx, pinIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PIN index for attendance_sessions: %w", err)
+	}
+
+	// --- Ensure TTL Index for failed PIN attempts ---
+	pinFailureIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(PINFailureWindow.Seconds())),
+	}
+	_, err = db.Collection("pin_failures").Indexes().CreateOne(ctx, pinFailureIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TTL index for pin_failures: %w", err)
+	}
+
+	// --- Ensure Indexes for the notification outbox ---
+	// Delivered messages are kept for a week for troubleshooting, failed ones until removed by hand
+	outboxDueIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "next_attempt_at", Value: 1},
+		},
+	}
+	outboxTTLIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "sent_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32((7 * 24 * time.Hour).Seconds())),
+	}
+	_, err = db.Collection("notification_outbox").Indexes().CreateMany(ctx, []mongo.IndexModel{outboxDueIndex, outboxTTLIndex})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes for notification_outbox: %w", err)
+	}
+
+	// --- Ensure one at-risk notification per student and class ---
+	atRiskIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "classroom_id", Value: 1},
+			{Key: "user_id", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = db.Collection("at_risk").Indexes().CreateOne(ctx, atRiskIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index for at_risk: %w", err)
+	}
+
+	// --- Ensure Indexes for webhooks and their delivery log ---
+	// The log is kept for 30 days, long enough to replay what an integration missed
+	webhookIndex := mongo.IndexModel{Keys: bson.D{{Key: "classroom_id", Value: 1}}}
+	orgWebhookIndex := mongo.IndexModel{Keys: bson.D{{Key: "organization_id", Value: 1}}}
+	_, err = db.Collection("webhooks").Indexes().CreateMany(ctx, []mongo.IndexModel{webhookIndex, orgWebhookIndex})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index for webhooks: %w", err)
+	}
+	deliveryDueIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "next_attempt_at", Value: 1},
+		},
+	}
+	deliveryLogIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "webhook_id", Value: 1},
+			{Key: "_id", Value: -1},
+		},
+	}
+	deliveryTTLIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(WebhookDeliveryRetention.Seconds())),
+	}
+	_, err = db.Collection("webhook_deliveries").Indexes().CreateMany(ctx, []mongo.IndexModel{deliveryDueIndex, deliveryLogIndex, deliveryTTLIndex})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes for webhook_deliveries: %w", err)
+	}
+
+	// --- Ensure Indexes for LTI ---
+	// An external account links to one user, and an LMS course to one classroom
+	identityIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "identities.issuer", Value: 1},
+			{Key: "identities.subject", Value: 1},
+		},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"identities": bson.M{"$exists": true}}),
+	}
+	_, err = db.Collection("users").Indexes().CreateOne(ctx, identityIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity index for users: %w", err)
+	}
+	// One live class per join code in an organization. Live classes have no deleted_at, so
+	// they all index it as null; deleted ones keep their code without blocking its reuse.
+	codeIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "organization_id", Value: 1},
+			{Key: "code", Value: 1},
+			{Key: "deleted_at", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = db.Collection("classrooms").Indexes().CreateOne(ctx, codeIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create code index for classrooms: %w", err)
+	}
+	contextIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "lti.issuer", Value: 1},
+			{Key: "lti.context_id", Value: 1},
+		},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"lti": bson.M{"$exists": true}}),
+	}
+	_, err = db.Collection("classrooms").Indexes().CreateOne(ctx, contextIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create LTI context index for classrooms: %w", err)
+	}
+	loginIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(LTILoginWindow.Seconds())),
+	}
+	_, err = db.Collection("lti_logins").Indexes().CreateOne(ctx, loginIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TTL index for lti_logins: %w", err)
+	}
+	oidcLoginIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(OIDCLoginWindow.Seconds())),
+	}
+	_, err = db.Collection("oidc_logins").Indexes().CreateOne(ctx, oidcLoginIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TTL index for oidc_logins: %w", err)
+	}
+	identityLinkIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(IdentityLinkWindow.Seconds())),
+	}
+	_, err = db.Collection("identity_links").Indexes().CreateOne(ctx, identityLinkIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TTL index for identity_links: %w", err)
+	}
+
+	// --- Ensure Index for Lectures ---
+	lectureIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "classroom_id", Value: 1},
+			{Key: "started_at", Value: -1},
+		},
+	}
+	meetingLectureIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "classroom_id", Value: 1},
+			{Key: "meeting.start", Value: 1},
+		},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"meeting": bson.M{"$exists": true}}),
+	}
+	// Lets only one of several concurrent sessions start a class's next unscheduled lecture
+	previousLectureIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "classroom_id", Value: 1},
+			{Key: "previous_id", Value: 1},
+		},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"previous_id": bson.M{"$exists": true}}),
+	}
+	// Finds lectures that have gone quiet, to close them
+	activityLectureIndex := mongo.IndexModel{Keys: bson.D{{Key: "last_activity_at", Value: 1}}}
+	_, err = db.Collection("lectures").Indexes().CreateMany(ctx, []mongo.IndexModel{lectureIndex, meetingLectureIndex, previousLectureIndex, activityLectureIndex})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes for lectures: %w", err)
+	}
+
+	// --- Ensure Indexes for Organizations ---
+	slugIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	// Finds the organization of a new user's email domain, which only one organization may claim.
+	// It replaces a non-unique index with the same keys. Organizations without domains are left
+	// out, since their empty lists would collide.
+	organizationsCollection := db.Collection("organizations")
+	if _, err := organizationsCollection.Indexes().DropOne(ctx, "settings.allowed_email_domains_1"); err != nil && !isIndexNotFound(err) {
+		return nil, fmt.Errorf("failed to drop legacy domain index for organizations: %w", err)
+	}
+	domainIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "settings.allowed_email_domains", Value: 1}},
+		Options: options.Index().
+			SetName("settings.allowed_email_domains_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"settings.allowed_email_domains": bson.M{"$type": "string"}}),
+	}
+	_, err = organizationsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{slugIndex, domainIndex})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes for organizations: %w", err)
+	}
+	// Lists an organization's members
+	memberIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "organization_id", Value: 1},
+			{Key: "_id", Value: 1},
+		},
+	}
+	_, err = db.Collection("users").Indexes().CreateOne(ctx, memberIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create organization index for users: %w", err)
+	}
+
+	// --- Ensure Indexes for the Audit Log ---
+	auditTTLIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	// Each query filter of the audit log, newest first
+	auditIndexes := []mongo.IndexModel{auditTTLIndex}
+	for _, field := range []string{"actor_id", "target_id", "classroom_id", "organization_id"} {
+		auditIndexes = append(auditIndexes, mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}, {Key: "_id", Value: -1}}})
+	}
+	_, err = db.Collection("audit_log").Indexes().CreateMany(ctx, auditIndexes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes for audit_log: %w", err)
+	}
+
+	// --- Ensure Indexes for API keys ---
+	prefixIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "prefix", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	ownerIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}},
+	}
+	_, err = db.Collection("api_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{prefixIndex, ownerIndex})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes for api_keys: %w", err)
+	}
+
+	// --- Ensure Indexes for kiosks ---
+	kioskPrefixIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "prefix", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	pairingCodeIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "pairing_code", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"pairing_code": bson.M{"$exists": true}}),
+	}
+	// Only unpaired kiosks carry a pairing expiry, so paired ones are never removed
+	pairingTTLIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "pairing_expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	kioskClassIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "classroom_id", Value: 1}},
+	}
+	_, err = db.Collection("kiosks").Indexes().CreateMany(ctx, []mongo.IndexModel{kioskPrefixIndex, pairingCodeIndex, pairingTTLIndex, kioskClassIndex})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes for kiosks: %w", err)
+	}
+
+	// --- Ensure Indexes for beacons ---
+	beaconIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "beacon_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	roomIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "room", Value: 1}},
+	}
+	_, err = db.Collection("beacons").Indexes().CreateMany(ctx, []mongo.IndexModel{beaconIndex, roomIndex})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes for beacons: %w", err)
+	}
+
+	if _, err := EnsureDefaultOrganization(ctx, db); err != nil {
+		return nil, fmt.Errorf("failed to set up the default organization: %w", err)
+	}
+
 	return db, nil
 }
+
+// isIndexNotFound reports whether err is MongoDB's IndexNotFound (or NamespaceNotFound for a new collection).
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == 27 || cmdErr.Code == 26
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+// File: internal/database/device.go
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BindDevice records that the user marked attendance from deviceID. A device that is already
+// bound only has its last-seen time refreshed; a new one is bound while the user has fewer than
+// maxDevices. It reports false when the device is unknown and the limit has been reached.
+func BindDevice(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, deviceID string, maxDevices int, now time.Time) (bool, error) {
+	usersCollection := db.Collection("users")
+
+	result, err := usersCollection.UpdateOne(ctx,
+		bson.M{"_id": userID, "devices.id": deviceID},
+		bson.M{"$set": bson.M{"devices.$.last_seen_at": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount > 0 {
+		return true, nil
+	}
+
+	// The size check and the push happen in one update so concurrent scans cannot overshoot the limit
+	result, err = usersCollection.UpdateOne(ctx,
+		bson.M{
+			"_id":        userID,
+			"devices.id": bson.M{"$ne": deviceID},
+			"$expr":      bson.M{"$lt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$devices", bson.A{}}}}, maxDevices}},
+		},
+		bson.M{"$push": bson.M{"devices": Device{ID: deviceID, RegisteredAt: now, LastSeenAt: now}}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount > 0 {
+		return true, nil
+	}
+
+	// A concurrent scan from the same device may have bound it in between
+	count, err := usersCollection.CountDocuments(ctx, bson.M{"_id": userID, "devices.id": deviceID})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
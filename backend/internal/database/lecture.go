@@ -0,0 +1,98 @@
+// File: internal/database/lecture.go
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LectureGap is how long after the last session of an unscheduled lecture a new session
+// still continues that lecture instead of starting a new one.
+const LectureGap = 15 * time.Minute
+
+// LectureCloseLookback bounds how long ago a lecture may have gone quiet to still be closed.
+// Older lectures without ClosedAt predate closing and count as held.
+const LectureCloseLookback = 24 * time.Hour
+
+// Held reports whether the lecture is over and counts towards attendance: it was closed, or
+// went quiet before lectures were closed.
+func (l *Lecture) Held(now time.Time) bool {
+	return l.ClosedAt != nil || l.LastActivityAt.Before(now.Add(-LectureCloseLookback))
+}
+
+// OpenLecture returns the lecture a session opened now belongs to, creating it if needed, and
+// whether it was created. Sessions inside a scheduled meeting share the meeting's lecture; other
+// sessions continue the class's most recent unscheduled lecture if it was active within LectureGap.
+func OpenLecture(ctx context.Context, db *mongo.Database, classroomID primitive.ObjectID, meeting *Meeting, now time.Time) (*Lecture, bool, error) {
+	lecturesCollection := db.Collection("lectures")
+
+	filter := bson.M{
+		"classroom_id":     classroomID,
+		"meeting":          bson.M{"$exists": false},
+		"last_activity_at": bson.M{"$gte": now.Add(-LectureGap)},
+	}
+	if meeting != nil {
+		filter = bson.M{"classroom_id": classroomID, "meeting.start": meeting.Start}
+	}
+
+	var lecture Lecture
+	err := lecturesCollection.FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.M{"$set": bson.M{"last_activity_at": now}},
+		options.FindOneAndUpdate().
+			SetSort(bson.M{"started_at": -1}).
+			SetReturnDocument(options.After),
+	).Decode(&lecture)
+	if err == nil {
+		return &lecture, false, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, false, err
+	}
+
+	lecture = Lecture{
+		ID:             primitive.NewObjectID(),
+		ClassroomID:    classroomID,
+		StartedAt:      now,
+		LastActivityAt: now,
+		Meeting:        meeting,
+	}
+	if meeting != nil {
+		lecture.StartedAt = meeting.Start
+	} else {
+		var previous Lecture
+		err := lecturesCollection.FindOne(
+			ctx,
+			bson.M{"classroom_id": classroomID, "meeting": bson.M{"$exists": false}},
+			options.FindOne().
+				SetSort(bson.M{"started_at": -1}).
+				SetProjection(bson.M{"last_activity_at": 1}),
+		).Decode(&previous)
+		switch {
+		case err == mongo.ErrNoDocuments:
+			lecture.PreviousID = &classroomID
+		case err != nil:
+			return nil, false, err
+		case !previous.LastActivityAt.Before(now.Add(-LectureGap)):
+			// Another session started a lecture since the lookup above; join it
+			return OpenLecture(ctx, db, classroomID, meeting, now)
+		default:
+			lecture.PreviousID = &previous.ID
+		}
+	}
+	if _, err := lecturesCollection.InsertOne(ctx, lecture); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Another session opened the lecture concurrently; join it
+			return OpenLecture(ctx, db, classroomID, meeting, now)
+		}
+		return nil, false, err
+	}
+	return &lecture, true, nil
+}
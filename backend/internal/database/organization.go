@@ -0,0 +1,87 @@
+// File: internal/database/organization.go
+
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultOrganizationSlug names the organization that owns everything created before
+// organizations existed, and users whose email domain no organization claims.
+const DefaultOrganizationSlug = "default"
+
+// EnsureDefaultOrganization creates the default organization if needed and moves users,
+// classrooms and terms that belong to no organization into it.
+func EnsureDefaultOrganization(ctx context.Context, db *mongo.Database) (*Organization, error) {
+	var org Organization
+	err := db.Collection("organizations").FindOneAndUpdate(ctx,
+		bson.M{"slug": DefaultOrganizationSlug},
+		bson.M{"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"name":       "Default",
+			"settings":   OrganizationSettings{AllowedEmailDomains: []string{}},
+			"created_at": time.Now(),
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&org)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, collection := range []string{"users", "classrooms", "terms"} {
+		_, err := db.Collection(collection).UpdateMany(ctx,
+			bson.M{"organization_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"organization_id": org.ID}},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &org, nil
+}
+
+// OrganizationForEmail finds the organization claiming the email's domain, falling back to
+// the default organization.
+func OrganizationForEmail(ctx context.Context, db *mongo.Database, email string) (*Organization, error) {
+	organizationsCollection := db.Collection("organizations")
+
+	var org Organization
+	if domain := EmailDomain(email); domain != "" {
+		err := organizationsCollection.FindOne(ctx, bson.M{"settings.allowed_email_domains": domain}).Decode(&org)
+		if err != mongo.ErrNoDocuments {
+			return &org, err
+		}
+	}
+	err := organizationsCollection.FindOne(ctx, bson.M{"slug": DefaultOrganizationSlug}).Decode(&org)
+	return &org, err
+}
+
+// AllowsEmail reports whether an address may belong to a member of the organization.
+func (o *Organization) AllowsEmail(email string) bool {
+	if len(o.Settings.AllowedEmailDomains) == 0 {
+		return true
+	}
+	domain := EmailDomain(email)
+	for _, allowed := range o.Settings.AllowedEmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// EmailDomain returns the lower-cased domain of an email address, or "" if it has none.
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
@@ -0,0 +1,117 @@
+// File: internal/database/summary.go
+
+package database
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SummarizeAttendance computes a student's standing in a class. lectures must be the class's
+// lectures, newest first; statusByLecture maps the lectures the student has a record for to the
+// record's status. Only lectures held by now count, and of those only the ones started after the
+// student joined or that they have a record for.
+func SummarizeAttendance(classroom Classroom, studentID primitive.ObjectID, lectures []Lecture, statusByLecture map[primitive.ObjectID]string, now time.Time) StudentClassSummary {
+	summary := StudentClassSummary{
+		ClassroomID:     classroom.ID,
+		Name:            classroom.Name,
+		Code:            classroom.Code,
+		RequiredPercent: classroom.Settings.RequiredPercent,
+	}
+	joinedAt, joinKnown := classroom.JoinedAt[studentID.Hex()]
+	streakBroken := false
+	for _, lecture := range lectures {
+		status, attended := statusByLecture[lecture.ID]
+		if !lecture.Held(now) || (!attended && joinKnown && lecture.StartedAt.Before(joinedAt)) {
+			continue
+		}
+		summary.Held++
+		if !attended {
+			streakBroken = true
+			continue
+		}
+		summary.Attended++
+		if !streakBroken {
+			summary.CurrentStreak++
+		}
+		switch status {
+		case StatusLate:
+			summary.Late++
+		case StatusExcused:
+			summary.Excused++
+		}
+	}
+
+	if summary.Held > 0 {
+		percentage := math.Round(float64(summary.Attended)*10000/float64(summary.Held)) / 100
+		summary.Percentage = &percentage
+	}
+	if required := classroom.Settings.RequiredPercent; required > 0 {
+		// Largest k with attended / (held + k) >= required%
+		canMiss := summary.Attended*100/required - summary.Held
+		if canMiss < 0 {
+			canMiss = 0
+		}
+		summary.CanMiss = &canMiss
+	}
+	return summary
+}
+
+// AtRisk reports whether the student has fallen under the class's required percentage.
+func (s StudentClassSummary) AtRisk() bool {
+	return s.RequiredPercent > 0 && s.Percentage != nil && *s.Percentage < float64(s.RequiredPercent)
+}
+
+// SummarizeClass computes the standing of every student enrolled in a class, by user ID, over
+// the lectures held up to now. It returns nil when the class has started no lecture yet.
+func SummarizeClass(ctx context.Context, db *mongo.Database, classroom Classroom, now time.Time) (map[primitive.ObjectID]StudentClassSummary, error) {
+	// Newest first, as SummarizeAttendance expects
+	cursor, err := db.Collection("lectures").Find(ctx,
+		bson.M{"classroom_id": classroom.ID, "started_at": bson.M{"$lte": now}},
+		options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var lectures []Lecture
+	if err := cursor.All(ctx, &lectures); err != nil {
+		return nil, err
+	}
+	if len(lectures) == 0 {
+		return nil, nil
+	}
+
+	cursor, err = db.Collection("attendance_records").Find(ctx,
+		bson.M{"classroom_id": classroom.ID, "lecture_id": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"user_id": 1, "lecture_id": 1, "status": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var records []AttendanceRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	statusByStudent := map[primitive.ObjectID]map[primitive.ObjectID]string{}
+	for _, record := range records {
+		if statusByStudent[record.UserID] == nil {
+			statusByStudent[record.UserID] = map[primitive.ObjectID]string{}
+		}
+		statusByStudent[record.UserID][record.LectureID] = record.Status
+	}
+
+	summaries := make(map[primitive.ObjectID]StudentClassSummary, len(classroom.StudentIDs))
+	for _, studentID := range classroom.StudentIDs {
+		if studentID == classroom.InstructorID {
+			continue
+		}
+		summaries[studentID] = SummarizeAttendance(classroom, studentID, lectures, statusByStudent[studentID], now)
+	}
+	return summaries, nil
+}
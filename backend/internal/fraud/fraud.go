@@ -0,0 +1,236 @@
+// File: internal/fraud/fraud.go
+
+// Package fraud looks for abuse patterns in attendance records: several accounts scanning from
+// one network address or device, scans landing almost simultaneously, scans from outside the
+// classroom's geofence, and students marked in two classrooms at once.
+package fraud
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of findings.
+const (
+	KindSharedIP          = "shared_ip"
+	KindSharedDevice      = "shared_device"
+	KindBurst             = "burst"
+	KindOutsideGeofence   = "outside_geofence"
+	KindConcurrentClasses = "concurrent_classes"
+)
+
+// Finding is one suspicious pattern, involving the listed records of one lecture.
+type Finding struct {
+	Kind      string               `json:"kind"`
+	LectureID primitive.ObjectID   `json:"lectureId"`
+	At        time.Time            `json:"at"` // Time of the earliest record involved
+	UserIDs   []primitive.ObjectID `json:"userIds"`
+	RecordIDs []primitive.ObjectID `json:"recordIds"`
+	Detail    string               `json:"detail"`
+}
+
+// Options tune the analysis. The zero value of a field falls back to DefaultOptions.
+type Options struct {
+	// Scans of different accounts closer together than this are reported as a burst
+	BurstWindow time.Duration
+	// An address shared by more accounts than this is taken to be a campus NAT and not reported
+	MaxSharedIPUsers int
+	// Marks in another classroom closer than this to a mark in the analysed one are concurrent
+	ConcurrencyWindow time.Duration
+	// Classroom geofence; nil skips the location check
+	Geofence *database.Geofence
+}
+
+// DefaultOptions are used for fields left zero in Options.
+var DefaultOptions = Options{
+	BurstWindow:       250 * time.Millisecond,
+	MaxSharedIPUsers:  3,
+	ConcurrencyWindow: 10 * time.Minute,
+}
+
+// Analyze inspects the records of one classroom. others holds the same students' records in other
+// classrooms around the same time, used for the concurrent-classes check. Findings are returned
+// ordered by time.
+func Analyze(records, others []database.AttendanceRecord, opts Options) []Finding {
+	if opts.BurstWindow == 0 {
+		opts.BurstWindow = DefaultOptions.BurstWindow
+	}
+	if opts.MaxSharedIPUsers == 0 {
+		opts.MaxSharedIPUsers = DefaultOptions.MaxSharedIPUsers
+	}
+	if opts.ConcurrencyWindow == 0 {
+		opts.ConcurrencyWindow = DefaultOptions.ConcurrencyWindow
+	}
+
+	sorted := make([]database.AttendanceRecord, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	var findings []Finding
+	findings = append(findings, sharedKey(sorted, KindSharedDevice, 0, func(r database.AttendanceRecord) string { return r.DeviceID })...)
+	findings = append(findings, sharedKey(sorted, KindSharedIP, opts.MaxSharedIPUsers, func(r database.AttendanceRecord) string { return r.IPAddress })...)
+	findings = append(findings, bursts(sorted, opts.BurstWindow)...)
+	if opts.Geofence != nil {
+		findings = append(findings, outsideGeofence(sorted, *opts.Geofence)...)
+	}
+	findings = append(findings, concurrent(sorted, others, opts.ConcurrencyWindow)...)
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].At.Before(findings[j].At) })
+	return findings
+}
+
+// sharedKey reports, per lecture, key values (device or address) used by more than one account.
+// Groups with more than maxUsers accounts are skipped when maxUsers is positive.
+func sharedKey(records []database.AttendanceRecord, kind string, maxUsers int, key func(database.AttendanceRecord) string) []Finding {
+	type groupKey struct {
+		lecture primitive.ObjectID
+		value   string
+	}
+	groups := map[groupKey][]database.AttendanceRecord{}
+	var order []groupKey
+	for _, record := range records {
+		value := key(record)
+		if value == "" || record.LectureID.IsZero() {
+			continue
+		}
+		k := groupKey{record.LectureID, value}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], record)
+	}
+
+	var findings []Finding
+	for _, k := range order {
+		group := groups[k]
+		users := distinctUsers(group)
+		if len(users) < 2 || (maxUsers > 0 && len(users) > maxUsers) {
+			continue
+		}
+		findings = append(findings, newFinding(kind, group, fmt.Sprintf("%d accounts scanned from %s", len(users), k.value)))
+	}
+	return findings
+}
+
+// bursts reports runs of scans by different accounts in the same lecture, each within window of the previous one.
+func bursts(records []database.AttendanceRecord, window time.Duration) []Finding {
+	byLecture := map[primitive.ObjectID][]database.AttendanceRecord{}
+	var order []primitive.ObjectID
+	for _, record := range records {
+		if record.LectureID.IsZero() {
+			continue
+		}
+		if _, ok := byLecture[record.LectureID]; !ok {
+			order = append(order, record.LectureID)
+		}
+		byLecture[record.LectureID] = append(byLecture[record.LectureID], record)
+	}
+
+	var findings []Finding
+	for _, lectureID := range order {
+		lecture := byLecture[lectureID]
+		start := 0
+		for i := 1; i <= len(lecture); i++ {
+			if i < len(lecture) && lecture[i].Timestamp.Sub(lecture[i-1].Timestamp) < window {
+				continue
+			}
+			run := lecture[start:i]
+			if users := distinctUsers(run); len(users) > 1 {
+				spread := run[len(run)-1].Timestamp.Sub(run[0].Timestamp)
+				findings = append(findings, newFinding(KindBurst, run, fmt.Sprintf("%d accounts scanned within %s", len(users), spread)))
+			}
+			start = i
+		}
+	}
+	return findings
+}
+
+// outsideGeofence reports scans whose location, allowing for its accuracy, lies outside the fence.
+func outsideGeofence(records []database.AttendanceRecord, fence database.Geofence) []Finding {
+	var findings []Finding
+	for _, record := range records {
+		if record.Location == nil {
+			continue
+		}
+		distance := DistanceMeters(record.Location.Latitude, record.Location.Longitude, fence.Latitude, fence.Longitude)
+		if distance-record.Location.AccuracyMeters > fence.RadiusMeters {
+			findings = append(findings, newFinding(KindOutsideGeofence, []database.AttendanceRecord{record},
+				fmt.Sprintf("scanned %.0f m from the classroom (radius %.0f m)", distance, fence.RadiusMeters)))
+		}
+	}
+	return findings
+}
+
+// concurrent reports marks of a student that another classroom also recorded within window.
+func concurrent(records, others []database.AttendanceRecord, window time.Duration) []Finding {
+	byUser := map[primitive.ObjectID][]database.AttendanceRecord{}
+	for _, other := range others {
+		byUser[other.UserID] = append(byUser[other.UserID], other)
+	}
+
+	var findings []Finding
+	for _, record := range records {
+		if record.Status == database.StatusExcused {
+			continue
+		}
+		for _, other := range byUser[record.UserID] {
+			if other.ClassroomID == record.ClassroomID || other.Status == database.StatusExcused {
+				continue
+			}
+			gap := record.Timestamp.Sub(other.Timestamp)
+			if gap < 0 {
+				gap = -gap
+			}
+			if gap < window {
+				finding := newFinding(KindConcurrentClasses, []database.AttendanceRecord{record},
+					fmt.Sprintf("also marked in classroom %s %s apart", other.ClassroomID.Hex(), gap.Round(time.Second)))
+				finding.RecordIDs = append(finding.RecordIDs, other.ID)
+				findings = append(findings, finding)
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// DistanceMeters is the great-circle distance between two coordinates.
+func DistanceMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadius = 6371000
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func newFinding(kind string, records []database.AttendanceRecord, detail string) Finding {
+	finding := Finding{
+		Kind:      kind,
+		LectureID: records[0].LectureID,
+		At:        records[0].Timestamp,
+		UserIDs:   distinctUsers(records),
+		Detail:    detail,
+	}
+	for _, record := range records {
+		finding.RecordIDs = append(finding.RecordIDs, record.ID)
+	}
+	return finding
+}
+
+func distinctUsers(records []database.AttendanceRecord) []primitive.ObjectID {
+	seen := map[primitive.ObjectID]bool{}
+	var users []primitive.ObjectID
+	for _, record := range records {
+		if !seen[record.UserID] {
+			seen[record.UserID] = true
+			users = append(users, record.UserID)
+		}
+	}
+	return users
+}
@@ -0,0 +1,518 @@
+// File: internal/handler/admin.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// impersonationTTL is how long a support session started with ImpersonateUser lasts.
+const impersonationTTL = time.Hour
+
+// adminUserID parses the "userID" URL parameter, writing the error response if it is invalid.
+func adminUserID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
+
+// adminOrgFilter adds the optional "org" query parameter, an organization ID, to filter.
+func adminOrgFilter(w http.ResponseWriter, r *http.Request, filter bson.M) bool {
+	orgIDHex := r.URL.Query().Get("org")
+	if orgIDHex == "" {
+		return true
+	}
+	orgID, err := primitive.ObjectIDFromHex(orgIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid organization ID"}`, http.StatusBadRequest)
+		return false
+	}
+	filter["organization_id"] = orgID
+	return true
+}
+
+// AdminSearchUsers returns a page of users across all organizations. "q" filters by a name or
+// email prefix, "org" by organization ID and "status" by active or disabled; results are sorted
+// by "sort" (created or name) and paginated with "limit"/"cursor".
+func (h *APIHandler) AdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	page, err := parseListParams(r, map[string]string{"created": "_id", "name": "name"}, "created")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{}
+	if !adminOrgFilter(w, r, filter) {
+		return
+	}
+	switch r.URL.Query().Get("status") {
+	case "":
+	case "active":
+		filter["disabled_at"] = bson.M{"$exists": false}
+	case "disabled":
+		filter["disabled_at"] = bson.M{"$exists": true}
+	default:
+		http.Error(w, `{"error": "status must be active or disabled"}`, http.StatusBadRequest)
+		return
+	}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"name": prefix}, bson.M{"email": prefix}}
+	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		filter["$and"] = bson.A{keyset}
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	cursor, err := h.DB.Collection("users").Find(ctx, filter, options.Find().SetSort(page.sort()).SetLimit(int64(page.Limit+1)))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch users", err)
+		return
+	}
+	users := []database.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		h.internalError(w, r, "Failed to decode users", err)
+		return
+	}
+	users = paginate(w, r, page, users, func(u database.User) (interface{}, primitive.ObjectID) {
+		if page.SortField == "name" {
+			return u.Name, u.ID
+		}
+		return u.ID, u.ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// AdminGetUser returns any user's account.
+func (h *APIHandler) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var user database.User
+	if err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// AdminDisableUser disables an account with an optional reason. Its tokens stop working at
+// once and it can no longer sign in. Operators cannot disable themselves.
+func (h *APIHandler) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	operatorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID.Hex() == operatorIDHex {
+		http.Error(w, `{"error": "You cannot disable your own account"}`, http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	now := time.Now()
+	reason := strings.TrimSpace(req.Reason)
+	result, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "disabled_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"disabled_at": now, "disabled_reason": reason}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to disable user", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		h.userNotFoundOr(ctx, w, r, userID, `{"error": "User is already disabled"}`)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionUserDisabled,
+		TargetType: targetUser,
+		TargetID:   userID,
+		After:      bson.M{"disabled_at": now, "disabled_reason": reason},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "User disabled"})
+}
+
+// AdminEnableUser lets a disabled account sign in again.
+func (h *APIHandler) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var before database.User
+	err := h.DB.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": userID, "disabled_at": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"disabled_at": "", "disabled_reason": ""}},
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		h.userNotFoundOr(ctx, w, r, userID, `{"error": "User is not disabled"}`)
+		return
+	}
+	if err != nil {
+		h.internalError(w, r, "Failed to enable user", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionUserEnabled,
+		TargetType: targetUser,
+		TargetID:   userID,
+		Before:     bson.M{"disabled_at": before.DisabledAt, "disabled_reason": before.DisabledReason},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "User enabled"})
+}
+
+// AdminResetPassword replaces a user's password with a generated one, returned only in this
+// response so the operator can pass it on. The user should change it after signing in.
+func (h *APIHandler) AdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	password, err := auth.GenerateSecureToken(9)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate password", err)
+		return
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		h.internalError(w, r, "Failed to hash password", err)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	result, err := h.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": hashedPassword}})
+	if err != nil {
+		h.internalError(w, r, "Failed to update password", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{Action: actionUserPasswordReset, TargetType: targetUser, TargetID: userID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"temporaryPassword": password})
+}
+
+// AdminImpersonateUser issues a short-lived token acting as a user, for support. A reason is
+// required and recorded in the audit log before the token is issued; requests made with the
+// token are logged with the operator's ID. Operators and disabled accounts cannot be
+// impersonated.
+func (h *APIHandler) AdminImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	operatorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, `{"error": "reason is required"}`, http.StatusBadRequest)
+		return
+	}
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var user database.User
+	if err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if user.PlatformAdmin {
+		http.Error(w, `{"error": "Platform administrators cannot be impersonated"}`, http.StatusForbidden)
+		return
+	}
+	if user.DisabledAt != nil {
+		http.Error(w, `{"error": "Disabled accounts cannot be impersonated"}`, http.StatusConflict)
+		return
+	}
+
+	expiresAt := time.Now().Add(impersonationTTL)
+	// No token without a record of who asked for it and why
+	err := h.recordAudit(ctx, r, database.AuditEntry{
+		Action:     actionUserImpersonated,
+		TargetType: targetUser,
+		TargetID:   user.ID,
+		After:      bson.M{"reason": strings.TrimSpace(req.Reason), "expires_at": expiresAt},
+	})
+	if err != nil {
+		h.internalError(w, r, "Failed to write audit log", err)
+		return
+	}
+	token, err := auth.GenerateImpersonationJWT(user.ID.Hex(), user.Name, user.OrganizationID.Hex(), operatorIDHex, h.JWT_Secret, impersonationTTL)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate token", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"token": token, "expiresAt": expiresAt})
+}
+
+// userNotFoundOr writes 404 if the user does not exist, or else the given conflict, for
+// conditional updates that matched nothing.
+func (h *APIHandler) userNotFoundOr(ctx context.Context, w http.ResponseWriter, r *http.Request, userID primitive.ObjectID, conflict string) {
+	count, err := h.DB.Collection("users").CountDocuments(ctx, bson.M{"_id": userID})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count == 0 {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	http.Error(w, conflict, http.StatusConflict)
+}
+
+// AdminGetClasses returns a page of classrooms across all organizations. "q" filters by a name
+// prefix or exact join code, "org" by organization ID and "status" by active (default),
+// archived, deleted or all; results are sorted by "sort" (created or name) and paginated with
+// "limit"/"cursor".
+func (h *APIHandler) AdminGetClasses(w http.ResponseWriter, r *http.Request) {
+	page, err := parseListParams(r, map[string]string{"created": "_id", "name": "name"}, "created")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{}
+	if !adminOrgFilter(w, r, filter) {
+		return
+	}
+	switch r.URL.Query().Get("status") {
+	case "", "active":
+		filter["archived_at"] = bson.M{"$exists": false}
+		filter["deleted_at"] = bson.M{"$exists": false}
+	case "archived":
+		filter["archived_at"] = bson.M{"$exists": true}
+		filter["deleted_at"] = bson.M{"$exists": false}
+	case "deleted":
+		filter["deleted_at"] = bson.M{"$exists": true}
+	case "all":
+	default:
+		http.Error(w, `{"error": "status must be one of active, archived, deleted or all"}`, http.StatusBadRequest)
+		return
+	}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		filter["$or"] = bson.A{
+			bson.M{"name": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q), Options: "i"}},
+			bson.M{"code": q},
+		}
+	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		filter["$and"] = bson.A{keyset}
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	cursor, err := h.DB.Collection("classrooms").Find(ctx, filter, options.Find().SetSort(page.sort()).SetLimit(int64(page.Limit+1)))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch classrooms", err)
+		return
+	}
+	classrooms := []database.Classroom{}
+	if err = cursor.All(ctx, &classrooms); err != nil {
+		h.internalError(w, r, "Failed to decode classrooms", err)
+		return
+	}
+	classrooms = paginate(w, r, page, classrooms, func(c database.Classroom) (interface{}, primitive.ObjectID) {
+		if page.SortField == "name" {
+			return c.Name, c.ID
+		}
+		return c.ID, c.ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(classrooms)
+}
+
+// AdminGetClass returns any classroom, including deleted ones, with its instructor and roster.
+func (h *APIHandler) AdminGetClass(w http.ResponseWriter, r *http.Request) {
+	classID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "classID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var classroom database.Classroom
+	if err := h.DB.Collection("classrooms").FindOne(ctx, bson.M{"_id": classID}).Decode(&classroom); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Classroom not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	cursor, err := h.DB.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": append(classroom.StudentIDs, classroom.InstructorID)}},
+		options.Find().
+			SetSort(bson.D{{Key: "name", Value: 1}}).
+			SetProjection(bson.M{"name": 1, "email": 1, "roll_number": 1, "department": 1, "organization_id": 1, "disabled_at": 1}),
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch roster", err)
+		return
+	}
+	var users []database.User
+	if err = cursor.All(ctx, &users); err != nil {
+		h.internalError(w, r, "Failed to decode roster", err)
+		return
+	}
+
+	var instructor *database.User
+	roster := []database.User{}
+	for i := range users {
+		if users[i].ID == classroom.InstructorID {
+			instructor = &users[i]
+		}
+		if containsID(classroom.StudentIDs, users[i].ID) {
+			roster = append(roster, users[i])
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"classroom":  classroom,
+		"instructor": instructor,
+		"roster":     roster,
+	})
+}
+
+// AdminGetClassAttendance returns any classroom's per-student attendance counts, with the
+// parameters of GetClassAttendance.
+func (h *APIHandler) AdminGetClassAttendance(w http.ResponseWriter, r *http.Request) {
+	classID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "classID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	count, err := h.DB.Collection("classrooms").CountDocuments(ctx, bson.M{"_id": classID})
+	cancel()
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count == 0 {
+		http.Error(w, `{"error": "Classroom not found"}`, http.StatusNotFound)
+		return
+	}
+
+	h.writeClassAttendance(w, r, classID)
+}
+
+// AdminGetStats summarizes the deployment: organizations, users, classrooms, attendance and
+// the notification and webhook backlogs.
+func (h *APIHandler) AdminGetStats(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	now := time.Now()
+	var stats database.SystemStats
+	counts := []struct {
+		collection string
+		filter     bson.M
+		into       *int64
+	}{
+		{"organizations", bson.M{}, &stats.Organizations},
+		{"users", bson.M{}, &stats.Users.Total},
+		{"users", bson.M{"disabled_at": bson.M{"$exists": true}}, &stats.Users.Disabled},
+		{"classrooms", bson.M{"archived_at": bson.M{"$exists": false}, "deleted_at": bson.M{"$exists": false}}, &stats.Classrooms.Active},
+		{"classrooms", bson.M{"archived_at": bson.M{"$exists": true}, "deleted_at": bson.M{"$exists": false}}, &stats.Classrooms.Archived},
+		{"classrooms", bson.M{"deleted_at": bson.M{"$exists": true}}, &stats.Classrooms.Deleted},
+		{"lectures", bson.M{}, &stats.Lectures},
+		{"attendance_sessions", bson.M{"expires_at": bson.M{"$gt": now}}, &stats.OpenSessions},
+		{"attendance_records", bson.M{}, &stats.AttendanceRecords.Total},
+		{"attendance_records", bson.M{"timestamp": bson.M{"$gte": now.Add(-24 * time.Hour)}}, &stats.AttendanceRecords.LastDay},
+		{"webhook_deliveries", bson.M{"status": database.DeliveryPending}, &stats.PendingWebhookDeliveries},
+		{"webhook_deliveries", bson.M{"status": database.DeliveryFailed}, &stats.FailedWebhookDeliveries},
+	}
+	for _, c := range counts {
+		count, err := h.DB.Collection(c.collection).CountDocuments(ctx, c.filter)
+		if err != nil {
+			h.internalError(w, r, "Failed to count "+c.collection, err)
+			return
+		}
+		*c.into = count
+	}
+	pending, err := h.Notify.Pending(ctx)
+	if err != nil {
+		h.internalError(w, r, "Failed to count notifications", err)
+		return
+	}
+	stats.PendingNotifications = pending
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
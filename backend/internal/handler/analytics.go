@@ -0,0 +1,433 @@
+// File: internal/handler/analytics.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"backend/internal/database"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// arrivalBoundaries are the minute marks, relative to the lecture start, that split the arrival
+// distribution. Scans before the first or after the last mark fall into open-ended buckets.
+var arrivalBoundaries = []int{0, 2, 5, 10, 15, 30, 60}
+
+// GetAttendanceTrend returns a class's attendance rate over time, per lecture or per week
+// ("interval=lecture|week"). It accepts "from"/"to" on the lecture start and "tz" for week boundaries.
+func (h *APIHandler) GetAttendanceTrend(w http.ResponseWriter, r *http.Request) {
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "lecture"
+	}
+	if interval != "lecture" && interval != "week" {
+		http.Error(w, `{"error": "interval must be lecture or week"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	loc, ok := analyticsLocation(w, r, classroom)
+	if !ok {
+		return
+	}
+	match, ok := lectureMatch(w, r, classroom.ID)
+	if !ok {
+		return
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.M{"started_at": 1}}},
+	}
+	pipeline = append(pipeline, lectureAttendanceStages()...)
+	if interval == "week" {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$group", Value: bson.M{
+				"_id": bson.M{"$dateTrunc": bson.M{
+					"date":        "$started_at",
+					"unit":        "week",
+					"timezone":    loc.String(),
+					"startOfWeek": "monday",
+				}},
+				"lectures": bson.M{"$sum": 1},
+				"attended": bson.M{"$sum": "$attended"},
+				"late":     bson.M{"$sum": "$late"},
+			}}},
+			bson.D{{Key: "$project", Value: bson.M{"_id": 0, "start": "$_id", "lectures": 1, "attended": 1, "late": 1}}},
+			bson.D{{Key: "$sort", Value: bson.M{"start": 1}}},
+		)
+	} else {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"start":      "$started_at",
+			"lecture_id": "$_id",
+			"lectures":   bson.M{"$literal": 1},
+			"attended":   1,
+			"late":       1,
+		}}})
+	}
+
+	cursor, err := h.DB.Collection("lectures").Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.AggregateTimeout))
+	if err != nil {
+		h.internalError(w, r, "Failed to aggregate attendance trend", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	points := []database.AttendanceTrendPoint{}
+	if err = cursor.All(ctx, &points); err != nil {
+		h.internalError(w, r, "Failed to decode attendance trend", err)
+		return
+	}
+	students := enrolledStudents(classroom)
+	for i := range points {
+		points[i].Rate = attendanceRate(points[i].Attended, points[i].Lectures, students)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
+
+// GetAttendanceHeatmap returns a class's attendance rate by weekday and hour of the lecture start,
+// in the "tz" timezone (default: the class timetable's, else UTC). Empty cells are omitted.
+func (h *APIHandler) GetAttendanceHeatmap(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	loc, ok := analyticsLocation(w, r, classroom)
+	if !ok {
+		return
+	}
+	match, ok := lectureMatch(w, r, classroom.ID)
+	if !ok {
+		return
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+	pipeline = append(pipeline, lectureAttendanceStages()...)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				// $dayOfWeek counts from 1 = Sunday; shift it to match time.Weekday
+				"weekday": bson.M{"$subtract": bson.A{bson.M{"$dayOfWeek": bson.M{"date": "$started_at", "timezone": loc.String()}}, 1}},
+				"hour":    bson.M{"$hour": bson.M{"date": "$started_at", "timezone": loc.String()}},
+			},
+			"lectures": bson.M{"$sum": 1},
+			"attended": bson.M{"$sum": "$attended"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "weekday": "$_id.weekday", "hour": "$_id.hour", "lectures": 1, "attended": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "weekday", Value: 1}, {Key: "hour", Value: 1}}}},
+	)
+
+	cursor, err := h.DB.Collection("lectures").Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.AggregateTimeout))
+	if err != nil {
+		h.internalError(w, r, "Failed to aggregate attendance heatmap", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	cells := []database.AttendanceHeatmapCell{}
+	if err = cursor.All(ctx, &cells); err != nil {
+		h.internalError(w, r, "Failed to decode attendance heatmap", err)
+		return
+	}
+	students := enrolledStudents(classroom)
+	for i := range cells {
+		cells[i].Rate = attendanceRate(cells[i].Attended, cells[i].Lectures, students)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cells)
+}
+
+// GetArrivalDistribution counts a class's scans by how many minutes after the lecture start they
+// arrived. Excused absences are left out. It accepts "from"/"to" on the scan time.
+func (h *APIHandler) GetArrivalDistribution(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	match := bson.M{
+		"classroom_id": classroom.ID,
+		"lecture_id":   bson.M{"$exists": true},
+		"status":       bson.M{"$ne": database.StatusExcused},
+	}
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeRange != nil {
+		match["timestamp"] = timeRange
+	}
+
+	boundaries := bson.A{math.MinInt32}
+	for _, b := range arrivalBoundaries {
+		boundaries = append(boundaries, b)
+	}
+	boundaries = append(boundaries, math.MaxInt32)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "lectures",
+			"localField":   "lecture_id",
+			"foreignField": "_id",
+			"as":           "lecture",
+		}}},
+		{{Key: "$unwind", Value: "$lecture"}},
+		{{Key: "$bucket", Value: bson.M{
+			"groupBy": bson.M{"$floor": bson.M{"$divide": bson.A{
+				bson.M{"$subtract": bson.A{"$timestamp", "$lecture.started_at"}},
+				60000,
+			}}},
+			"boundaries": boundaries,
+			"output":     bson.M{"count": bson.M{"$sum": 1}},
+		}}},
+	}
+
+	cursor, err := h.DB.Collection("attendance_records").Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.AggregateTimeout))
+	if err != nil {
+		h.internalError(w, r, "Failed to aggregate arrival times", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		LowerBound int `bson:"_id"`
+		Count      int `bson:"count"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		h.internalError(w, r, "Failed to decode arrival times", err)
+		return
+	}
+	counts := make(map[int]int, len(rows))
+	for _, row := range rows {
+		counts[row.LowerBound] = row.Count
+	}
+
+	// $bucket omits empty buckets; report every bucket so clients can plot a fixed axis
+	buckets := make([]database.ArrivalBucket, 0, len(arrivalBoundaries)+1)
+	for i := 0; i <= len(arrivalBoundaries); i++ {
+		bucket := database.ArrivalBucket{}
+		lower := math.MinInt32
+		if i > 0 {
+			lower = arrivalBoundaries[i-1]
+			from := lower
+			bucket.FromMinutes = &from
+		}
+		if i < len(arrivalBoundaries) {
+			to := arrivalBoundaries[i]
+			bucket.ToMinutes = &to
+		}
+		bucket.Count = counts[lower]
+		buckets = append(buckets, bucket)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buckets)
+}
+
+// GetClassCohorts compares attendance across the classes the user teaches. It accepts "term" to
+// compare one term's classes and "from"/"to" on the lecture start.
+func (h *APIHandler) GetClassCohorts(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classFilter := bson.M{"instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}
+	if v := r.URL.Query().Get("term"); v != "" {
+		termID, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid term ID"}`, http.StatusBadRequest)
+			return
+		}
+		classFilter["term_id"] = termID
+	}
+	lectureFilter := bson.M{"$expr": bson.M{"$eq": bson.A{"$classroom_id", "$$classroom"}}}
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeRange != nil {
+		lectureFilter["started_at"] = timeRange
+	}
+
+	lecturePipeline := bson.A{bson.M{"$match": lectureFilter}}
+	for _, stage := range lectureAttendanceStages() {
+		lecturePipeline = append(lecturePipeline, stage)
+	}
+	lecturePipeline = append(lecturePipeline, bson.M{"$group": bson.M{
+		"_id":      nil,
+		"lectures": bson.M{"$sum": 1},
+		"attended": bson.M{"$sum": "$attended"},
+		"late":     bson.M{"$sum": "$late"},
+	}})
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: classFilter}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":     "lectures",
+			"let":      bson.M{"classroom": "$_id"},
+			"pipeline": lecturePipeline,
+			"as":       "totals",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"name":     1,
+			"code":     1,
+			"term_id":  1,
+			"students": bson.M{"$size": bson.M{"$setDifference": bson.A{"$student_ids", bson.A{"$instructor_id"}}}},
+			"lectures": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$totals.lectures", 0}}, 0}},
+			"attended": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$totals.attended", 0}}, 0}},
+			"late":     bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$totals.late", 0}}, 0}},
+		}}},
+		{{Key: "$sort", Value: bson.M{"name": 1}}},
+	}
+
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	cursor, err := h.DB.Collection("classrooms").Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.AggregateTimeout))
+	if err != nil {
+		h.internalError(w, r, "Failed to aggregate class cohorts", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	cohorts := []database.ClassCohort{}
+	if err = cursor.All(ctx, &cohorts); err != nil {
+		h.internalError(w, r, "Failed to decode class cohorts", err)
+		return
+	}
+	for i := range cohorts {
+		cohorts[i].Rate = attendanceRate(cohorts[i].Attended, cohorts[i].Lectures, cohorts[i].Students)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cohorts)
+}
+
+// instructedClassroom loads the class in the URL if the user teaches it. Otherwise it writes
+// the error response and returns false.
+func (h *APIHandler) instructedClassroom(ctx context.Context, w http.ResponseWriter, r *http.Request) (*database.Classroom, bool) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "classID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
+		return nil, false
+	}
+
+	var classroom database.Classroom
+	err = h.DB.Collection("classrooms").FindOne(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+			return nil, false
+		}
+		h.internalError(w, r, "Database error", err)
+		return nil, false
+	}
+	return &classroom, true
+}
+
+// analyticsLocation resolves the "tz" query parameter, defaulting to the class timetable's timezone.
+func analyticsLocation(w http.ResponseWriter, r *http.Request, classroom *database.Classroom) (*time.Location, bool) {
+	name := r.URL.Query().Get("tz")
+	if name == "" && classroom.Schedule != nil {
+		name = classroom.Schedule.Timezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		http.Error(w, `{"error": "Unknown timezone"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	return loc, true
+}
+
+// lectureMatch selects a class's lectures that have started, limited by "from"/"to".
+func lectureMatch(w http.ResponseWriter, r *http.Request, classID primitive.ObjectID) (bson.M, bool) {
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if timeRange == nil {
+		timeRange = bson.M{}
+	}
+	timeRange["$lte"] = time.Now()
+	return bson.M{"classroom_id": classID, "started_at": timeRange}, true
+}
+
+// lectureAttendanceStages add "attended" (present or late records) and "late" counts to each lecture.
+func lectureAttendanceStages() []bson.D {
+	return []bson.D{
+		{{Key: "$lookup", Value: bson.M{
+			"from": "attendance_records",
+			"let":  bson.M{"lecture": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$lecture_id", "$$lecture"}},
+					bson.M{"$ne": bson.A{"$status", database.StatusExcused}},
+				}}}},
+				bson.M{"$group": bson.M{
+					"_id":      nil,
+					"attended": bson.M{"$sum": 1},
+					"late":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", database.StatusLate}}, 1, 0}}},
+				}},
+			},
+			"as": "counts",
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"attended": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$counts.attended", 0}}, 0}},
+			"late":     bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$counts.late", 0}}, 0}},
+		}}},
+		{{Key: "$project", Value: bson.M{"counts": 0}}},
+	}
+}
+
+// enrolledStudents counts a class's students, leaving out the auto-enrolled instructor.
+func enrolledStudents(classroom *database.Classroom) int {
+	students := 0
+	for _, id := range classroom.StudentIDs {
+		if id != classroom.InstructorID {
+			students++
+		}
+	}
+	return students
+}
+
+// attendanceRate is attended over possible attendances, rounded to four decimals; 0 when nothing was possible.
+func attendanceRate(attended, lectures, students int) float64 {
+	possible := lectures * students
+	if possible == 0 {
+		return 0
+	}
+	return math.Round(float64(attended)*10000/float64(possible)) / 10000
+}
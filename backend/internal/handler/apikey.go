@@ -0,0 +1,310 @@
+// File: internal/handler/apikey.go
+
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// apiKeyMarker starts every API key, telling it apart from a JWT in the Authorization header.
+	// Keys read "ak_<prefix>_<secret>".
+	apiKeyMarker = "ak_"
+	// maxAPIKeysPerUser bounds the unrevoked keys an account can hold.
+	maxAPIKeysPerUser = 20
+	// apiKeyUsageInterval is how stale a key's last use may get before a request records it again.
+	apiKeyUsageInterval = time.Minute
+)
+
+// APIKeyContextKey holds the *database.APIKey a request authenticated with, if it used one.
+const APIKeyContextKey = contextKey("apiKey")
+
+// apiKeyAuth authenticates a request carrying an API key in place of a JWT. The key acts as its
+// owner, who must still be enabled, with only the key's scopes (see ScopeMiddleware).
+func (h *APIHandler) apiKeyAuth(w http.ResponseWriter, r *http.Request, next http.Handler, keyString string) {
+	prefix, _, found := strings.Cut(strings.TrimPrefix(keyString, apiKeyMarker), "_")
+	if !found || prefix == "" {
+		http.Error(w, `{"error": "Invalid API key"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var key database.APIKey
+	err := h.DB.Collection("api_keys").FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if err != nil && err != mongo.ErrNoDocuments {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if err == mongo.ErrNoDocuments || subtle.ConstantTimeCompare([]byte(auth.HashToken(keyString)), []byte(key.KeyHash)) != 1 {
+		http.Error(w, `{"error": "Invalid API key"}`, http.StatusUnauthorized)
+		return
+	}
+	now := time.Now()
+	if key.RevokedAt != nil {
+		http.Error(w, `{"error": "API key has been revoked"}`, http.StatusUnauthorized)
+		return
+	}
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		http.Error(w, `{"error": "API key has expired"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// The owner's organization is read now rather than copied into the key, so keys follow
+	// users moved between organizations
+	var owner database.User
+	err = h.DB.Collection("users").FindOne(ctx, bson.M{"_id": key.UserID},
+		options.FindOne().SetProjection(bson.M{"organization_id": 1, "disabled_at": 1})).Decode(&owner)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Invalid API key"}`, http.StatusUnauthorized)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if owner.DisabledAt != nil {
+		http.Error(w, `{"error": "This account is disabled"}`, http.StatusForbidden)
+		return
+	}
+
+	// Record the use, but write at most once a minute however busy the client is
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUsageInterval {
+		_, err := h.DB.Collection("api_keys").UpdateOne(ctx,
+			bson.M{"_id": key.ID, "$or": bson.A{
+				bson.M{"last_used_at": bson.M{"$exists": false}},
+				bson.M{"last_used_at": bson.M{"$lt": now.Add(-apiKeyUsageInterval)}},
+			}},
+			bson.M{"$set": bson.M{"last_used_at": now, "last_used_ip": clientIP(r)}},
+		)
+		if err != nil {
+			h.logger(r).Warn("Failed to record API key use", "api_key_id", key.ID.Hex(), "error", err)
+		}
+	}
+
+	if entry, ok := r.Context().Value(logEntryContextKey).(*logEntry); ok {
+		entry.logger = entry.logger.With("user_id", key.UserID.Hex(), "org_id", owner.OrganizationID.Hex(), "api_key_id", key.ID.Hex())
+	}
+
+	reqCtx := context.WithValue(r.Context(), UserIDContextKey, key.UserID.Hex())
+	reqCtx = context.WithValue(reqCtx, OrgIDContextKey, owner.OrganizationID)
+	reqCtx = context.WithValue(reqCtx, APIKeyContextKey, &key)
+	next.ServeHTTP(w, r.WithContext(reqCtx))
+}
+
+// ScopeMiddleware lets API keys through only if they were granted scope. Requests signed in with
+// a JWT have every scope. It must run after AuthMiddleware.
+func (h *APIHandler) ScopeMiddleware(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := r.Context().Value(APIKeyContextKey).(*database.APIKey); ok && !containsString(key.Scopes, scope) {
+				writeJSONError(w, "Forbidden: API key lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SessionMiddleware refuses API keys, keeping the routes behind it to users signed in with a JWT.
+// It must run after AuthMiddleware.
+func (h *APIHandler) SessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(APIKeyContextKey).(*database.APIKey); ok {
+			http.Error(w, `{"error": "Forbidden: Not available to API keys"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeySnapshot is the part of an API key recorded in the audit log.
+func apiKeySnapshot(key *database.APIKey) bson.M {
+	return bson.M{"name": key.Name, "prefix": key.Prefix, "scopes": key.Scopes, "expires_at": key.ExpiresAt}
+}
+
+// CreateAPIKey issues an API key acting as the logged-in user with the requested scopes, for
+// machine clients. The response holds the key itself, which is not shown again.
+func (h *APIHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expiresInDays"` // 0 for a key that does not expire
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, `{"error": "name is required and must be at most 100 characters"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, `{"error": "scopes must list at least one scope"}`, http.StatusBadRequest)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !containsString(database.APIScopes, scope) {
+			writeJSONError(w, "Unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > 3650 {
+		http.Error(w, `{"error": "expiresInDays must be between 0 and 3650"}`, http.StatusBadRequest)
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	keysCollection := h.DB.Collection("api_keys")
+	count, err := keysCollection.CountDocuments(ctx, bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count >= maxAPIKeysPerUser {
+		http.Error(w, `{"error": "Too many API keys; revoke one first"}`, http.StatusConflict)
+		return
+	}
+
+	prefix, err := auth.GenerateSecureToken(6)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate API key", err)
+		return
+	}
+	secret, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate API key", err)
+		return
+	}
+	keyString := apiKeyMarker + prefix + "_" + secret
+
+	now := time.Now()
+	key := database.APIKey{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		OrganizationID: orgID,
+		Name:           req.Name,
+		Prefix:         prefix,
+		KeyHash:        auth.HashToken(keyString),
+		Scopes:         scopes,
+		CreatedAt:      now,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+	if _, err := keysCollection.InsertOne(ctx, key); err != nil {
+		h.internalError(w, r, "Failed to create API key", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionAPIKeyCreated,
+		TargetType: targetAPIKey,
+		TargetID:   key.ID,
+		After:      apiKeySnapshot(&key),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"apiKey": key,
+		"key":    keyString,
+	})
+}
+
+// GetAPIKeys lists the logged-in user's API keys, revoked ones included, and the scopes a key
+// can be granted.
+func (h *APIHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	cursor, err := h.DB.Collection("api_keys").Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch API keys", err)
+		return
+	}
+	keys := []database.APIKey{}
+	if err = cursor.All(ctx, &keys); err != nil {
+		h.internalError(w, r, "Failed to decode API keys", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"apiKeys": keys,
+		"scopes":  database.APIScopes,
+	})
+}
+
+// RevokeAPIKey stops one of the logged-in user's API keys from working. Revoked keys are kept
+// so their use stays traceable in the audit log.
+func (h *APIHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	keyID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "keyID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid API key ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var key database.APIKey
+	err = h.DB.Collection("api_keys").FindOneAndUpdate(ctx,
+		bson.M{"_id": keyID, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "API key not found or already revoked"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Failed to revoke API key", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionAPIKeyRevoked,
+		TargetType: targetAPIKey,
+		TargetID:   key.ID,
+		Before:     bson.M{"revoked_at": nil},
+		After:      bson.M{"revoked_at": key.RevokedAt},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
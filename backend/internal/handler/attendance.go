@@ -4,33 +4,42 @@ package handler
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"time"
 
+	"backend/internal/auth"
 	"backend/internal/database"
+	"backend/internal/metrics"
+	"backend/internal/schedule"
+	"backend/internal/webhook"
 
 	"github.com/go-chi/chi/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// generateSecureToken creates a random, URL-safe string.
-func generateSecureToken(length int) (string, error) {
-	bytes := make([]byte, length)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(bytes), nil
-}
+const (
+	// qrSessionTTL is how long a manually started (QR) attendance session accepts scans.
+	qrSessionTTL = 60 * time.Second
+	// pinSessionTTL is longer, since students have to read and type the code.
+	pinSessionTTL = 2 * time.Minute
 
-// CreateAttendanceSession generates a short-lived token for a class.
+	pinDigits      = 6
+	maxPINFailures = 5 // Within database.PINFailureWindow, per student
+)
+
+// CreateAttendanceSession generates a short-lived token for a class. With {"pin": true} in the
+// body the session also gets a numeric PIN that students can type instead of scanning.
 func (h *APIHandler) CreateAttendanceSession(w http.ResponseWriter, r *http.Request) {
 	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
 	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
 
 	classIDHex := chi.URLParam(r, "classID")
 	classID, err := primitive.ObjectIDFromHex(classIDHex)
@@ -39,101 +48,418 @@ func (h *APIHandler) CreateAttendanceSession(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// The body is optional; older clients send none
+	var req struct {
+		PIN bool `json:"pin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var classroom database.Classroom
 	classroomsCollection := h.DB.Collection("classrooms")
-	count, err := classroomsCollection.CountDocuments(context.TODO(), bson.M{"_id": classID, "instructor_id": instructorID})
-	if err != nil || count == 0 {
-		http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+	err = classroomsCollection.FindOne(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if classroom.ArchivedAt != nil {
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
 		return
 	}
 
+	session, err := h.openAttendanceSession(ctx, r, &classroom, req.PIN, primitive.NilObjectID)
+	if err != nil {
+		h.internalError(w, r, "Failed to create attendance session", err)
+		return
+	}
+
+	resp := map[string]interface{}{"attendanceToken": session.Token}
+	if session.PIN != "" {
+		resp["pin"] = session.PIN
+		resp["expiresAt"] = session.ExpiresAt
+	}
+	if session.Meeting != nil {
+		resp["meeting"] = session.Meeting
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// openAttendanceSession opens a QR session (with a PIN if pin is set) for classroom, in the
+// lecture under way or a new one, announcing new lectures to students and webhooks. kioskID is
+// set for sessions a kiosk opens on its own.
+func (h *APIHandler) openAttendanceSession(ctx context.Context, r *http.Request, classroom *database.Classroom, pin bool, kioskID primitive.ObjectID) (*database.AttendanceSession, error) {
 	session := database.AttendanceSession{
 		ID:          primitive.NewObjectID(),
-		Token:       "", // Will be generated next
-		ClassroomID: classID,
+		ClassroomID: classroom.ID,
 		CreatedAt:   time.Now(),
+		KioskID:     kioskID,
+	}
+	session.ExpiresAt = session.CreatedAt.Add(qrSessionTTL)
+	// Attach the session to the scheduled meeting it falls in, if the class has a timetable
+	if classroom.Schedule != nil {
+		session.Meeting, _ = schedule.MeetingAt(classroom.Schedule, session.CreatedAt)
+	}
+	lecture, lectureCreated, err := database.OpenLecture(ctx, h.DB, classroom.ID, session.Meeting, session.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("open lecture: %w", err)
+	}
+	session.LectureID = lecture.ID
+	session.LectureAt = lecture.StartedAt
+	session.Token, err = auth.GenerateSecureToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("generate session token: %w", err)
+	}
+	if pin {
+		session.PIN, err = auth.GeneratePIN(pinDigits)
+		if err != nil {
+			return nil, fmt.Errorf("generate session PIN: %w", err)
+		}
+		session.ExpiresAt = session.CreatedAt.Add(pinSessionTTL)
+	}
+
+	if _, err := h.DB.Collection("attendance_sessions").InsertOne(ctx, session); err != nil {
+		return nil, err
+	}
+	// A kiosk rotates its session every few seconds; only the one starting a lecture is audited
+	if kioskID.IsZero() || lectureCreated {
+		// The token and PIN would let anyone reading the log mark attendance while the session is open
+		after := bson.M{
+			"lecture_id":      session.LectureID,
+			"lecture_created": lectureCreated,
+			"pin":             session.PIN != "",
+			"expires_at":      session.ExpiresAt,
+		}
+		if !kioskID.IsZero() {
+			after["kiosk_id"] = kioskID
+		}
+		h.audit(ctx, r, database.AuditEntry{
+			Action:         actionSessionCreated,
+			TargetType:     targetSession,
+			TargetID:       session.ID,
+			ClassroomID:    &classroom.ID,
+			OrganizationID: classroom.OrganizationID, // Kiosks have no organization of their own
+			After:          after,
+		})
+	}
+	// Rotating the QR code opens a new session every few seconds; only the first one of a lecture is announced
+	if lectureCreated {
+		h.enqueueNotification(ctx, r, classStudents(classroom), sessionOpenedMessage(classroom, lecture))
+		h.emitWebhook(ctx, r, webhook.SessionOpened(lecture))
 	}
-	// Generate token that includes session ID for better traceability if needed
-	token, err := generateSecureToken(16)
+	return &session, nil
+}
+
+// GetActiveAttendanceSession returns the open session of a class with the latest expiry,
+// so instructors can display the token of a session opened by the scheduler.
+func (h *APIHandler) GetActiveAttendanceSession(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to generate session token"}`, http.StatusInternalServerError)
+		http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
 		return
 	}
-	session.Token = token
 
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroomsCollection := h.DB.Collection("classrooms")
+	count, err := classroomsCollection.CountDocuments(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count == 0 {
+		http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+		return
+	}
+
+	var session database.AttendanceSession
 	sessionsCollection := h.DB.Collection("attendance_sessions")
-	_, err = sessionsCollection.InsertOne(context.TODO(), session)
+	err = sessionsCollection.FindOne(
+		ctx,
+		bson.M{"classroom_id": classID, "expires_at": bson.M{"$gt": time.Now()}},
+		options.FindOne().SetSort(bson.M{"expires_at": -1}),
+	).Decode(&session)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to create attendance session"}`, http.StatusInternalServerError)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "No open attendance session"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
 		return
 	}
 
+	resp := map[string]interface{}{
+		"attendanceToken": session.Token,
+		"expiresAt":       session.ExpiresAt,
+		"automatic":       session.Automatic,
+	}
+	if session.PIN != "" {
+		resp["pin"] = session.PIN
+	}
+	if session.Meeting != nil {
+		resp["meeting"] = session.Meeting
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"attendanceToken": token})
+	json.NewEncoder(w).Encode(resp)
 }
 
-// MarkAttendance allows a student to mark their attendance.
+// MarkAttendance allows a student to mark their attendance, either with the token from the QR
+// code or with a class ID and the session PIN.
 func (h *APIHandler) MarkAttendance(w http.ResponseWriter, r *http.Request) {
 	studentIDHex, _ := r.Context().Value(UserIDContextKey).(string)
 	studentID, _ := primitive.ObjectIDFromHex(studentIDHex)
 
 	var req struct {
 		AttendanceToken string `json:"attendanceToken"`
+		ClassID         string `json:"classId"` // With PIN, instead of a token
+		PIN             string `json:"pin"`
+		markDevice
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkInvalidRequest).Inc()
 		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
 		return
 	}
+	if msg := req.markDevice.validate(h.RequireDeviceID); msg != "" {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkInvalidRequest).Inc()
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	method := database.MethodQR
+	sessionFilter := bson.M{"token": req.AttendanceToken, "expires_at": bson.M{"$gt": time.Now()}}
+	if req.PIN != "" {
+		classID, err := primitive.ObjectIDFromHex(req.ClassID)
+		if err != nil {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkInvalidRequest).Inc()
+			http.Error(w, `{"error": "classId is required with a PIN"}`, http.StatusBadRequest)
+			return
+		}
+		// Six digits are guessable, so mistyped PINs are limited per student
+		failures, err := h.DB.Collection("pin_failures").CountDocuments(ctx, bson.M{"user_id": studentID})
+		if err != nil {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if failures >= maxPINFailures {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkRateLimited).Inc()
+			http.Error(w, `{"error": "Too many incorrect PINs, try again later"}`, http.StatusTooManyRequests)
+			return
+		}
+		method = database.MethodPIN
+		sessionFilter = bson.M{"classroom_id": classID, "pin": req.PIN, "expires_at": bson.M{"$gt": time.Now()}}
+	}
 
 	sessionsCollection := h.DB.Collection("attendance_sessions")
 	var session database.AttendanceSession
-	err := sessionsCollection.FindOne(context.TODO(), bson.M{"token": req.AttendanceToken}).Decode(&session)
+	err := sessionsCollection.FindOne(ctx, sessionFilter).Decode(&session)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid or expired attendance token"}`, http.StatusUnauthorized)
+		if err == mongo.ErrNoDocuments {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkExpiredToken).Inc()
+			if method == database.MethodPIN {
+				_, err = h.DB.Collection("pin_failures").InsertOne(ctx, bson.M{"user_id": studentID, "at": time.Now()})
+				if err != nil {
+					h.logger(r).Error("Failed to record PIN failure", "error", err)
+				}
+				http.Error(w, `{"error": "Invalid or expired PIN"}`, http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, `{"error": "Invalid or expired attendance token"}`, http.StatusUnauthorized)
+			return
+		}
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+		h.internalError(w, r, "Database error", err)
 		return
 	}
 
+	h.completeMark(ctx, w, r, &session, method, req.markDevice, primitive.NilObjectID)
+}
+
+// markDevice is what the phone marking attendance reports about itself.
+type markDevice struct {
+	DeviceID string             `json:"deviceId"` // Stable per-install identifier of the scanning phone
+	Location *database.GeoPoint `json:"location"` // Optional, checked against the classroom geofence in reports
+}
+
+// validate checks the device ID, required when requireDeviceID is set, and the location.
+func (d *markDevice) validate(requireDeviceID bool) string {
+	if len(d.DeviceID) > maxDeviceIDLength || (d.DeviceID == "" && requireDeviceID) {
+		return "A device ID of at most 128 characters is required"
+	}
+	if loc := d.Location; loc != nil && (math.Abs(loc.Latitude) > 90 || math.Abs(loc.Longitude) > 180 || loc.AccuracyMeters < 0) {
+		return "Invalid location"
+	}
+	return ""
+}
+
+// completeMark records the logged-in student's attendance in session, made with method (and
+// the beacon beaconID, for beacon check-ins), once the student has proven they may use it.
+func (h *APIHandler) completeMark(ctx context.Context, w http.ResponseWriter, r *http.Request, session *database.AttendanceSession, method string, device markDevice, beaconID primitive.ObjectID) {
+	studentIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	studentID, _ := primitive.ObjectIDFromHex(studentIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	var classroom database.Classroom
 	classroomsCollection := h.DB.Collection("classrooms")
-	count, err := classroomsCollection.CountDocuments(context.TODO(), bson.M{"_id": session.ClassroomID, "student_ids": studentID})
-	if err != nil || count == 0 {
-		http.Error(w, `{"error": "Forbidden: You are not enrolled in this class"}`, http.StatusForbidden)
+	err := classroomsCollection.FindOne(ctx, bson.M{"_id": session.ClassroomID, "student_ids": studentID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkNotEnrolled).Inc()
+			http.Error(w, `{"error": "Forbidden: You are not enrolled in this class"}`, http.StatusForbidden)
+			return
+		}
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+		h.internalError(w, r, "Database error", err)
 		return
 	}
 
+	if device.DeviceID != "" && h.MaxDevices > 0 {
+		bound, err := database.BindDevice(ctx, h.DB, studentID, device.DeviceID, h.MaxDevices, time.Now())
+		if err != nil {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+			h.internalError(w, r, "Failed to register device", err)
+			return
+		}
+		if !bound {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkDeviceRejected).Inc()
+			http.Error(w, `{"error": "This device is not registered to your account and you have reached the device limit. Ask your instructor to reset your devices."}`, http.StatusForbidden)
+			return
+		}
+	}
+
 	attendanceCollection := h.DB.Collection("attendance_records")
 	newRecord := database.AttendanceRecord{
 		ID:          primitive.NewObjectID(),
 		UserID:      studentID,
 		ClassroomID: session.ClassroomID,
 		SessionID:   session.ID, // MODIFIED: Store the session ID
+		LectureID:   session.LectureID,
+		Status:      database.StatusPresent,
+		Method:      method,
+		BeaconID:    beaconID,
+		DeviceID:    device.DeviceID,
+		IPAddress:   clientIP(r),
+		Location:    device.Location,
 		Timestamp:   time.Now(),
 	}
+	lateAfter := time.Duration(classroom.Settings.LateAfterMinutes) * time.Minute
+	if lateAfter > 0 && !session.LectureAt.IsZero() && newRecord.Timestamp.After(session.LectureAt.Add(lateAfter)) {
+		newRecord.Status = database.StatusLate
+	}
+
+	// The mark is accepted, but flagged for the instructor, when the device already scanned for someone else
+	var sharedFilter bson.M
+	if newRecord.DeviceID != "" && !session.LectureID.IsZero() {
+		sharedFilter = bson.M{"lecture_id": session.LectureID, "device_id": newRecord.DeviceID, "user_id": bson.M{"$ne": studentID}}
+		shared, err := attendanceCollection.CountDocuments(ctx, sharedFilter)
+		if err != nil {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if shared > 0 {
+			newRecord.Flags = []string{database.FlagSharedDevice}
+		}
+	}
 
-	_, err = attendanceCollection.InsertOne(context.TODO(), newRecord)
+	_, err = attendanceCollection.InsertOne(ctx, newRecord)
 	if err != nil {
 		// This will now catch the duplicate key error from our unique index
 		if mongo.IsDuplicateKeyError(err) {
-			http.Error(w, `{"error": "Attendance already marked for this session"}`, http.StatusConflict) // 409 Conflict
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkDuplicate).Inc()
+			http.Error(w, `{"error": "Attendance already marked for this lecture"}`, http.StatusConflict) // 409 Conflict
 			return
 		}
-		http.Error(w, `{"error": "Failed to record attendance"}`, http.StatusInternalServerError)
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+		h.internalError(w, r, "Failed to record attendance", err)
 		return
 	}
 
+	if len(newRecord.Flags) > 0 {
+		h.logger(r).Warn("Device marked attendance for multiple accounts", "lecture_id", session.LectureID.Hex(), "device_id", newRecord.DeviceID)
+		_, err = attendanceCollection.UpdateMany(ctx, sharedFilter, bson.M{"$addToSet": bson.M{"flags": database.FlagSharedDevice}})
+		if err != nil {
+			h.logger(r).Error("Failed to flag shared-device records", "error", err)
+		}
+	}
+
+	metrics.AttendanceMarks.WithLabelValues(metrics.MarkSuccess).Inc()
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionAttendanceMarked,
+		TargetType:  targetRecord,
+		TargetID:    newRecord.ID,
+		ClassroomID: &newRecord.ClassroomID,
+		After:       recordSnapshot(&newRecord),
+	})
+	h.enqueueNotification(ctx, r, []primitive.ObjectID{studentID}, attendanceMarkedMessage(&classroom, newRecord))
+	h.emitWebhook(ctx, r, webhook.AttendanceMarked(newRecord))
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Attendance marked successfully"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "Attendance marked successfully", "status": newRecord.Status})
 }
 
-// GetMyAttendanceHistory retrieves all attendance records for the logged-in user.
+// GetMyAttendanceHistory retrieves a page of attendance records for the logged-in user,
+// newest first by default. It accepts "from"/"to" and "classId" filters, "sort" (timestamp)
+// and the shared "limit"/"cursor" pagination parameters.
 func (h *APIHandler) GetMyAttendanceHistory(w http.ResponseWriter, r *http.Request) {
 	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
 	userID, _ := primitive.ObjectIDFromHex(userIDHex)
 
+	page, err := parseListParams(r, map[string]string{"timestamp": "timestamp"}, "-timestamp")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	match := bson.M{"user_id": userID}
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeRange != nil {
+		match["timestamp"] = timeRange
+	}
+	if classIDHex := r.URL.Query().Get("classId"); classIDHex != "" {
+		classID, err := primitive.ObjectIDFromHex(classIDHex)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
+			return
+		}
+		match["classroom_id"] = classID
+	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		match["$and"] = bson.A{keyset}
+	}
+
 	attendanceCollection := h.DB.Collection("attendance_records")
 
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"user_id": userID}}},
-		{{Key: "$sort", Value: bson.M{"timestamp": -1}}},
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: page.sort()}},
+		{{Key: "$limit", Value: page.Limit + 1}},
 		{{Key: "$lookup", Value: bson.M{
 			"from":         "classrooms",
 			"localField":   "classroom_id",
@@ -143,27 +469,36 @@ func (h *APIHandler) GetMyAttendanceHistory(w http.ResponseWriter, r *http.Reque
 		{{Key: "$unwind", Value: "$classroomInfo"}},
 	}
 
-	cursor, err := attendanceCollection.Aggregate(context.TODO(), pipeline)
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	cursor, err := attendanceCollection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.AggregateTimeout))
 	if err != nil {
-		http.Error(w, `{"error": "Failed to aggregate attendance history"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to aggregate attendance history", err)
 		return
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
-	var results []database.StudentAttendanceHistory
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		http.Error(w, `{"error": "Failed to decode attendance history"}`, http.StatusInternalServerError)
+	results := []database.StudentAttendanceHistory{}
+	if err = cursor.All(ctx, &results); err != nil {
+		h.internalError(w, r, "Failed to decode attendance history", err)
 		return
 	}
+	results = paginate(w, r, page, results, func(record database.StudentAttendanceHistory) (interface{}, primitive.ObjectID) {
+		return record.Timestamp, record.ID
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
 
-// GetClassAttendance retrieves a summary of attendance for all students in a class.
+// GetClassAttendance retrieves a page of per-student attendance counts for a class.
+// It accepts "from"/"to" to count only records in a date range, "sort" (name or
+// attendedCount) and the shared "limit"/"cursor" pagination parameters.
 func (h *APIHandler) GetClassAttendance(w http.ResponseWriter, r *http.Request) {
 	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
 	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
 
 	classIDHex := chi.URLParam(r, "classID")
 	classID, err := primitive.ObjectIDFromHex(classIDHex)
@@ -172,20 +507,56 @@ func (h *APIHandler) GetClassAttendance(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	classroomsCollection := h.DB.Collection("classrooms")
-	count, err := classroomsCollection.CountDocuments(context.TODO(), bson.M{"_id": classID, "instructor_id": instructorID})
-	if err != nil || count == 0 {
+	ctx, cancel := h.queryContext(r)
+	count, err := h.DB.Collection("classrooms").CountDocuments(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}})
+	cancel()
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count == 0 {
 		http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
 		return
 	}
 
+	h.writeClassAttendance(w, r, classID)
+}
+
+// writeClassAttendance writes the page of per-student attendance counts GetClassAttendance
+// describes, for a class the caller has already been allowed to see.
+func (h *APIHandler) writeClassAttendance(w http.ResponseWriter, r *http.Request, classID primitive.ObjectID) {
+	page, err := parseListParams(r, map[string]string{"name": "name", "attendedCount": "attendedCount"}, "name")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	match := bson.M{"classroom_id": classID}
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeRange != nil {
+		match["timestamp"] = timeRange
+	}
+
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
 	attendanceCollection := h.DB.Collection("attendance_records")
 
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"classroom_id": classID}}},
+		{{Key: "$match", Value: match}},
 		{{Key: "$group", Value: bson.M{
 			"_id":           "$user_id",
 			"attendedCount": bson.M{"$sum": 1},
+			"flaggedCount": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$flags", bson.A{}}}}, 0}}, 1, 0,
+			}}},
+			"qrCount":       methodCount(database.MethodQR),
+			"pinCount":      methodCount(database.MethodPIN),
+			"rollCallCount": methodCount(database.MethodRollCall),
+			"beaconCount":   methodCount(database.MethodBeacon),
 		}}},
 		{{Key: "$lookup", Value: bson.M{
 			"from":         "users",
@@ -199,22 +570,51 @@ func (h *APIHandler) GetClassAttendance(w http.ResponseWriter, r *http.Request)
 			"name":          "$studentInfo.name",
 			"email":         "$studentInfo.email",
 			"attendedCount": 1,
+			"flaggedCount":  1,
+			"qrCount":       1,
+			"pinCount":      1,
+			"rollCallCount": 1,
+			"beaconCount":   1,
 		}}},
 	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: keyset}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: page.sort()}},
+		bson.D{{Key: "$limit", Value: page.Limit + 1}},
+	)
 
-	cursor, err := attendanceCollection.Aggregate(context.TODO(), pipeline)
+	cursor, err := attendanceCollection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.AggregateTimeout))
 	if err != nil {
-		http.Error(w, `{"error": "Failed to aggregate class attendance"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to aggregate class attendance", err)
 		return
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
-	var results []database.ClassAttendanceSummary
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		http.Error(w, `{"error": "Failed to decode class attendance"}`, http.StatusInternalServerError)
+	results := []database.ClassAttendanceSummary{}
+	if err = cursor.All(ctx, &results); err != nil {
+		h.internalError(w, r, "Failed to decode class attendance", err)
 		return
 	}
+	results = paginate(w, r, page, results, func(summary database.ClassAttendanceSummary) (interface{}, primitive.ObjectID) {
+		if page.SortField == "attendedCount" {
+			return summary.AttendedCount, summary.UserID
+		}
+		return summary.Name, summary.UserID
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
+
+// methodCount sums the grouped records made with method. Records without a method predate the
+// other methods and were QR scans, unless they are excuses.
+func methodCount(method string) bson.M {
+	recordMethod := bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{"$status", database.StatusExcused}},
+		"",
+		bson.M{"$ifNull": bson.A{"$method", database.MethodQR}},
+	}}
+	return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{recordMethod, method}}, 1, 0}}}
+}
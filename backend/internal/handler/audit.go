@@ -0,0 +1,234 @@
+// File: internal/handler/audit.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Audited actions
+const (
+	actionUserRegistered        = "user.registered"
+	actionUserProvisioned       = "user.provisioned" // Created on first SSO or LMS sign-in
+	actionUserIdentityLinked    = "user.identity_linked"
+	actionUserUpdated           = "user.updated"
+	actionUserDeleted           = "user.deleted"
+	actionUserPasswordChanged   = "user.password_changed"
+	actionUserEmailRequested    = "user.email_change_requested"
+	actionUserEmailChanged      = "user.email_changed"
+	actionUserNotificationPrefs = "user.notification_prefs_updated"
+	actionUserPushTokenAdded    = "user.push_token_added"
+	actionUserPushTokenRemoved  = "user.push_token_removed"
+	actionUserDevicesReset      = "user.devices_reset"
+	actionUserOrgRoleChanged    = "user.org_role_changed"
+	actionUserDisabled          = "user.disabled"
+	actionUserEnabled           = "user.enabled"
+	actionUserPasswordReset     = "user.password_reset"
+	actionUserImpersonated      = "user.impersonated"
+	actionTermCreated           = "term.created"
+	actionClassCreated          = "class.created"
+	actionClassUpdated          = "class.updated"
+	actionClassScheduleUpdated  = "class.schedule_updated"
+	actionClassArchived         = "class.archived"
+	actionClassUnarchived       = "class.unarchived"
+	actionClassDeleted          = "class.deleted"
+	actionClassRestored         = "class.restored"
+	actionClassTransferred      = "class.transferred"
+	actionClassJoined           = "class.joined"
+	actionClassLeft             = "class.left"
+	actionClassGradesPushed     = "class.grades_pushed"
+	actionLectureStarted        = "lecture.started"
+	actionSessionCreated        = "session.created"
+	actionAttendanceMarked      = "attendance.marked"
+	actionAttendanceRollCall    = "attendance.roll_call"
+	actionAttendanceExcused     = "attendance.excused"
+	actionWebhookCreated        = "webhook.created"
+	actionWebhookUpdated        = "webhook.updated"
+	actionWebhookDeleted        = "webhook.deleted"
+	actionWebhookReplayed       = "webhook.delivery_replayed"
+	actionOrganizationUpdated   = "organization.updated"
+	actionAPIKeyCreated         = "api_key.created"
+	actionAPIKeyRevoked         = "api_key.revoked"
+	actionKioskPaired           = "kiosk.paired"
+	actionKioskUnpaired         = "kiosk.unpaired"
+	actionBeaconRegistered      = "beacon.registered"
+	actionBeaconDeleted         = "beacon.deleted"
+)
+
+// Audit target types
+const (
+	targetUser         = "user"
+	targetTerm         = "term"
+	targetClassroom    = "classroom"
+	targetLecture      = "lecture"
+	targetSession      = "attendance_session"
+	targetRecord       = "attendance_record"
+	targetWebhook      = "webhook"
+	targetOrganization = "organization"
+	targetAPIKey       = "api_key"
+	targetKiosk        = "kiosk"
+	targetBeacon       = "beacon"
+)
+
+// recordAudit appends entry to the audit log. Fields left empty are taken from the request: the
+// actor, their organization, any operator impersonating them or API key they used, and the
+// client. Entries written on behalf of no one signed in, such as registrations, must set ActorID
+// themselves.
+func (h *APIHandler) recordAudit(ctx context.Context, r *http.Request, entry database.AuditEntry) error {
+	entry.ID = primitive.NewObjectID()
+	entry.At = time.Now()
+	if entry.ActorID.IsZero() {
+		actorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+		entry.ActorID, _ = primitive.ObjectIDFromHex(actorIDHex)
+	}
+	if entry.OrganizationID.IsZero() {
+		entry.OrganizationID, _ = r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+	}
+	if impersonatorID, ok := r.Context().Value(ImpersonatorIDContextKey).(primitive.ObjectID); ok {
+		entry.ImpersonatorID = &impersonatorID
+	}
+	if key, ok := r.Context().Value(APIKeyContextKey).(*database.APIKey); ok {
+		entry.APIKeyID = &key.ID
+	}
+	entry.IP = clientIP(r)
+	entry.UserAgent = r.UserAgent()
+	entry.RequestID, _ = r.Context().Value(RequestIDContextKey).(string)
+	if h.AuditRetention > 0 {
+		expiresAt := entry.At.Add(h.AuditRetention)
+		entry.ExpiresAt = &expiresAt
+	}
+
+	_, err := h.DB.Collection("audit_log").InsertOne(ctx, entry)
+	return err
+}
+
+// audit is recordAudit for changes that have already been made: a failure to log them is only
+// logged, since the request has succeeded.
+func (h *APIHandler) audit(ctx context.Context, r *http.Request, entry database.AuditEntry) {
+	if err := h.recordAudit(ctx, r, entry); err != nil {
+		h.logger(r).Error("Failed to write audit log", "action", entry.Action, "target_id", entry.TargetID.Hex(), "error", err)
+	}
+}
+
+// userSnapshot is the part of an account recorded in the audit log, leaving out credentials.
+func userSnapshot(user *database.User) bson.M {
+	return bson.M{
+		"name":            user.Name,
+		"email":           user.Email,
+		"roll_number":     user.RollNumber,
+		"department":      user.Department,
+		"organization_id": user.OrganizationID,
+	}
+}
+
+// recordSnapshot is the part of an attendance record recorded in the audit log. The device, IP
+// address and location stay in the record only, where deleting the account anonymizes them.
+func recordSnapshot(record *database.AttendanceRecord) bson.M {
+	snapshot := bson.M{
+		"user_id":    record.UserID,
+		"lecture_id": record.LectureID,
+		"status":     record.Status,
+	}
+	if !record.SessionID.IsZero() {
+		snapshot["session_id"] = record.SessionID
+	}
+	if record.Method != "" {
+		snapshot["method"] = record.Method
+	}
+	if !record.BeaconID.IsZero() {
+		snapshot["beacon_id"] = record.BeaconID
+	}
+	if len(record.Flags) > 0 {
+		snapshot["flags"] = record.Flags
+	}
+	return snapshot
+}
+
+// snapshotFields picks the fields named by the keys of an update's $set (dotted paths included)
+// from doc, to record their values before or after the update.
+func snapshotFields(doc interface{}, set bson.M) bson.M {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	snapshot := bson.M{}
+	for key := range set {
+		value, err := bson.Raw(raw).LookupErr(strings.Split(key, ".")...)
+		if err != nil {
+			snapshot[key] = nil
+			continue
+		}
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// AdminGetAuditLog returns a page of audit log entries, newest first. "actor", "target",
+// "class" and "org" filter by the ID of the acting user, the changed object, its class and the
+// organization, "action" by action name and "from"/"to" by time; "limit"/"cursor" paginate.
+func (h *APIHandler) AdminGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	page, err := parseListParams(r, map[string]string{"created": "_id"}, "-created")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{}
+	query := r.URL.Query()
+	for param, field := range map[string]string{"actor": "actor_id", "target": "target_id", "class": "classroom_id", "org": "organization_id"} {
+		if idHex := query.Get(param); idHex != "" {
+			id, err := primitive.ObjectIDFromHex(idHex)
+			if err != nil {
+				writeJSONError(w, "Invalid "+param+" ID", http.StatusBadRequest)
+				return
+			}
+			filter[field] = id
+		}
+	}
+	if action := query.Get("action"); action != "" {
+		filter["action"] = action
+	}
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeRange != nil {
+		filter["at"] = timeRange
+	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		filter["$and"] = bson.A{keyset}
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	// Snapshots have no fixed shape; decode them as maps so they render as JSON objects
+	auditLog := h.DB.Collection("audit_log", options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true}))
+	cursor, err := auditLog.Find(ctx, filter, options.Find().SetSort(page.sort()).SetLimit(int64(page.Limit+1)))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch audit log", err)
+		return
+	}
+	entries := []database.AuditEntry{}
+	if err = cursor.All(ctx, &entries); err != nil {
+		h.internalError(w, r, "Failed to decode audit log", err)
+		return
+	}
+	entries = paginate(w, r, page, entries, func(e database.AuditEntry) (interface{}, primitive.ObjectID) {
+		return e.ID, e.ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
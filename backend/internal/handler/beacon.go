@@ -0,0 +1,278 @@
+// File: internal/handler/beacon.go
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+	"backend/internal/metrics"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// beaconObservationMaxAge is how far the time a beacon signed may be from now, either way,
+	// for the observation to count. It bounds how long a relayed observation stays usable.
+	beaconObservationMaxAge = time.Minute
+	// maxBeaconsPerOrganization bounds the beacons an organization registers.
+	maxBeaconsPerOrganization = 1000
+)
+
+// beaconSnapshot is the part of a beacon recorded in the audit log, leaving out its secret.
+func beaconSnapshot(beacon *database.Beacon) bson.M {
+	return bson.M{"beacon_id": beacon.BeaconID, "kind": beacon.Kind, "room": beacon.Room}
+}
+
+// CreateBeacon registers a BLE beacon or NFC tag in a room of the admin's organization. The
+// response holds the secret to provision into the beacon, which is not shown again.
+func (h *APIHandler) CreateBeacon(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BeaconID string `json:"beaconId"`
+		Kind     string `json:"kind"`
+		Room     string `json:"room"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	req.BeaconID = strings.TrimSpace(req.BeaconID)
+	req.Room = strings.TrimSpace(req.Room)
+	if req.BeaconID == "" || len(req.BeaconID) > 128 {
+		http.Error(w, `{"error": "beaconId is required and must be at most 128 characters"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Kind != database.BeaconBLE && req.Kind != database.BeaconNFC {
+		http.Error(w, `{"error": "kind must be ble or nfc"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Room == "" || len(req.Room) > 100 {
+		http.Error(w, `{"error": "room is required and must be at most 100 characters"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	admin, ok := h.orgAdmin(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	beaconsCollection := h.DB.Collection("beacons")
+	count, err := beaconsCollection.CountDocuments(ctx, bson.M{"organization_id": admin.OrganizationID})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count >= maxBeaconsPerOrganization {
+		http.Error(w, `{"error": "Too many beacons; remove one first"}`, http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate beacon secret", err)
+		return
+	}
+	beacon := database.Beacon{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: admin.OrganizationID,
+		BeaconID:       req.BeaconID,
+		Kind:           req.Kind,
+		Room:           req.Room,
+		Secret:         secret,
+		CreatedBy:      admin.ID,
+		CreatedAt:      time.Now(),
+	}
+	if _, err := beaconsCollection.InsertOne(ctx, beacon); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, `{"error": "This beacon is already registered"}`, http.StatusConflict)
+			return
+		}
+		h.internalError(w, r, "Failed to register beacon", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionBeaconRegistered,
+		TargetType: targetBeacon,
+		TargetID:   beacon.ID,
+		After:      beaconSnapshot(&beacon),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"beacon": beacon,
+		"secret": secret,
+	})
+}
+
+// GetBeacons lists the beacons of the admin's organization, by room. "room" filters by room.
+func (h *APIHandler) GetBeacons(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	admin, ok := h.orgAdmin(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"organization_id": admin.OrganizationID}
+	if room := r.URL.Query().Get("room"); room != "" {
+		filter["room"] = room
+	}
+	cursor, err := h.DB.Collection("beacons").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "room", Value: 1}, {Key: "beacon_id", Value: 1}}))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch beacons", err)
+		return
+	}
+	beacons := []database.Beacon{}
+	if err = cursor.All(ctx, &beacons); err != nil {
+		h.internalError(w, r, "Failed to decode beacons", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(beacons)
+}
+
+// DeleteBeacon removes a beacon of the admin's organization. Records of check-ins made with it
+// keep its ID.
+func (h *APIHandler) DeleteBeacon(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "beaconID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid beacon ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	admin, ok := h.orgAdmin(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	var beacon database.Beacon
+	err = h.DB.Collection("beacons").FindOneAndDelete(ctx, bson.M{"_id": id, "organization_id": admin.OrganizationID}).Decode(&beacon)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Beacon not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Failed to delete beacon", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionBeaconDeleted,
+		TargetType: targetBeacon,
+		TargetID:   beacon.ID,
+		Before:     beaconSnapshot(&beacon),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MarkAttendanceWithBeacon marks the student present with an observation of a beacon instead of
+// a QR code: the beacon's ID, the unix time it signed and its signature (see
+// auth.BeaconSignature). The mark goes to the open session of the student's class meeting in the
+// beacon's room.
+func (h *APIHandler) MarkAttendanceWithBeacon(w http.ResponseWriter, r *http.Request) {
+	studentIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	studentID, _ := primitive.ObjectIDFromHex(studentIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	var req struct {
+		BeaconID  string `json:"beaconId"`
+		Timestamp int64  `json:"timestamp"`
+		Signature string `json:"signature"`
+		markDevice
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkInvalidRequest).Inc()
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.BeaconID == "" || req.Signature == "" {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkInvalidRequest).Inc()
+		http.Error(w, `{"error": "beaconId, timestamp and signature are required"}`, http.StatusBadRequest)
+		return
+	}
+	if msg := req.markDevice.validate(h.RequireDeviceID); msg != "" {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkInvalidRequest).Inc()
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var beacon database.Beacon
+	err := h.DB.Collection("beacons").FindOne(ctx, bson.M{"organization_id": orgID, "beacon_id": req.BeaconID}).Decode(&beacon)
+	if err != nil && err != mongo.ErrNoDocuments {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	now := time.Now()
+	signedAt := time.Unix(req.Timestamp, 0)
+	if err == mongo.ErrNoDocuments || signedAt.Before(now.Add(-beaconObservationMaxAge)) || signedAt.After(now.Add(beaconObservationMaxAge)) ||
+		!auth.VerifyBeaconSignature(beacon.Secret, beacon.BeaconID, req.Timestamp, req.Signature) {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkExpiredToken).Inc()
+		http.Error(w, `{"error": "Invalid or expired beacon observation"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// The student's classes that meet in the beacon's room
+	cursor, err := h.DB.Collection("classrooms").Find(ctx,
+		bson.M{"organization_id": orgID, "settings.room": beacon.Room, "student_ids": studentID, "deleted_at": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	var classrooms []database.Classroom
+	if err = cursor.All(ctx, &classrooms); err != nil {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if len(classrooms) == 0 {
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkNotEnrolled).Inc()
+		http.Error(w, `{"error": "Forbidden: You are not enrolled in a class meeting in this room"}`, http.StatusForbidden)
+		return
+	}
+	classIDs := make([]primitive.ObjectID, len(classrooms))
+	for i, classroom := range classrooms {
+		classIDs[i] = classroom.ID
+	}
+
+	var session database.AttendanceSession
+	err = h.DB.Collection("attendance_sessions").FindOne(ctx,
+		bson.M{"classroom_id": bson.M{"$in": classIDs}, "expires_at": bson.M{"$gt": now}},
+		options.FindOne().SetSort(bson.M{"expires_at": -1}),
+	).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			metrics.AttendanceMarks.WithLabelValues(metrics.MarkExpiredToken).Inc()
+			http.Error(w, `{"error": "No attendance session is open in this room"}`, http.StatusNotFound)
+			return
+		}
+		metrics.AttendanceMarks.WithLabelValues(metrics.MarkError).Inc()
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	h.completeMark(ctx, w, r, &session, database.MethodBeacon, req.markDevice, beacon.ID)
+}
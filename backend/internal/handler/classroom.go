@@ -1,16 +1,20 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
+	"math"
 	"net/http"
+	"strings"
+	"time"
 
 	"backend/internal/database" // Use your module name
+	"backend/internal/webhook"
 
 	"github.com/go-chi/chi/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // CreateClass handles the creation of a new classroom.
@@ -22,85 +26,180 @@ func (h *APIHandler) CreateClass(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
 
 	var req struct {
-		Name string `json:"name"`
-		Code string `json:"code"`
+		Name   string              `json:"name"`
+		Code   string              `json:"code"`
+		TermID *primitive.ObjectID `json:"termId"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
 		return
 	}
+	if req.Code == "" {
+		http.Error(w, `{"error": "code is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	if req.TermID != nil {
+		count, err := h.DB.Collection("terms").CountDocuments(ctx, bson.M{"_id": *req.TermID, "organization_id": orgID})
+		if err != nil {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if count == 0 {
+			http.Error(w, `{"error": "Term not found"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// New classes start with the organization's required percentage
+	var org database.Organization
+	if err := h.DB.Collection("organizations").FindOne(ctx, bson.M{"_id": orgID}).Decode(&org); err != nil {
+		h.internalError(w, r, "Failed to load organization", err)
+		return
+	}
 
 	classroomsCollection := h.DB.Collection("classrooms")
 
 	// Create the new classroom document
 	newClass := database.Classroom{
-		ID:           primitive.NewObjectID(),
-		Name:         req.Name,
-		Code:         req.Code,
-		InstructorID: instructorID,
-		StudentIDs:   []primitive.ObjectID{instructorID}, // Instructor is auto-enrolled
+		ID:             primitive.NewObjectID(),
+		Name:           req.Name,
+		Code:           req.Code,
+		InstructorID:   instructorID,
+		StudentIDs:     []primitive.ObjectID{instructorID}, // Instructor is auto-enrolled
+		TermID:         req.TermID,
+		Settings:       database.ClassroomSettings{RequiredPercent: org.Settings.RequiredPercent},
+		OrganizationID: orgID,
 	}
 
-	_, err := classroomsCollection.InsertOne(context.TODO(), newClass)
+	_, err := classroomsCollection.InsertOne(ctx, newClass)
 	if err != nil {
-		// In a real app, you'd check for duplicate code errors specifically
-		http.Error(w, `{"error": "Failed to create classroom"}`, http.StatusInternalServerError)
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, `{"error": "Another classroom already uses this code"}`, http.StatusConflict)
+			return
+		}
+		h.internalError(w, r, "Failed to create classroom", err)
 		return
 	}
 
 	// Add the classroom to the user's list of classrooms
 	usersCollection := h.DB.Collection("users")
 	_, err = usersCollection.UpdateOne(
-		context.TODO(),
+		ctx,
 		bson.M{"_id": instructorID},
 		bson.M{"$addToSet": bson.M{"classroom_ids": newClass.ID}},
 	)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to update user's classrooms list"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to update user's classrooms list", err)
 		return
 	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionClassCreated,
+		TargetType:  targetClassroom,
+		TargetID:    newClass.ID,
+		ClassroomID: &newClass.ID,
+		After:       newClass,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newClass)
 }
 
-// GetMyClasses retrieves all classrooms a user is enrolled in.
+// GetMyClasses retrieves a page of the classrooms a user is enrolled in.
+// The "status" query parameter selects active (default), archived or all classes,
+// and "term" restricts the result to one term. Results are sorted by "sort" (created or
+// name) and paginated with the shared "limit"/"cursor" parameters.
 func (h *APIHandler) GetMyClasses(w http.ResponseWriter, r *http.Request) {
 	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
 	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	page, err := parseListParams(r, map[string]string{"created": "_id", "name": "name"}, "created")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{"organization_id": orgID, "deleted_at": bson.M{"$exists": false}}
+	switch r.URL.Query().Get("status") {
+	case "", "active":
+		filter["archived_at"] = bson.M{"$exists": false}
+	case "archived":
+		filter["archived_at"] = bson.M{"$exists": true}
+	case "all":
+	case "deleted":
+		// Deleted classes are no longer in anyone's classroom_ids, so list the ones the user owns
+		filter = bson.M{"organization_id": orgID, "deleted_at": bson.M{"$exists": true}, "instructor_id": userID}
+	default:
+		http.Error(w, `{"error": "status must be one of active, archived, all or deleted"}`, http.StatusBadRequest)
+		return
+	}
+	if termIDHex := r.URL.Query().Get("term"); termIDHex != "" {
+		termID, err := primitive.ObjectIDFromHex(termIDHex)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid term ID"}`, http.StatusBadRequest)
+			return
+		}
+		filter["term_id"] = termID
+	}
 
 	usersCollection := h.DB.Collection("users")
 	classroomsCollection := h.DB.Collection("classrooms")
 
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
 	var user database.User
-	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": userID}).Decode(&user)
+	err = usersCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
 	if err != nil {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
 		return
 	}
 
-	if len(user.ClassroomIDs) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([]database.Classroom{}) // Return empty JSON array
-		return
+	if _, ok := filter["instructor_id"]; !ok {
+		if len(user.ClassroomIDs) == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode([]database.Classroom{}) // Return empty JSON array
+			return
+		}
+
+		// Find all classrooms where the _id is in the user's list
+		filter["_id"] = bson.M{"$in": user.ClassroomIDs}
+	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		filter["$and"] = bson.A{keyset}
 	}
 
-	// Find all classrooms where the _id is in the user's list
-	cursor, err := classroomsCollection.Find(context.TODO(), bson.M{"_id": bson.M{"$in": user.ClassroomIDs}})
+	findOptions := options.Find().SetSort(page.sort()).SetLimit(int64(page.Limit + 1))
+	cursor, err := classroomsCollection.Find(ctx, filter, findOptions)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to fetch classrooms"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to fetch classrooms", err)
 		return
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
-	var classrooms []database.Classroom
-	if err = cursor.All(context.TODO(), &classrooms); err != nil {
-		http.Error(w, `{"error": "Failed to decode classrooms"}`, http.StatusInternalServerError)
+	classrooms := []database.Classroom{}
+	if err = cursor.All(ctx, &classrooms); err != nil {
+		h.internalError(w, r, "Failed to decode classrooms", err)
 		return
 	}
+	classrooms = paginate(w, r, page, classrooms, func(c database.Classroom) (interface{}, primitive.ObjectID) {
+		if page.SortField == "name" {
+			return c.Name, c.ID
+		}
+		return c.ID, c.ID
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(classrooms)
@@ -110,6 +209,7 @@ func (h *APIHandler) GetMyClasses(w http.ResponseWriter, r *http.Request) {
 func (h *APIHandler) JoinClass(w http.ResponseWriter, r *http.Request) {
 	studentIDHex, _ := r.Context().Value(UserIDContextKey).(string)
 	studentID, _ := primitive.ObjectIDFromHex(studentIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
 
 	var req struct {
 		Code string `json:"code"`
@@ -122,46 +222,69 @@ func (h *APIHandler) JoinClass(w http.ResponseWriter, r *http.Request) {
 	classroomsCollection := h.DB.Collection("classrooms")
 	usersCollection := h.DB.Collection("users")
 
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
 	// Find the classroom by its code
 	var classroom database.Classroom
-	err := classroomsCollection.FindOne(context.TODO(), bson.M{"code": req.Code}).Decode(&classroom)
+	err := classroomsCollection.FindOne(ctx, bson.M{"code": req.Code, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			http.Error(w, `{"error": "Classroom with that code not found"}`, http.StatusNotFound)
 			return
 		}
-		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if classroom.ArchivedAt != nil {
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
+		return
+	}
+	if classroom.Settings.JoinLocked {
+		http.Error(w, `{"error": "This classroom is not accepting new students"}`, http.StatusForbidden)
 		return
 	}
 
-	// Add student to the classroom's student list
-	_, err = classroomsCollection.UpdateOne(
-		context.TODO(),
-		bson.M{"_id": classroom.ID},
-		bson.M{"$addToSet": bson.M{"student_ids": studentID}},
+	// Add student to the classroom's student list, recording when they joined: lectures before
+	// that do not count against them
+	result, err := classroomsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": classroom.ID, "student_ids": bson.M{"$ne": studentID}},
+		bson.M{
+			"$push": bson.M{"student_ids": studentID},
+			"$set":  bson.M{"joined_at." + studentID.Hex(): time.Now()},
+		},
 	)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to add student to classroom"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to add student to classroom", err)
 		return
 	}
 
 	// Add classroom to the student's classroom list
 	_, err = usersCollection.UpdateOne(
-		context.TODO(),
+		ctx,
 		bson.M{"_id": studentID},
 		bson.M{"$addToSet": bson.M{"classroom_ids": classroom.ID}},
 	)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to add classroom to user"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to add classroom to user", err)
 		return
 	}
+	if result.ModifiedCount > 0 {
+		h.audit(ctx, r, database.AuditEntry{
+			Action:      actionClassJoined,
+			TargetType:  targetUser,
+			TargetID:    studentID,
+			ClassroomID: &classroom.ID,
+			After:       bson.M{"code": classroom.Code},
+		})
+		h.emitWebhook(ctx, r, webhook.StudentJoined(classroom.ID, studentID))
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Successfully joined classroom"})
 }
 
-// ... (at the end of the file)
-
 // LeaveClass allows a user to leave a classroom.
 func (h *APIHandler) LeaveClass(w http.ResponseWriter, r *http.Request) {
 	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
@@ -178,29 +301,502 @@ func (h *APIHandler) LeaveClass(w http.ResponseWriter, r *http.Request) {
 	classroomsCollection := h.DB.Collection("classrooms")
 	usersCollection := h.DB.Collection("users")
 
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
 	// Use $pull to remove an item from an array
 	// Remove student from the classroom's student list
-	_, err = classroomsCollection.UpdateOne(
-		context.TODO(),
+	result, err := classroomsCollection.UpdateOne(
+		ctx,
 		bson.M{"_id": classID},
-		bson.M{"$pull": bson.M{"student_ids": userID}},
+		bson.M{"$pull": bson.M{"student_ids": userID}, "$unset": bson.M{"joined_at." + userID.Hex(): ""}},
 	)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to remove student from classroom"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to remove student from classroom", err)
 		return
 	}
 
 	// Remove classroom from the student's classroom list
 	_, err = usersCollection.UpdateOne(
-		context.TODO(),
+		ctx,
 		bson.M{"_id": userID},
 		bson.M{"$pull": bson.M{"classroom_ids": classID}},
 	)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to remove classroom from user"}`, http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to remove classroom from user", err)
 		return
 	}
+	if result.ModifiedCount > 0 {
+		h.audit(ctx, r, database.AuditEntry{Action: actionClassLeft, TargetType: targetUser, TargetID: userID, ClassroomID: &classID})
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Successfully left classroom"})
 }
+
+// ArchiveClass makes a classroom read-only: no new sessions, joins or schedule changes.
+// Attendance records are kept so reports keep working.
+func (h *APIHandler) ArchiveClass(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, true)
+}
+
+// UnarchiveClass makes an archived classroom writable again.
+func (h *APIHandler) UnarchiveClass(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, false)
+}
+
+func (h *APIHandler) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"archived_at": ""}}
+	if archived {
+		update = bson.M{"$set": bson.M{"archived_at": time.Now()}}
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroomsCollection := h.DB.Collection("classrooms")
+	result, err := classroomsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "archived_at": bson.M{"$exists": !archived}, "deleted_at": bson.M{"$exists": false}},
+		update,
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to update classroom", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		count, err := classroomsCollection.CountDocuments(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}})
+		if err != nil {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if count == 0 {
+			http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+			return
+		}
+		// Already in the requested state; treat as success
+	} else {
+		action := actionClassUnarchived
+		if archived {
+			action = actionClassArchived
+		}
+		h.audit(ctx, r, database.AuditEntry{Action: action, TargetType: targetClassroom, TargetID: classID, ClassroomID: &classID, After: update})
+	}
+
+	if archived {
+		// Close any session that is still accepting scans
+		_, err = h.DB.Collection("attendance_sessions").DeleteMany(ctx, bson.M{"classroom_id": classID})
+		if err != nil {
+			h.internalError(w, r, "Failed to close open attendance sessions", err)
+			return
+		}
+	}
+
+	message := "Classroom unarchived"
+	if archived {
+		message = "Classroom archived"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// UpdateClass renames a classroom or changes its code, term or settings.
+// Only the fields present in the request body are changed, settings included; a null geofence
+// removes it.
+func (h *APIHandler) UpdateClass(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name     *string             `json:"name"`
+		Code     *string             `json:"code"`
+		TermID   *primitive.ObjectID `json:"termId"`
+		Settings *struct {
+			JoinLocked       *bool           `json:"joinLocked"`
+			LateAfterMinutes *int            `json:"lateAfterMinutes"`
+			RequiredPercent  *int            `json:"requiredPercent"`
+			Geofence         json.RawMessage `json:"geofence"` // null removes the geofence
+			Room             *string         `json:"room"`
+		} `json:"settings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var classroom database.Classroom
+	classroomsCollection := h.DB.Collection("classrooms")
+	err = classroomsCollection.FindOne(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if classroom.ArchivedAt != nil {
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
+		return
+	}
+	original := classroom
+
+	set := bson.M{}
+	if req.Name != nil {
+		if *req.Name == "" {
+			http.Error(w, `{"error": "name must not be empty"}`, http.StatusBadRequest)
+			return
+		}
+		set["name"] = *req.Name
+		classroom.Name = *req.Name
+	}
+	if req.Code != nil && *req.Code != classroom.Code {
+		count, err := classroomsCollection.CountDocuments(ctx, bson.M{"code": *req.Code, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}})
+		if err != nil {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if count > 0 {
+			http.Error(w, `{"error": "Another classroom already uses this code"}`, http.StatusConflict)
+			return
+		}
+		set["code"] = *req.Code
+		classroom.Code = *req.Code
+	}
+	if req.TermID != nil {
+		count, err := h.DB.Collection("terms").CountDocuments(ctx, bson.M{"_id": *req.TermID, "organization_id": orgID})
+		if err != nil {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if count == 0 {
+			http.Error(w, `{"error": "Term not found"}`, http.StatusBadRequest)
+			return
+		}
+		set["term_id"] = *req.TermID
+		classroom.TermID = req.TermID
+	}
+	if settings := req.Settings; settings != nil {
+		if settings.JoinLocked != nil {
+			set["settings.join_locked"] = *settings.JoinLocked
+			classroom.Settings.JoinLocked = *settings.JoinLocked
+		}
+		if settings.LateAfterMinutes != nil {
+			if *settings.LateAfterMinutes < 0 {
+				http.Error(w, `{"error": "lateAfterMinutes must not be negative"}`, http.StatusBadRequest)
+				return
+			}
+			set["settings.late_after_minutes"] = *settings.LateAfterMinutes
+			classroom.Settings.LateAfterMinutes = *settings.LateAfterMinutes
+		}
+		if settings.RequiredPercent != nil {
+			if *settings.RequiredPercent < 0 || *settings.RequiredPercent > 100 {
+				http.Error(w, `{"error": "requiredPercent must be between 0 and 100"}`, http.StatusBadRequest)
+				return
+			}
+			set["settings.required_percent"] = *settings.RequiredPercent
+			classroom.Settings.RequiredPercent = *settings.RequiredPercent
+		}
+		if len(settings.Geofence) > 0 {
+			var fence *database.Geofence
+			if err := json.Unmarshal(settings.Geofence, &fence); err != nil {
+				http.Error(w, `{"error": "Invalid geofence"}`, http.StatusBadRequest)
+				return
+			}
+			if fence != nil && (math.Abs(fence.Latitude) > 90 || math.Abs(fence.Longitude) > 180 || fence.RadiusMeters <= 0) {
+				http.Error(w, `{"error": "geofence needs a valid latitude, longitude and a positive radiusMeters"}`, http.StatusBadRequest)
+				return
+			}
+			set["settings.geofence"] = fence
+			classroom.Settings.Geofence = fence
+		}
+		if settings.Room != nil {
+			room := strings.TrimSpace(*settings.Room)
+			if len(room) > 100 {
+				http.Error(w, `{"error": "room must be at most 100 characters"}`, http.StatusBadRequest)
+				return
+			}
+			set["settings.room"] = room
+			classroom.Settings.Room = room
+		}
+	}
+
+	if len(set) > 0 {
+		_, err = classroomsCollection.UpdateOne(ctx, bson.M{"_id": classID}, bson.M{"$set": set})
+		if err != nil {
+			// Another class took the code since it was checked
+			if mongo.IsDuplicateKeyError(err) {
+				http.Error(w, `{"error": "Another classroom already uses this code"}`, http.StatusConflict)
+				return
+			}
+			h.internalError(w, r, "Failed to update classroom", err)
+			return
+		}
+		h.audit(ctx, r, database.AuditEntry{
+			Action:      actionClassUpdated,
+			TargetType:  targetClassroom,
+			TargetID:    classID,
+			ClassroomID: &classID,
+			Before:      snapshotFields(original, set),
+			After:       set,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(classroom)
+}
+
+// TransferClass hands a classroom over to another user, identified by email.
+// The new instructor is enrolled automatically; the previous one stays enrolled until they leave.
+func (h *APIHandler) TransferClass(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body, expected 'email'"}`, http.StatusBadRequest)
+		return
+	}
+
+	var classroom database.Classroom
+	classroomsCollection := h.DB.Collection("classrooms")
+	usersCollection := h.DB.Collection("users")
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	err = classroomsCollection.FindOne(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if classroom.ArchivedAt != nil {
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
+		return
+	}
+
+	var newInstructor database.User
+	err = usersCollection.FindOne(ctx, bson.M{"email": req.Email, "organization_id": orgID}).Decode(&newInstructor)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "No user with that email"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	_, err = classroomsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": classID},
+		bson.M{
+			"$set":      bson.M{"instructor_id": newInstructor.ID},
+			"$addToSet": bson.M{"student_ids": newInstructor.ID},
+		},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to transfer classroom", err)
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": newInstructor.ID},
+		bson.M{"$addToSet": bson.M{"classroom_ids": classID}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to update new instructor's classrooms list", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionClassTransferred,
+		TargetType:  targetClassroom,
+		TargetID:    classID,
+		ClassroomID: &classID,
+		Before:      bson.M{"instructor_id": classroom.InstructorID},
+		After:       bson.M{"instructor_id": newInstructor.ID},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Classroom transferred"})
+}
+
+// DeleteClass soft-deletes a classroom. It disappears from every member's class list and its
+// open sessions are closed; attendance records are kept until the restore window runs out.
+func (h *APIHandler) DeleteClass(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	now := time.Now()
+	classroomsCollection := h.DB.Collection("classrooms")
+	result, err := classroomsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deleted_at": now}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to delete classroom", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+		return
+	}
+
+	_, err = h.DB.Collection("users").UpdateMany(
+		ctx,
+		bson.M{"classroom_ids": classID},
+		bson.M{"$pull": bson.M{"classroom_ids": classID}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to remove classroom from users", err)
+		return
+	}
+
+	_, err = h.DB.Collection("attendance_sessions").DeleteMany(ctx, bson.M{"classroom_id": classID})
+	if err != nil {
+		h.internalError(w, r, "Failed to close open attendance sessions", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionClassDeleted,
+		TargetType:  targetClassroom,
+		TargetID:    classID,
+		ClassroomID: &classID,
+		After:       bson.M{"deleted_at": now},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Classroom deleted",
+		"restoreUntil": now.Add(database.ClassroomRestoreWindow),
+	})
+}
+
+// RestoreClass undoes DeleteClass while the restore window is still open.
+func (h *APIHandler) RestoreClass(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID format"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var classroom database.Classroom
+	classroomsCollection := h.DB.Collection("classrooms")
+	restorable := bson.M{
+		"_id":             classID,
+		"instructor_id":   instructorID,
+		"organization_id": orgID,
+		"deleted_at":      bson.M{"$gt": time.Now().Add(-database.ClassroomRestoreWindow)},
+	}
+	err = classroomsCollection.FindOne(ctx, restorable, options.FindOne().SetProjection(bson.M{"code": 1})).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "No restorable classroom found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	// The code may have been given to another class since this one was deleted
+	if classroom.Code != "" {
+		count, err := classroomsCollection.CountDocuments(ctx, bson.M{"code": classroom.Code, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}})
+		if err != nil {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if count > 0 {
+			http.Error(w, `{"error": "Another classroom now uses this code; change its code first"}`, http.StatusConflict)
+			return
+		}
+	}
+
+	err = classroomsCollection.FindOneAndUpdate(ctx, restorable, bson.M{"$unset": bson.M{"deleted_at": ""}}).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "No restorable classroom found"}`, http.StatusNotFound)
+			return
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, `{"error": "Another classroom now uses this code; change its code first"}`, http.StatusConflict)
+			return
+		}
+		h.internalError(w, r, "Failed to restore classroom", err)
+		return
+	}
+
+	_, err = h.DB.Collection("users").UpdateMany(
+		ctx,
+		bson.M{"_id": bson.M{"$in": classroom.StudentIDs}},
+		bson.M{"$addToSet": bson.M{"classroom_ids": classID}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to add classroom back to users", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionClassRestored,
+		TargetType:  targetClassroom,
+		TargetID:    classID,
+		ClassroomID: &classID,
+		Before:      bson.M{"deleted_at": classroom.DeletedAt},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Classroom restored"})
+}
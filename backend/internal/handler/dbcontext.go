@@ -0,0 +1,36 @@
+// File: internal/handler/dbcontext.go
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// queryContext derives a context for ordinary store calls from the request, so that a client
+// disconnect cancels the work, bounded by the configured query timeout.
+func (h *APIHandler) queryContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), h.QueryTimeout)
+}
+
+// aggregateContext is like queryContext but uses the longer aggregation timeout.
+func (h *APIHandler) aggregateContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), h.AggregateTimeout)
+}
+
+// dbErrorStatus maps store errors caused by timeouts or an unreachable database to
+// 504 and 503 respectively. Anything else is a plain 500.
+func dbErrorStatus(err error) (int, string) {
+	// Server selection is checked first: it also reports as a timeout when no server answers
+	switch {
+	case errors.As(err, &topology.ServerSelectionError{}) || mongo.IsNetworkError(err):
+		return http.StatusServiceUnavailable, "Database unavailable"
+	case errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err):
+		return http.StatusGatewayTimeout, "Database timed out"
+	}
+	return http.StatusInternalServerError, ""
+}
@@ -0,0 +1,182 @@
+// File: internal/handler/device.go
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"backend/internal/database"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxDeviceIDLength bounds the device identifier clients send with a scan.
+const maxDeviceIDLength = 128
+
+// GetMyDevices lists the devices bound to the logged-in user's account. Students cannot unbind
+// devices themselves, otherwise the limit would not stop them lending their account.
+func (h *APIHandler) GetMyDevices(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var user database.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if user.Devices == nil {
+		user.Devices = []database.Device{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"devices":    user.Devices,
+		"maxDevices": h.MaxDevices,
+	})
+}
+
+// ResetStudentDevices unbinds every device of a student enrolled in the instructor's class,
+// for example after the student replaced their phone.
+func (h *APIHandler) ResetStudentDevices(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "classID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
+		return
+	}
+	studentID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "studentID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid student ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroomsCollection := h.DB.Collection("classrooms")
+	count, err := classroomsCollection.CountDocuments(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count == 0 {
+		http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+		return
+	}
+	count, err = classroomsCollection.CountDocuments(ctx, bson.M{"_id": classID, "student_ids": studentID, "organization_id": orgID})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count == 0 {
+		http.Error(w, `{"error": "The student is not enrolled in this class"}`, http.StatusNotFound)
+		return
+	}
+
+	var before database.User
+	err = h.DB.Collection("users").FindOneAndUpdate(ctx, bson.M{"_id": studentID}, bson.M{"$unset": bson.M{"devices": ""}}).Decode(&before)
+	if err != nil && err != mongo.ErrNoDocuments {
+		h.internalError(w, r, "Failed to reset devices", err)
+		return
+	}
+	h.logger(r).Info("Student devices reset", "class_id", classID.Hex(), "student_id", studentID.Hex())
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionUserDevicesReset,
+		TargetType:  targetUser,
+		TargetID:    studentID,
+		ClassroomID: &classID,
+		Before:      bson.M{"devices": before.Devices},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Devices reset"})
+}
+
+// GetSharedDeviceFlags lists, newest lecture first, the cases where one device marked attendance
+// for several accounts in the same lecture of a class. It accepts "from"/"to" on the scan time.
+func (h *APIHandler) GetSharedDeviceFlags(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	match := bson.M{"classroom_id": classroom.ID, "flags": database.FlagSharedDevice}
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeRange != nil {
+		match["timestamp"] = timeRange
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "users",
+			"localField":   "user_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$user", "preserveNullAndEmptyArrays": true}}},
+		{{Key: "$sort", Value: bson.M{"timestamp": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"lecture_id": "$lecture_id", "device_id": "$device_id"},
+			"students": bson.M{"$push": bson.M{
+				"user_id":   "$user_id",
+				"name":      "$user.name",
+				"email":     "$user.email",
+				"timestamp": "$timestamp",
+			}},
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "lectures",
+			"localField":   "_id.lecture_id",
+			"foreignField": "_id",
+			"as":           "lecture",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"lecture_id": "$_id.lecture_id",
+			"device_id":  "$_id.device_id",
+			"started_at": bson.M{"$arrayElemAt": bson.A{"$lecture.started_at", 0}},
+			"students":   1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "started_at", Value: -1}, {Key: "device_id", Value: 1}}}},
+	}
+
+	cursor, err := h.DB.Collection("attendance_records").Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(h.AggregateTimeout))
+	if err != nil {
+		h.internalError(w, r, "Failed to aggregate device flags", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	flags := []database.SharedDeviceFlag{}
+	if err = cursor.All(ctx, &flags); err != nil {
+		h.internalError(w, r, "Failed to decode device flags", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flags)
+}
@@ -0,0 +1,105 @@
+// File: internal/handler/health.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// BeginShutdown makes Readyz fail so load balancers stop routing new traffic here
+// while in-flight requests finish.
+func (h *APIHandler) BeginShutdown() {
+	h.shuttingDown.Store(true)
+}
+
+// Healthz is the liveness probe: it only reports that the process is serving HTTP.
+func (h *APIHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Readyz is the readiness probe. It pings MongoDB and checks that the required indexes exist,
+// and fails once graceful shutdown has started.
+func (h *APIHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := map[string]string{}
+	ready := true
+
+	if h.shuttingDown.Load() {
+		checks["shutdown"] = "in progress"
+		ready = false
+	}
+
+	if err := h.DB.Client().Ping(ctx, nil); err != nil {
+		h.logger(r).Warn("Readiness check: MongoDB ping failed", "error", err)
+		checks["mongo"] = "unreachable"
+		ready = false
+	} else {
+		checks["mongo"] = "ok"
+		for collection, names := range database.RequiredIndexes {
+			if missing, err := h.missingIndexes(ctx, collection, names); err != nil {
+				h.logger(r).Warn("Readiness check: listing indexes failed", "collection", collection, "error", err)
+				checks["indexes."+collection] = "unavailable"
+				ready = false
+			} else if len(missing) > 0 {
+				checks["indexes."+collection] = "missing " + missing[0]
+				ready = false
+			} else {
+				checks["indexes."+collection] = "ok"
+			}
+		}
+	}
+
+	status := "ready"
+	code := http.StatusOK
+	if !ready {
+		status = "not ready"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        status,
+		"version":       h.Version,
+		"uptimeSeconds": int64(time.Since(h.StartedAt).Seconds()),
+		"checks":        checks,
+	})
+}
+
+// missingIndexes returns the names in want that are not defined on the collection.
+func (h *APIHandler) missingIndexes(ctx context.Context, collection string, want []string) ([]string, error) {
+	cursor, err := h.DB.Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(indexes))
+	for _, index := range indexes {
+		if name, ok := index["name"].(string); ok {
+			present[name] = true
+		}
+	}
+
+	var missing []string
+	for _, name := range want {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,258 @@
+// File: internal/handler/identity.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// errNoLinkedUser is returned by linkedUser when no user matches and provisioning is off.
+	errNoLinkedUser = errors.New("no user is linked to this identity")
+	// errLinkRefused is returned by linkedUser when the account with the email operates the
+	// platform, which is never linked by email.
+	errLinkRefused = errors.New("the account with this email cannot be linked automatically")
+)
+
+// linkConfirmationError is returned by linkedUser when the account with the email has a
+// password. The user links the external account by sending Token and their password to
+// LinkIdentity within database.IdentityLinkWindow.
+type linkConfirmationError struct {
+	Token string
+}
+
+func (e *linkConfirmationError) Error() string {
+	return "the account with this email must confirm the link with its password"
+}
+
+// externalAccount is what an identity provider asserts about the person signing in.
+type externalAccount struct {
+	Provider      string
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool // Whether the provider vouches for Email; unverified addresses are never linked
+	Name          string
+	Organization  string // Slug of the organization new users join; by default the email's
+	// SameOrganization restricts linking by email to users of the organization new users join.
+	SameOrganization bool
+}
+
+// linkedUser finds the user of an external account by its identity, then by email, linking the
+// account to that user. Platform operators are never linked by email, and accounts with a
+// password only once the user confirms with it (see linkConfirmationError). With provision set a user is created if neither matches, in the organization
+// resolveOrganization picks; such users have no password. Links and new users are audited as
+// the user's own actions.
+func (h *APIHandler) linkedUser(ctx context.Context, r *http.Request, account externalAccount, provision bool) (*database.User, error) {
+	usersCollection := h.DB.Collection("users")
+	byIdentity := bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": account.Issuer, "subject": account.Subject}}}
+	identity := database.Identity{Provider: account.Provider, Issuer: account.Issuer, Subject: account.Subject, LinkedAt: time.Now()}
+
+	var user database.User
+	err := usersCollection.FindOne(ctx, byIdentity).Decode(&user)
+	if err != mongo.ErrNoDocuments {
+		return &user, err
+	}
+
+	if account.Email != "" && account.EmailVerified {
+		byEmail := bson.M{"email": account.Email}
+		if account.SameOrganization {
+			org, err := h.resolveOrganization(ctx, account.Organization, account.Email)
+			if err != nil {
+				return nil, err
+			}
+			byEmail["organization_id"] = org.ID
+		}
+		var match database.User
+		err = usersCollection.FindOne(ctx, byEmail, options.FindOne().SetProjection(bson.M{"password": 1, "platform_admin": 1})).Decode(&match)
+		if err == nil && match.PlatformAdmin {
+			return nil, errLinkRefused
+		}
+		if err == nil && match.Password != "" {
+			token, err := h.startIdentityLink(ctx, match.ID, identity)
+			if err != nil {
+				return nil, err
+			}
+			return nil, &linkConfirmationError{Token: token}
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if err == nil {
+			// The account may have changed since it was matched
+			err = usersCollection.FindOneAndUpdate(ctx,
+				bson.M{"_id": match.ID, "password": bson.M{"$in": bson.A{"", nil}}, "platform_admin": bson.M{"$ne": true}},
+				bson.M{"$push": bson.M{"identities": identity}},
+				options.FindOneAndUpdate().SetReturnDocument(options.After),
+			).Decode(&user)
+			if err == mongo.ErrNoDocuments {
+				return nil, errLinkRefused
+			}
+		}
+		if err == nil {
+			h.audit(ctx, r, database.AuditEntry{
+				ActorID:        user.ID,
+				OrganizationID: user.OrganizationID,
+				Action:         actionUserIdentityLinked,
+				TargetType:     targetUser,
+				TargetID:       user.ID,
+				After:          bson.M{"provider": identity.Provider, "issuer": identity.Issuer, "subject": identity.Subject},
+			})
+		}
+		if err != mongo.ErrNoDocuments {
+			return &user, err
+		}
+	}
+	if !provision {
+		return nil, errNoLinkedUser
+	}
+
+	email := account.Email
+	if !account.EmailVerified {
+		email = ""
+	}
+	org, err := h.resolveOrganization(ctx, account.Organization, email)
+	if err != nil {
+		return nil, err
+	}
+	user = database.User{
+		ID:             primitive.NewObjectID(),
+		Name:           account.Name,
+		Email:          email,
+		ClassroomIDs:   []primitive.ObjectID{},
+		Identities:     []database.Identity{identity},
+		OrganizationID: org.ID,
+	}
+	if _, err := usersCollection.InsertOne(ctx, user); err != nil {
+		// A concurrent sign-in of the same account created it first
+		if mongo.IsDuplicateKeyError(err) {
+			err = usersCollection.FindOne(ctx, byIdentity).Decode(&user)
+		}
+		return &user, err
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		ActorID:        user.ID,
+		OrganizationID: user.OrganizationID,
+		Action:         actionUserProvisioned,
+		TargetType:     targetUser,
+		TargetID:       user.ID,
+		After:          userSnapshot(&user),
+	})
+	return &user, nil
+}
+
+// startIdentityLink records an identity waiting to be linked to a user who confirms with their
+// password, returning the token that names it.
+func (h *APIHandler) startIdentityLink(ctx context.Context, userID primitive.ObjectID, identity database.Identity) (string, error) {
+	token, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = h.DB.Collection("identity_links").InsertOne(ctx, bson.M{
+		"_id":        auth.HashToken(token),
+		"user_id":    userID,
+		"identity":   identity,
+		"created_at": time.Now(),
+	})
+	return token, err
+}
+
+// writeLinkConfirmation answers a sign-in that needs the user to confirm linking with their
+// password: the link token goes to redirect in the URL fragment ("linkToken"), or is returned
+// as JSON with 409 without one.
+func writeLinkConfirmation(w http.ResponseWriter, r *http.Request, redirect string, link *linkConfirmationError) {
+	if redirect != "" {
+		fragment := url.Values{"linkToken": {link.Token}}
+		http.Redirect(w, r, redirect+"#"+fragment.Encode(), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":     "An account with your email already exists; sign in with its password and confirm the link",
+		"linkToken": link.Token,
+	})
+}
+
+// LinkIdentity links the external account of a sign-in that matched the user by email, once
+// they confirm with their password. The link token comes from that sign-in.
+func (h *APIHandler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req struct {
+		LinkToken string `json:"linkToken"`
+		Password  string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.LinkToken == "" {
+		http.Error(w, `{"error": "linkToken is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := h.checkPassword(w, r, userID, req.Password)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var link struct {
+		Identity database.Identity `bson:"identity"`
+	}
+	err := h.DB.Collection("identity_links").FindOneAndDelete(ctx, bson.M{
+		"_id":        auth.HashToken(req.LinkToken),
+		"user_id":    user.ID,
+		"created_at": bson.M{"$gt": time.Now().Add(-database.IdentityLinkWindow)},
+	}).Decode(&link)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Invalid or expired link token; sign in again"}`, http.StatusBadRequest)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	identity := link.Identity
+	identity.LinkedAt = time.Now()
+	_, err = h.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$push": bson.M{"identities": identity}})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, `{"error": "This account is already linked to another user"}`, http.StatusConflict)
+			return
+		}
+		h.internalError(w, r, "Failed to link account", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionUserIdentityLinked,
+		TargetType: targetUser,
+		TargetID:   user.ID,
+		After:      bson.M{"provider": identity.Provider, "issuer": identity.Issuer, "subject": identity.Subject},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Account linked"})
+}
@@ -0,0 +1,476 @@
+// File: internal/handler/kiosk.go
+
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+	"backend/internal/schedule"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// kioskCredentialMarker starts every kiosk credential, which reads "kk_<prefix>_<secret>".
+	kioskCredentialMarker = "kk_"
+	kioskPairingDigits    = 8
+	// kioskRotation is how often a kiosk shows a new QR code. Each code stays valid for
+	// qrSessionTTL, so a student scanning just before it changes is still counted.
+	kioskRotation = 15 * time.Second
+	// kioskIdlePoll is how long a kiosk waits to ask again while no lecture is under way.
+	kioskIdlePoll = 30 * time.Second
+	// kioskSeenInterval is how stale a kiosk's last contact may get before a request records it again.
+	kioskSeenInterval = time.Minute
+	maxKiosksPerClass = 10
+)
+
+// KioskContextKey holds the *database.Kiosk a request authenticated as.
+const KioskContextKey = contextKey("kiosk")
+
+// newPairingCode generates a pairing code and its expiry.
+func newPairingCode() (string, time.Time, error) {
+	code, err := auth.GeneratePIN(kioskPairingDigits)
+	return code, time.Now().Add(database.KioskPairingTTL), err
+}
+
+// KioskMiddleware authenticates kiosks by the credential they received when they registered.
+func (h *APIHandler) KioskMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		prefix, _, found := strings.Cut(strings.TrimPrefix(credential, kioskCredentialMarker), "_")
+		if !strings.HasPrefix(credential, kioskCredentialMarker) || !found || prefix == "" {
+			http.Error(w, `{"error": "Kiosk credential required"}`, http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := h.queryContext(r)
+		defer cancel()
+
+		var kiosk database.Kiosk
+		err := h.DB.Collection("kiosks").FindOne(ctx, bson.M{"prefix": prefix}).Decode(&kiosk)
+		if err != nil && err != mongo.ErrNoDocuments {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		// Unpaired kiosks are removed once their pairing code expires; they must register again
+		if err == mongo.ErrNoDocuments || subtle.ConstantTimeCompare([]byte(auth.HashToken(credential)), []byte(kiosk.CredentialHash)) != 1 {
+			http.Error(w, `{"error": "Invalid kiosk credential"}`, http.StatusUnauthorized)
+			return
+		}
+
+		now := time.Now()
+		if kiosk.LastSeenAt == nil || now.Sub(*kiosk.LastSeenAt) >= kioskSeenInterval {
+			_, err := h.DB.Collection("kiosks").UpdateOne(ctx,
+				bson.M{"_id": kiosk.ID, "$or": bson.A{
+					bson.M{"last_seen_at": bson.M{"$exists": false}},
+					bson.M{"last_seen_at": bson.M{"$lt": now.Add(-kioskSeenInterval)}},
+				}},
+				bson.M{"$set": bson.M{"last_seen_at": now}},
+			)
+			if err != nil {
+				h.logger(r).Warn("Failed to record kiosk contact", "kiosk_id", kiosk.ID.Hex(), "error", err)
+			}
+		}
+
+		if entry, ok := r.Context().Value(logEntryContextKey).(*logEntry); ok {
+			entry.logger = entry.logger.With("kiosk_id", kiosk.ID.Hex())
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), KioskContextKey, &kiosk)))
+	})
+}
+
+// RegisterKiosk registers a new kiosk. It needs no sign-in: the response holds the kiosk's
+// credential, which is not shown again, and a pairing code for an instructor to enter.
+func (h *APIHandler) RegisterKiosk(w http.ResponseWriter, r *http.Request) {
+	// The body is optional
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > 100 {
+		http.Error(w, `{"error": "name must be at most 100 characters"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	// Retry the rare collision of a prefix or pairing code with another kiosk's
+	for attempt := 0; ; attempt++ {
+		prefix, err := auth.GenerateSecureToken(6)
+		if err != nil {
+			h.internalError(w, r, "Failed to generate kiosk credential", err)
+			return
+		}
+		secret, err := auth.GenerateSecureToken(32)
+		if err != nil {
+			h.internalError(w, r, "Failed to generate kiosk credential", err)
+			return
+		}
+		code, codeExpiresAt, err := newPairingCode()
+		if err != nil {
+			h.internalError(w, r, "Failed to generate pairing code", err)
+			return
+		}
+		credential := kioskCredentialMarker + prefix + "_" + secret
+
+		kiosk := database.Kiosk{
+			ID:               primitive.NewObjectID(),
+			Name:             req.Name,
+			Prefix:           prefix,
+			CredentialHash:   auth.HashToken(credential),
+			PairingCode:      code,
+			PairingExpiresAt: &codeExpiresAt,
+			CreatedAt:        time.Now(),
+		}
+		if _, err := h.DB.Collection("kiosks").InsertOne(ctx, kiosk); err != nil {
+			if mongo.IsDuplicateKeyError(err) && attempt < 3 {
+				continue
+			}
+			h.internalError(w, r, "Failed to register kiosk", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"kiosk":            kiosk,
+			"credential":       credential,
+			"pairingCode":      code,
+			"pairingExpiresAt": codeExpiresAt,
+		})
+		return
+	}
+}
+
+// GetKiosk tells a kiosk how it is set up: its class and room once paired, its pairing code
+// before.
+func (h *APIHandler) GetKiosk(w http.ResponseWriter, r *http.Request) {
+	kiosk := r.Context().Value(KioskContextKey).(*database.Kiosk)
+
+	resp := map[string]interface{}{"kiosk": kiosk, "paired": kiosk.ClassroomID != nil}
+	if kiosk.ClassroomID == nil {
+		if kiosk.PairingCode != "" {
+			resp["pairingCode"] = kiosk.PairingCode
+			resp["pairingExpiresAt"] = kiosk.PairingExpiresAt
+		}
+	} else {
+		ctx, cancel := h.queryContext(r)
+		defer cancel()
+
+		var classroom database.Classroom
+		err := h.DB.Collection("classrooms").FindOne(ctx, bson.M{"_id": kiosk.ClassroomID, "deleted_at": bson.M{"$exists": false}},
+			options.FindOne().SetProjection(bson.M{"name": 1, "code": 1})).Decode(&classroom)
+		if err != nil && err != mongo.ErrNoDocuments {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if err == nil {
+			resp["classroom"] = bson.M{"id": classroom.ID, "name": classroom.Name, "code": classroom.Code}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RequestKioskPairingCode gives an unpaired kiosk a new pairing code, replacing an expired or
+// unused one. A kiosk that is still not paired when the code expires is removed.
+func (h *APIHandler) RequestKioskPairingCode(w http.ResponseWriter, r *http.Request) {
+	kiosk := r.Context().Value(KioskContextKey).(*database.Kiosk)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		code, codeExpiresAt, err := newPairingCode()
+		if err != nil {
+			h.internalError(w, r, "Failed to generate pairing code", err)
+			return
+		}
+		result, err := h.DB.Collection("kiosks").UpdateOne(ctx,
+			bson.M{"_id": kiosk.ID, "classroom_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"pairing_code": code, "pairing_expires_at": codeExpiresAt}},
+		)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) && attempt < 3 {
+				continue
+			}
+			h.internalError(w, r, "Failed to set pairing code", err)
+			return
+		}
+		if result.MatchedCount == 0 {
+			http.Error(w, `{"error": "Kiosk is already paired; ask the instructor to unpair it first"}`, http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pairingCode":      code,
+			"pairingExpiresAt": codeExpiresAt,
+		})
+		return
+	}
+}
+
+// GetKioskQR returns the QR code a paired kiosk should display. While a lecture is under way -
+// during a scheduled meeting of the class, or while a session its instructor or the scheduler
+// opened is open - the kiosk opens a session of its own every kioskRotation and returns its
+// token. Otherwise "active" is false. "refreshAfter" says when to poll again.
+func (h *APIHandler) GetKioskQR(w http.ResponseWriter, r *http.Request) {
+	kiosk := r.Context().Value(KioskContextKey).(*database.Kiosk)
+	if kiosk.ClassroomID == nil {
+		http.Error(w, `{"error": "Kiosk is not paired with a class"}`, http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	now := time.Now()
+	idle := map[string]interface{}{"active": false, "refreshAfter": now.Add(kioskIdlePoll)}
+
+	var classroom database.Classroom
+	err := h.DB.Collection("classrooms").FindOne(ctx, bson.M{"_id": kiosk.ClassroomID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
+	if err != nil && err != mongo.ErrNoDocuments {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if err == mongo.ErrNoDocuments || classroom.ArchivedAt != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(idle)
+		return
+	}
+	idle["classroom"] = bson.M{"id": classroom.ID, "name": classroom.Name, "code": classroom.Code}
+
+	sessionsCollection := h.DB.Collection("attendance_sessions")
+	var meeting *database.Meeting
+	if classroom.Schedule != nil {
+		meeting, _ = schedule.MeetingAt(classroom.Schedule, now)
+	}
+	if meeting == nil {
+		// Kiosk sessions do not count, or a kiosk would keep its lecture going forever
+		open, err := sessionsCollection.CountDocuments(ctx, bson.M{
+			"classroom_id": classroom.ID,
+			"expires_at":   bson.M{"$gt": now},
+			"kiosk_id":     bson.M{"$exists": false},
+		})
+		if err != nil {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if open == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(idle)
+			return
+		}
+	}
+
+	var session database.AttendanceSession
+	err = sessionsCollection.FindOne(ctx,
+		bson.M{"classroom_id": classroom.ID, "kiosk_id": kiosk.ID, "created_at": bson.M{"$gt": now.Add(-kioskRotation)}},
+		options.FindOne().SetSort(bson.M{"created_at": -1}),
+	).Decode(&session)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		opened, err := h.openAttendanceSession(ctx, r, &classroom, false, kiosk.ID)
+		if err != nil {
+			h.internalError(w, r, "Failed to create attendance session", err)
+			return
+		}
+		session = *opened
+	}
+
+	resp := map[string]interface{}{
+		"active":          true,
+		"attendanceToken": session.Token,
+		"expiresAt":       session.ExpiresAt,
+		"refreshAfter":    session.CreatedAt.Add(kioskRotation),
+		"classroom":       idle["classroom"],
+	}
+	if kiosk.Room != "" {
+		resp["room"] = kiosk.Room
+	}
+	if session.Meeting != nil {
+		resp["meeting"] = session.Meeting
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// kioskSnapshot is the part of a kiosk recorded in the audit log.
+func kioskSnapshot(kiosk *database.Kiosk) bson.M {
+	return bson.M{"name": kiosk.Name, "classroom_id": kiosk.ClassroomID, "room": kiosk.Room}
+}
+
+// PairKiosk pairs the kiosk showing "pairingCode" with the instructor's class, optionally
+// naming the "room" it is mounted in.
+func (h *APIHandler) PairKiosk(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PairingCode string `json:"pairingCode"`
+		Room        string `json:"room"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	req.PairingCode = strings.TrimSpace(req.PairingCode)
+	req.Room = strings.TrimSpace(req.Room)
+	if req.PairingCode == "" {
+		http.Error(w, `{"error": "pairingCode is required"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Room) > 100 {
+		http.Error(w, `{"error": "room must be at most 100 characters"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	if classroom.ArchivedAt != nil {
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
+		return
+	}
+
+	kiosksCollection := h.DB.Collection("kiosks")
+	count, err := kiosksCollection.CountDocuments(ctx, bson.M{"classroom_id": classroom.ID})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count >= maxKiosksPerClass {
+		http.Error(w, `{"error": "Too many kiosks; unpair one first"}`, http.StatusConflict)
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	now := time.Now()
+	set := bson.M{
+		"classroom_id":    classroom.ID,
+		"organization_id": classroom.OrganizationID,
+		"paired_by":       userID,
+		"paired_at":       now,
+	}
+	update := bson.M{"$set": set, "$unset": bson.M{"pairing_code": "", "pairing_expires_at": ""}}
+	if req.Room != "" {
+		set["room"] = req.Room
+	} else {
+		update["$unset"].(bson.M)["room"] = ""
+	}
+
+	var kiosk database.Kiosk
+	err = kiosksCollection.FindOneAndUpdate(ctx,
+		bson.M{"pairing_code": req.PairingCode, "pairing_expires_at": bson.M{"$gt": now}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&kiosk)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Pairing code not found or expired"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Failed to pair kiosk", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionKioskPaired,
+		TargetType:  targetKiosk,
+		TargetID:    kiosk.ID,
+		ClassroomID: &classroom.ID,
+		After:       kioskSnapshot(&kiosk),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kiosk)
+}
+
+// GetClassKiosks lists the kiosks paired with the instructor's class.
+func (h *APIHandler) GetClassKiosks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	cursor, err := h.DB.Collection("kiosks").Find(ctx, bson.M{"classroom_id": classroom.ID}, options.Find().SetSort(bson.M{"paired_at": 1}))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch kiosks", err)
+		return
+	}
+	kiosks := []database.Kiosk{}
+	if err = cursor.All(ctx, &kiosks); err != nil {
+		h.internalError(w, r, "Failed to decode kiosks", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kiosks)
+}
+
+// UnpairKiosk detaches a kiosk from the instructor's class. The kiosk keeps its credential and
+// can request a new pairing code.
+func (h *APIHandler) UnpairKiosk(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	kioskID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "kioskID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid kiosk ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var kiosk database.Kiosk
+	err = h.DB.Collection("kiosks").FindOneAndUpdate(ctx,
+		bson.M{"_id": kioskID, "classroom_id": classroom.ID},
+		bson.M{"$unset": bson.M{"classroom_id": "", "organization_id": "", "room": "", "paired_by": "", "paired_at": ""}},
+	).Decode(&kiosk)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Kiosk not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Failed to unpair kiosk", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionKioskUnpaired,
+		TargetType:  targetKiosk,
+		TargetID:    kiosk.ID,
+		ClassroomID: &classroom.ID,
+		Before:      kioskSnapshot(&kiosk),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,377 @@
+// File: internal/handler/lecture.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/internal/database"
+	"backend/internal/notify"
+	"backend/internal/schedule"
+	"backend/internal/webhook"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetClassLectures lists a page of the lectures held for a class, newest first by default.
+// It accepts "from"/"to" on the start time and the shared "limit"/"cursor"/"sort" parameters.
+func (h *APIHandler) GetClassLectures(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	page, err := parseListParams(r, map[string]string{"startedAt": "started_at"}, "-startedAt")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := bson.M{"classroom_id": classID}
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeRange != nil {
+		filter["started_at"] = timeRange
+	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		filter = bson.M{"$and": bson.A{filter, keyset}}
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroomsCollection := h.DB.Collection("classrooms")
+	count, err := classroomsCollection.CountDocuments(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count == 0 {
+		http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+		return
+	}
+
+	lecturesCollection := h.DB.Collection("lectures")
+	opts := options.Find().SetSort(page.sort()).SetLimit(int64(page.Limit + 1))
+	cursor, err := lecturesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch lectures", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	lectures := []database.Lecture{}
+	if err = cursor.All(ctx, &lectures); err != nil {
+		h.internalError(w, r, "Failed to decode lectures", err)
+		return
+	}
+	lectures = paginate(w, r, page, lectures, func(l database.Lecture) (interface{}, primitive.ObjectID) {
+		return l.StartedAt, l.ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lectures)
+}
+
+// StartLecture opens a lecture without an attendance session, for taking attendance by roll
+// call. Like opening a session, it continues a lecture that is already running.
+func (h *APIHandler) StartLecture(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	if classroom.ArchivedAt != nil {
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	var meeting *database.Meeting
+	if classroom.Schedule != nil {
+		meeting, _ = schedule.MeetingAt(classroom.Schedule, now)
+	}
+	lecture, created, err := database.OpenLecture(ctx, h.DB, classroom.ID, meeting, now)
+	if err != nil {
+		h.internalError(w, r, "Failed to open lecture", err)
+		return
+	}
+	if created {
+		h.audit(ctx, r, database.AuditEntry{
+			Action:      actionLectureStarted,
+			TargetType:  targetLecture,
+			TargetID:    lecture.ID,
+			ClassroomID: &classroom.ID,
+			After:       lecture,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lecture)
+}
+
+// GetRollCall lists every student enrolled in the class, by name, with their mark for the lecture.
+func (h *APIHandler) GetRollCall(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	lectureID, ok := h.classLectureID(ctx, w, r, classroom.ID)
+	if !ok {
+		return
+	}
+
+	entries := []database.RollCallEntry{}
+	cursor, err := h.DB.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": classStudents(classroom)}},
+		options.Find().SetProjection(bson.M{"name": 1, "email": 1, "roll_number": 1}).SetSort(bson.M{"name": 1}),
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch students", err)
+		return
+	}
+	if err = cursor.All(ctx, &entries); err != nil {
+		h.internalError(w, r, "Failed to decode students", err)
+		return
+	}
+
+	var records []database.AttendanceRecord
+	cursor, err = h.DB.Collection("attendance_records").Find(ctx, bson.M{"lecture_id": lectureID})
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch attendance", err)
+		return
+	}
+	if err = cursor.All(ctx, &records); err != nil {
+		h.internalError(w, r, "Failed to decode attendance", err)
+		return
+	}
+	byUser := make(map[primitive.ObjectID]database.AttendanceRecord, len(records))
+	for _, record := range records {
+		byUser[record.UserID] = record
+	}
+	for i, entry := range entries {
+		if record, ok := byUser[entry.UserID]; ok {
+			entries[i].Status = record.Status
+			entries[i].Method = record.Method
+			if entries[i].Status == "" {
+				entries[i].Status = database.StatusPresent
+			}
+			if entries[i].Method == "" && record.Status != database.StatusExcused {
+				entries[i].Method = database.MethodQR
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// SubmitRollCall marks the students the instructor ticked as present (or late) in a lecture.
+// Students who already have a mark for the lecture keep it.
+func (h *APIHandler) SubmitRollCall(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Present []primitive.ObjectID `json:"present"`
+		Late    []primitive.ObjectID `json:"late"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Present)+len(req.Late) == 0 {
+		http.Error(w, `{"error": "present or late must list at least one student"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	if classroom.ArchivedAt != nil {
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
+		return
+	}
+	lectureID, ok := h.classLectureID(ctx, w, r, classroom.ID)
+	if !ok {
+		return
+	}
+
+	enrolled := make(map[primitive.ObjectID]bool, len(classroom.StudentIDs))
+	for _, id := range classroom.StudentIDs {
+		enrolled[id] = id != classroom.InstructorID
+	}
+	now := time.Now()
+	seen := map[primitive.ObjectID]bool{}
+	var records []interface{}
+	for status, ids := range map[string][]primitive.ObjectID{database.StatusPresent: req.Present, database.StatusLate: req.Late} {
+		for _, id := range ids {
+			if !enrolled[id] {
+				writeJSONError(w, "Student "+id.Hex()+" is not enrolled in this class", http.StatusBadRequest)
+				return
+			}
+			if seen[id] {
+				writeJSONError(w, "Student "+id.Hex()+" is listed more than once", http.StatusBadRequest)
+				return
+			}
+			seen[id] = true
+			records = append(records, database.AttendanceRecord{
+				ID:          primitive.NewObjectID(),
+				UserID:      id,
+				ClassroomID: classroom.ID,
+				LectureID:   lectureID,
+				Status:      status,
+				Method:      database.MethodRollCall,
+				Timestamp:   now,
+			})
+		}
+	}
+
+	duplicates := map[int]bool{}
+	_, err := h.DB.Collection("attendance_records").InsertMany(ctx, records, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			h.internalError(w, r, "Failed to record roll call", err)
+			return
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				h.internalError(w, r, "Failed to record roll call", err)
+				return
+			}
+			duplicates[writeErr.Index] = true
+		}
+	}
+	alreadyMarked := len(duplicates)
+
+	for i, doc := range records {
+		if duplicates[i] {
+			continue
+		}
+		record := doc.(database.AttendanceRecord)
+		h.audit(ctx, r, database.AuditEntry{
+			Action:      actionAttendanceRollCall,
+			TargetType:  targetRecord,
+			TargetID:    record.ID,
+			ClassroomID: &classroom.ID,
+			After:       recordSnapshot(&record),
+		})
+		h.enqueueNotification(ctx, r, []primitive.ObjectID{record.UserID}, attendanceMarkedMessage(classroom, record))
+		h.emitWebhook(ctx, r, webhook.AttendanceMarked(record))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"lectureId":     lectureID,
+		"marked":        len(records) - alreadyMarked,
+		"alreadyMarked": alreadyMarked,
+	})
+}
+
+// classLectureID parses the lecture in the URL and checks it belongs to the class. Otherwise it
+// writes the error response and returns false.
+func (h *APIHandler) classLectureID(ctx context.Context, w http.ResponseWriter, r *http.Request, classID primitive.ObjectID) (primitive.ObjectID, bool) {
+	lectureID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "lectureID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid lecture ID"}`, http.StatusBadRequest)
+		return lectureID, false
+	}
+	count, err := h.DB.Collection("lectures").CountDocuments(ctx, bson.M{"_id": lectureID, "classroom_id": classID})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return lectureID, false
+	}
+	if count == 0 {
+		http.Error(w, `{"error": "Lecture not found"}`, http.StatusNotFound)
+		return lectureID, false
+	}
+	return lectureID, true
+}
+
+// ExcuseAbsence records an excused absence for a student who has no attendance for a lecture.
+// Excused lectures count as attended in the student's summary.
+func (h *APIHandler) ExcuseAbsence(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID primitive.ObjectID `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID.IsZero() {
+		http.Error(w, `{"error": "userId is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	lectureID, ok := h.classLectureID(ctx, w, r, classroom.ID)
+	if !ok {
+		return
+	}
+	if !containsID(classStudents(classroom), req.UserID) {
+		http.Error(w, `{"error": "The student is not enrolled in this class"}`, http.StatusBadRequest)
+		return
+	}
+
+	record := database.AttendanceRecord{
+		ID:          primitive.NewObjectID(),
+		UserID:      req.UserID,
+		ClassroomID: classroom.ID,
+		LectureID:   lectureID,
+		Status:      database.StatusExcused,
+		Timestamp:   time.Now(),
+	}
+	_, err := h.DB.Collection("attendance_records").InsertOne(ctx, record)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, `{"error": "The student already has attendance for this lecture"}`, http.StatusConflict)
+			return
+		}
+		h.internalError(w, r, "Failed to record excused absence", err)
+		return
+	}
+
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionAttendanceExcused,
+		TargetType:  targetRecord,
+		TargetID:    record.ID,
+		ClassroomID: &classroom.ID,
+		After:       recordSnapshot(&record),
+	})
+	h.emitWebhook(ctx, r, webhook.AttendanceMarked(record))
+	h.enqueueNotification(ctx, r, []primitive.ObjectID{req.UserID}, notify.Message{
+		Event: notify.EventAbsenceExcused,
+		Title: "Absence excused",
+		Body:  "Your instructor excused your absence from a lecture of " + classroom.Name + ".",
+		Data:  map[string]string{"classId": classroom.ID.Hex(), "lectureId": lectureID.Hex()},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Absence excused", "status": record.Status})
+}
@@ -0,0 +1,106 @@
+// File: internal/handler/logging.go
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"backend/internal/auth"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestIDHeader carries the request ID in both directions so clients can quote it in bug reports.
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	RequestIDContextKey = contextKey("requestID")
+	logEntryContextKey  = contextKey("logEntry")
+)
+
+// logEntry holds the request-scoped logger. It is stored by pointer so that middleware further
+// down the chain (AuthMiddleware) can enrich the logger used for the final access log line.
+type logEntry struct {
+	logger *slog.Logger
+}
+
+// RequestID reuses the caller's X-Request-ID or generates one, and echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID, _ = auth.GenerateSecureToken(8)
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), RequestIDContextKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestLogger attaches a request-scoped logger to the context and writes one access log line
+// per request. It must run after RequestID.
+func (h *APIHandler) RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID, _ := r.Context().Value(RequestIDContextKey).(string)
+		entry := &logEntry{logger: h.Logger.With("request_id", requestID)}
+		ctx := context.WithValue(r.Context(), logEntryContextKey, entry)
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		entry.logger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// clientIP is the host part of the request's remote address, which middleware.RealIP rewrites
+// when proxy headers are trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// logger returns the request-scoped logger, falling back to the handler's base logger.
+func (h *APIHandler) logger(r *http.Request) *slog.Logger {
+	if entry, ok := r.Context().Value(logEntryContextKey).(*logEntry); ok {
+		return entry.logger
+	}
+	return h.Logger
+}
+
+// internalError logs the underlying error and responds with a 500 that does not leak it.
+// Database timeouts and outages get a 504 or 503 instead, and nothing is written when the
+// client has already gone away.
+func (h *APIHandler) internalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		h.logger(r).Info("Request cancelled by client", "during", message)
+		return
+	}
+	status, statusMessage := dbErrorStatus(err)
+	if status != http.StatusInternalServerError {
+		h.logger(r).Warn(message, "error", err, "status", status)
+		writeJSONError(w, statusMessage, status)
+		return
+	}
+	h.logger(r).Error(message, "error", err)
+	writeJSONError(w, message, http.StatusInternalServerError)
+}
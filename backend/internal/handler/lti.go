@@ -0,0 +1,526 @@
+// File: internal/handler/lti.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+	"backend/internal/lti"
+	"backend/internal/webhook"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ltiLaunchPath is where platforms post launches, relative to PublicURL.
+const ltiLaunchPath = "/lti/launch"
+
+// LTIJWKS serves the tool's public key, which platforms use to verify grade service requests.
+func (h *APIHandler) LTIJWKS(w http.ResponseWriter, r *http.Request) {
+	if h.LTI == nil {
+		http.Error(w, `{"error": "LTI is not configured"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.LTI.Key.JWKS())
+}
+
+// LTILogin answers a platform's third-party login initiation (GET or form POST) by redirecting
+// the browser to the platform's authorization endpoint with a one-time state and nonce.
+func (h *APIHandler) LTILogin(w http.ResponseWriter, r *http.Request) {
+	if h.LTI == nil {
+		http.Error(w, `{"error": "LTI is not configured"}`, http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error": "Invalid login request"}`, http.StatusBadRequest)
+		return
+	}
+	issuer := r.Form.Get("iss")
+	loginHint := r.Form.Get("login_hint")
+	if issuer == "" || loginHint == "" {
+		http.Error(w, `{"error": "iss and login_hint are required"}`, http.StatusBadRequest)
+		return
+	}
+	platform, err := h.LTI.Platform(issuer, r.Form.Get("client_id"))
+	if err != nil {
+		h.logger(r).Warn("LTI login from unknown platform", "issuer", issuer, "error", err)
+		http.Error(w, `{"error": "Unknown LTI platform"}`, http.StatusBadRequest)
+		return
+	}
+
+	state, err := auth.GenerateSecureToken(16)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate state", err)
+		return
+	}
+	nonce, err := auth.GenerateSecureToken(16)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate nonce", err)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	_, err = h.DB.Collection("lti_logins").InsertOne(ctx, bson.M{
+		"_id":        state,
+		"nonce":      nonce,
+		"issuer":     platform.Issuer,
+		"client_id":  platform.ClientID,
+		"created_at": time.Now(),
+	})
+	if err != nil {
+		h.internalError(w, r, "Failed to start LTI login", err)
+		return
+	}
+
+	redirectURI := h.PublicURL + ltiLaunchPath
+	http.Redirect(w, r, platform.AuthRequestURL(redirectURI, loginHint, r.Form.Get("lti_message_hint"), state, nonce), http.StatusFound)
+}
+
+// LTILaunch completes a resource link launch. It validates the platform's id_token, finds or
+// creates the user and the classroom of the LMS course, enrolls learners and signs the user in.
+// Courses are set up by the first instructor launch; learners launching before that are refused.
+// With LTIRedirectURL set the browser is sent there with "token" and "classId" in the URL
+// fragment, otherwise they are returned as JSON.
+func (h *APIHandler) LTILaunch(w http.ResponseWriter, r *http.Request) {
+	if h.LTI == nil {
+		http.Error(w, `{"error": "LTI is not configured"}`, http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error": "Invalid launch request"}`, http.StatusBadRequest)
+		return
+	}
+	idToken := r.PostForm.Get("id_token")
+	state := r.PostForm.Get("state")
+	if idToken == "" || state == "" {
+		http.Error(w, `{"error": "id_token and state are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	// Each state is good for one launch
+	var login struct {
+		Nonce    string `bson:"nonce"`
+		Issuer   string `bson:"issuer"`
+		ClientID string `bson:"client_id"`
+	}
+	err := h.DB.Collection("lti_logins").FindOneAndDelete(ctx, bson.M{"_id": state}).Decode(&login)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Unknown or expired LTI login; launch again from the course"}`, http.StatusBadRequest)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	platform, err := h.LTI.Platform(login.Issuer, login.ClientID)
+	if err != nil {
+		http.Error(w, `{"error": "Unknown LTI platform"}`, http.StatusBadRequest)
+		return
+	}
+	claims, err := h.LTI.VerifyLaunch(platform, idToken, login.Nonce)
+	if err != nil {
+		h.logger(r).Warn("Rejected LTI launch", "issuer", platform.Issuer, "error", err)
+		http.Error(w, `{"error": "Invalid LTI launch"}`, http.StatusUnauthorized)
+		return
+	}
+	if claims.Context == nil || claims.Context.ID == "" {
+		http.Error(w, `{"error": "The launch has no course context"}`, http.StatusBadRequest)
+		return
+	}
+	if !claims.IsInstructor() && !claims.IsLearner() {
+		http.Error(w, `{"error": "Only instructors and learners can use this tool"}`, http.StatusForbidden)
+		return
+	}
+
+	user, err := h.ltiUser(ctx, r, platform, claims)
+	if err != nil {
+		if errors.Is(err, errEmailDomainNotAllowed) {
+			http.Error(w, `{"error": "Your email domain is not allowed in this organization"}`, http.StatusForbidden)
+			return
+		}
+		var link *linkConfirmationError
+		if errors.As(err, &link) {
+			writeLinkConfirmation(w, r, h.LTIRedirectURL, link)
+			return
+		}
+		if errors.Is(err, errLinkRefused) {
+			http.Error(w, `{"error": "An account with your email already exists and cannot be linked to the LMS automatically"}`, http.StatusConflict)
+			return
+		}
+		h.internalError(w, r, "Failed to sign in LTI user", err)
+		return
+	}
+	if user.DisabledAt != nil {
+		http.Error(w, `{"error": "This account is disabled"}`, http.StatusForbidden)
+		return
+	}
+	classroom, ok := h.ltiClassroom(ctx, w, r, platform, claims, user)
+	if !ok {
+		return
+	}
+
+	token, err := auth.GenerateJWT(user.ID.Hex(), user.Name, user.OrganizationID.Hex(), h.JWT_Secret)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate token", err)
+		return
+	}
+	h.logger(r).Info("LTI launch", "issuer", platform.Issuer, "context_id", claims.Context.ID, "class_id", classroom.ID.Hex(), "user_id", user.ID.Hex())
+
+	if h.LTIRedirectURL != "" {
+		fragment := url.Values{"token": {token}, "classId": {classroom.ID.Hex()}}
+		http.Redirect(w, r, h.LTIRedirectURL+"#"+fragment.Encode(), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     token,
+		"classroom": classroom,
+	})
+}
+
+// ltiUser finds or creates the user of a launch. LMS platforms are trusted to have verified the
+// email addresses they send, but only for users of the organization the platform's users join.
+func (h *APIHandler) ltiUser(ctx context.Context, r *http.Request, platform *lti.Platform, claims *lti.LaunchClaims) (*database.User, error) {
+	name := claims.Name
+	if name == "" {
+		name = strings.TrimSpace(claims.GivenName + " " + claims.FamilyName)
+	}
+	return h.linkedUser(ctx, r, externalAccount{
+		Provider:      database.ProviderLTI,
+		Issuer:        platform.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: true,
+		Name:          name,
+		Organization:  platform.Organization,
+
+		SameOrganization: true,
+	}, true)
+}
+
+// ltiClassroom finds the classroom linked to the launch's course, creating it on an instructor
+// launch, records the grade service endpoints and enrolls learners unless the class is archived.
+// Otherwise it writes the error response and returns false.
+func (h *APIHandler) ltiClassroom(ctx context.Context, w http.ResponseWriter, r *http.Request, platform *lti.Platform, claims *lti.LaunchClaims, user *database.User) (*database.Classroom, bool) {
+	classroomsCollection := h.DB.Collection("classrooms")
+	byContext := bson.M{"lti.issuer": platform.Issuer, "lti.context_id": claims.Context.ID}
+
+	var classroom database.Classroom
+	err := classroomsCollection.FindOne(ctx, byContext).Decode(&classroom)
+	if err == mongo.ErrNoDocuments {
+		if !claims.IsInstructor() {
+			http.Error(w, `{"error": "This course has not been set up yet; ask your instructor to open it first"}`, http.StatusNotFound)
+			return nil, false
+		}
+		classroom, err = h.createLTIClassroom(ctx, platform, claims, user)
+		if err == nil {
+			h.audit(ctx, r, database.AuditEntry{
+				ActorID:        user.ID,
+				OrganizationID: user.OrganizationID,
+				Action:         actionClassCreated,
+				TargetType:     targetClassroom,
+				TargetID:       classroom.ID,
+				ClassroomID:    &classroom.ID,
+				After:          classroom,
+			})
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			err = classroomsCollection.FindOne(ctx, byContext).Decode(&classroom)
+		}
+	}
+	if err != nil {
+		h.internalError(w, r, "Failed to find LTI classroom", err)
+		return nil, false
+	}
+	if classroom.DeletedAt != nil {
+		http.Error(w, `{"error": "The class of this course was deleted; restore it to launch again"}`, http.StatusConflict)
+		return nil, false
+	}
+	if classroom.OrganizationID != user.OrganizationID {
+		http.Error(w, `{"error": "This course belongs to another organization"}`, http.StatusForbidden)
+		return nil, false
+	}
+
+	set := bson.M{}
+	if ep := claims.Endpoint; ep != nil && containsString(ep.Scope, lti.ScopeScore) {
+		if ep.LineItems != "" {
+			set["lti.line_items_url"] = ep.LineItems
+		}
+		if ep.LineItem != "" && classroom.LTI.LineItemURL == "" {
+			set["lti.line_item_url"] = ep.LineItem
+		}
+	}
+	if len(set) > 0 {
+		if _, err := classroomsCollection.UpdateOne(ctx, bson.M{"_id": classroom.ID}, bson.M{"$set": set}); err != nil {
+			h.internalError(w, r, "Failed to update LTI classroom", err)
+			return nil, false
+		}
+	}
+
+	if user.ID == classroom.InstructorID {
+		return &classroom, true
+	}
+	if !claims.IsLearner() {
+		http.Error(w, `{"error": "This course is linked to another instructor's class"}`, http.StatusForbidden)
+		return nil, false
+	}
+
+	// Archived classes take no new students, as with JoinClass; enrolled ones can still sign in
+	if classroom.ArchivedAt != nil {
+		if containsID(classroom.StudentIDs, user.ID) {
+			return &classroom, true
+		}
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
+		return nil, false
+	}
+	// The LMS roster is authoritative, so learners are enrolled even when joining by code is locked
+	result, err := classroomsCollection.UpdateOne(ctx,
+		bson.M{"_id": classroom.ID, "student_ids": bson.M{"$ne": user.ID}},
+		bson.M{
+			"$push": bson.M{"student_ids": user.ID},
+			"$set":  bson.M{"joined_at." + user.ID.Hex(): time.Now()},
+		},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to add student to classroom", err)
+		return nil, false
+	}
+	_, err = h.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$addToSet": bson.M{"classroom_ids": classroom.ID}})
+	if err != nil {
+		h.internalError(w, r, "Failed to add classroom to user", err)
+		return nil, false
+	}
+	if result.ModifiedCount > 0 {
+		h.audit(ctx, r, database.AuditEntry{
+			ActorID:        user.ID,
+			OrganizationID: user.OrganizationID,
+			Action:         actionClassJoined,
+			TargetType:     targetUser,
+			TargetID:       user.ID,
+			ClassroomID:    &classroom.ID,
+			After:          bson.M{"via": database.ProviderLTI},
+		})
+		h.emitWebhook(ctx, r, webhook.StudentJoined(classroom.ID, user.ID))
+	}
+	return &classroom, true
+}
+
+// ltiCodeAttempts bounds how many random join codes createLTIClassroom tries.
+const ltiCodeAttempts = 3
+
+// createLTIClassroom creates the classroom of an LMS course, taught by the launching instructor.
+// It draws a new join code when the random one is taken, and returns a duplicate key error when
+// the course's classroom was created concurrently.
+func (h *APIHandler) createLTIClassroom(ctx context.Context, platform *lti.Platform, claims *lti.LaunchClaims, instructor *database.User) (database.Classroom, error) {
+	name := claims.Context.Title
+	if name == "" {
+		name = claims.Context.Label
+	}
+	var org database.Organization
+	if err := h.DB.Collection("organizations").FindOne(ctx, bson.M{"_id": instructor.OrganizationID}).Decode(&org); err != nil {
+		return database.Classroom{}, err
+	}
+	classroom := database.Classroom{
+		ID:           primitive.NewObjectID(),
+		Name:         name,
+		InstructorID: instructor.ID,
+		StudentIDs:   []primitive.ObjectID{instructor.ID}, // Instructor is auto-enrolled
+		LTI: &database.LTILink{
+			Issuer:       platform.Issuer,
+			ClientID:     platform.ClientID,
+			DeploymentID: claims.DeploymentID,
+			ContextID:    claims.Context.ID,
+		},
+		Settings:       database.ClassroomSettings{RequiredPercent: org.Settings.RequiredPercent},
+		OrganizationID: org.ID,
+	}
+	classroomsCollection := h.DB.Collection("classrooms")
+	for attempt := 1; ; attempt++ {
+		code, err := auth.GenerateSecureToken(4)
+		if err != nil {
+			return classroom, err
+		}
+		classroom.Code = strings.ToUpper(code)
+		_, err = classroomsCollection.InsertOne(ctx, classroom)
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt == ltiCodeAttempts {
+			return classroom, err
+		}
+		// Only a taken code is worth another try; a taken course is the caller's to handle
+		count, countErr := classroomsCollection.CountDocuments(ctx, bson.M{"lti.issuer": platform.Issuer, "lti.context_id": claims.Context.ID})
+		if countErr != nil {
+			return classroom, countErr
+		}
+		if count > 0 {
+			return classroom, err
+		}
+	}
+	_, err := h.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": instructor.ID}, bson.M{"$addToSet": bson.M{"classroom_ids": classroom.ID}})
+	return classroom, err
+}
+
+// PushLTIGrades posts each student's attendance percentage to the LMS gradebook of the class's
+// course, creating an "Attendance" column on first use. Students who never launched the tool
+// from the LMS have no LMS account on record and are skipped.
+func (h *APIHandler) PushLTIGrades(w http.ResponseWriter, r *http.Request) {
+	if h.LTI == nil {
+		http.Error(w, `{"error": "LTI is not configured"}`, http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+	link := classroom.LTI
+	if link == nil {
+		http.Error(w, `{"error": "This class is not linked to an LMS course"}`, http.StatusConflict)
+		return
+	}
+	if link.LineItemURL == "" && link.LineItemsURL == "" {
+		http.Error(w, `{"error": "The LMS has not granted grade access; launch the tool from the course first"}`, http.StatusConflict)
+		return
+	}
+	platform, err := h.LTI.Platform(link.Issuer, link.ClientID)
+	if err != nil {
+		http.Error(w, `{"error": "The LMS of this class is no longer registered"}`, http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	summaries, err := database.SummarizeClass(ctx, h.DB, *classroom, now)
+	if err != nil {
+		h.internalError(w, r, "Failed to summarize attendance", err)
+		return
+	}
+
+	// LMS user IDs of the students who launched from this platform
+	subjects := map[primitive.ObjectID]string{}
+	cursor, err := h.DB.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": classStudents(classroom)}, "identities.issuer": link.Issuer},
+		options.Find().SetProjection(bson.M{"identities": 1}),
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch students", err)
+		return
+	}
+	var students []database.User
+	if err = cursor.All(ctx, &students); err != nil {
+		h.internalError(w, r, "Failed to decode students", err)
+		return
+	}
+	for _, student := range students {
+		for _, identity := range student.Identities {
+			if identity.Issuer == link.Issuer {
+				subjects[student.ID] = identity.Subject
+			}
+		}
+	}
+
+	// Grade service calls are not database calls, so they are only bounded by the request
+	scopes := []string{lti.ScopeScore}
+	if link.LineItemURL == "" {
+		scopes = append(scopes, lti.ScopeLineItem)
+	}
+	accessToken, err := h.LTI.AccessToken(r.Context(), platform, scopes...)
+	if err != nil {
+		h.ltiGatewayError(w, r, "Failed to get an LMS access token", err)
+		return
+	}
+	lineItemURL := link.LineItemURL
+	if lineItemURL == "" {
+		item, err := h.LTI.CreateLineItem(r.Context(), accessToken, link.LineItemsURL, lti.LineItem{
+			Label:        "Attendance",
+			ScoreMaximum: 100,
+			ResourceID:   "attendance",
+			Tag:          "attendance",
+		})
+		if err != nil {
+			h.ltiGatewayError(w, r, "Failed to create the LMS gradebook column", err)
+			return
+		}
+		lineItemURL = item.ID
+		// Saved right away, so a failure below does not create a second column next time
+		_, err = h.DB.Collection("classrooms").UpdateOne(ctx, bson.M{"_id": classroom.ID}, bson.M{"$set": bson.M{"lti.line_item_url": lineItemURL}})
+		if err != nil {
+			h.internalError(w, r, "Failed to update LTI classroom", err)
+			return
+		}
+	}
+
+	posted, skipped := 0, 0
+	for _, studentID := range classStudents(classroom) {
+		summary, held := summaries[studentID]
+		subject := subjects[studentID]
+		if !held || summary.Percentage == nil || subject == "" {
+			skipped++
+			continue
+		}
+		err := h.LTI.PostScore(r.Context(), accessToken, lineItemURL, lti.Score{
+			UserID:           subject,
+			ScoreGiven:       *summary.Percentage,
+			ScoreMaximum:     100,
+			Comment:          fmt.Sprintf("Attended %d of %d lectures", summary.Attended, summary.Held),
+			Timestamp:        now,
+			ActivityProgress: "InProgress",
+			GradingProgress:  "FullyGraded",
+		})
+		if err != nil {
+			h.ltiGatewayError(w, r, fmt.Sprintf("Failed to post a score after %d succeeded", posted), err)
+			return
+		}
+		posted++
+	}
+
+	_, err = h.DB.Collection("classrooms").UpdateOne(ctx,
+		bson.M{"_id": classroom.ID},
+		bson.M{"$set": bson.M{"lti.grades_pushed_at": now}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to update LTI classroom", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionClassGradesPushed,
+		TargetType:  targetClassroom,
+		TargetID:    classroom.ID,
+		ClassroomID: &classroom.ID,
+		Before:      bson.M{"grades_pushed_at": link.GradesPushedAt},
+		After:       bson.M{"grades_pushed_at": now, "line_item_url": lineItemURL, "posted": posted, "skipped": skipped},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"posted": posted, "skipped": skipped})
+}
+
+// ltiGatewayError reports a failed call to the LMS as 502, keeping the platform's answer in the log only.
+func (h *APIHandler) ltiGatewayError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	h.logger(r).Error(msg, "error", err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSONError(w, msg+": the LMS did not answer in time", http.StatusGatewayTimeout)
+		return
+	}
+	writeJSONError(w, msg, http.StatusBadGateway)
+}
@@ -2,12 +2,18 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"strings"
 
 	"backend/internal/auth" // Use your module name
+	"backend/internal/database"
 
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // A private key for context that is guaranteed to be unique
@@ -15,7 +21,17 @@ type contextKey string
 
 const UserIDContextKey = contextKey("userID")
 
-// AuthMiddleware creates a middleware that validates JWT tokens.
+// OrgIDContextKey holds the primitive.ObjectID of the signed-in user's organization.
+const OrgIDContextKey = contextKey("orgID")
+
+// AuthTimeContextKey holds the time.Time the user signed in, for tokens that record it.
+const AuthTimeContextKey = contextKey("authTime")
+
+// ImpersonatorIDContextKey holds the primitive.ObjectID of the operator acting as the user, on
+// requests made with an impersonation token.
+const ImpersonatorIDContextKey = contextKey("impersonatorID")
+
+// AuthMiddleware creates a middleware that validates JWT tokens, or API keys in their place.
 func (h *APIHandler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -29,6 +45,10 @@ func (h *APIHandler) AuthMiddleware(next http.Handler) http.Handler {
 			http.Error(w, `{"error": "Invalid token format"}`, http.StatusUnauthorized)
 			return
 		}
+		if strings.HasPrefix(tokenString, apiKeyMarker) {
+			h.apiKeyAuth(w, r, next, tokenString)
+			return
+		}
 
 		claims := &auth.Claims{}
 
@@ -40,10 +60,115 @@ func (h *APIHandler) AuthMiddleware(next http.Handler) http.Handler {
 			http.Error(w, `{"error": "Invalid or expired token"}`, http.StatusUnauthorized)
 			return
 		}
+		// Deleted and disabled accounts lose access at once, not when their tokens expire. The
+		// organization is read here too rather than taken from the token, so users moved to another
+		// organization act in the new one straight away.
+		userID, _ := primitive.ObjectIDFromHex(claims.UserID)
+		queryCtx, cancel := h.queryContext(r)
+		var user database.User
+		err = h.DB.Collection("users").FindOne(queryCtx, bson.M{"_id": userID},
+			options.FindOne().SetProjection(bson.M{"disabled_at": 1, "organization_id": 1})).Decode(&user)
+		cancel()
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				http.Error(w, `{"error": "Invalid or expired token"}`, http.StatusUnauthorized)
+				return
+			}
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if user.DisabledAt != nil {
+			http.Error(w, `{"error": "This account is disabled"}`, http.StatusForbidden)
+			return
+		}
+		// Impersonation ends as soon as the operator is disabled, removed or no longer an operator
+		var impersonatorID primitive.ObjectID
+		if claims.ImpersonatorID != "" {
+			impersonatorID, err = primitive.ObjectIDFromHex(claims.ImpersonatorID)
+			if err != nil {
+				http.Error(w, `{"error": "Invalid or expired token"}`, http.StatusUnauthorized)
+				return
+			}
+			queryCtx, cancel := h.queryContext(r)
+			operators, err := h.DB.Collection("users").CountDocuments(queryCtx, bson.M{
+				"_id":            impersonatorID,
+				"platform_admin": true,
+				"disabled_at":    bson.M{"$exists": false},
+			})
+			cancel()
+			if err != nil {
+				h.internalError(w, r, "Database error", err)
+				return
+			}
+			if operators == 0 {
+				http.Error(w, `{"error": "Impersonation is no longer allowed"}`, http.StatusUnauthorized)
+				return
+			}
+		}
+
+		// Tag every later log line of this request, including the access log, with the user
+		if entry, ok := r.Context().Value(logEntryContextKey).(*logEntry); ok {
+			entry.logger = entry.logger.With("user_id", claims.UserID, "org_id", user.OrganizationID.Hex())
+			if claims.ImpersonatorID != "" {
+				entry.logger = entry.logger.With("impersonator_id", claims.ImpersonatorID)
+			}
+		}
 
 		// Add user ID to the request context
 		ctx := context.WithValue(r.Context(), UserIDContextKey, claims.UserID)
+		ctx = context.WithValue(ctx, OrgIDContextKey, user.OrganizationID)
+		if !impersonatorID.IsZero() {
+			ctx = context.WithValue(ctx, ImpersonatorIDContextKey, impersonatorID)
+		}
+		if claims.AuthTime != nil {
+			ctx = context.WithValue(ctx, AuthTimeContextKey, claims.AuthTime.Time)
+		}
 		// Call the next handler in the chain
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// AdminMiddleware lets only platform operators through. It must run after AuthMiddleware and
+// SessionMiddleware. Impersonation tokens are refused even when the impersonated user is an
+// operator, so support sessions cannot reach the admin API.
+func (h *APIHandler) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ImpersonatorIDContextKey).(primitive.ObjectID); ok {
+			http.Error(w, `{"error": "Forbidden: Not available while impersonating"}`, http.StatusForbidden)
+			return
+		}
+		userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+		userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+		queryCtx, cancel := h.queryContext(r)
+		count, err := h.DB.Collection("users").CountDocuments(queryCtx, bson.M{"_id": userID, "platform_admin": true})
+		cancel()
+		if err != nil {
+			h.internalError(w, r, "Database error", err)
+			return
+		}
+		if count == 0 {
+			http.Error(w, `{"error": "Forbidden: Platform administrators only"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refuseImpersonation answers 403 to requests made with an impersonation token and reports
+// whether it did. Support sessions must not change how an account signs in or is reached, delete
+// it, or leave behind credentials that outlive them.
+func refuseImpersonation(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := r.Context().Value(ImpersonatorIDContextKey).(primitive.ObjectID); ok {
+		http.Error(w, `{"error": "Forbidden: Not available while impersonating"}`, http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// writeJSONError writes an error message in the same {"error": ...} shape used by the literal
+// error strings, escaping the message properly for dynamic text.
+func writeJSONError(w http.ResponseWriter, message string, status int) {
+	body, _ := json.Marshal(map[string]string{"error": message})
+	http.Error(w, string(body), status)
+}
@@ -0,0 +1,266 @@
+// File: internal/handler/notifications.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"backend/internal/database"
+	"backend/internal/notify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxPushTokens bounds the devices a user can receive push notifications on.
+const maxPushTokens = 10
+
+// GetNotificationPrefs returns the logged-in user's notification preferences, with the channels
+// and events that can be chosen.
+func (h *APIHandler) GetNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var user database.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	prefs := user.NotificationPrefs
+	if prefs == nil {
+		prefs = &database.NotificationPrefs{Channels: notify.DefaultChannels, MutedEvents: []string{}}
+	}
+
+	available := []string{}
+	for _, channel := range notify.Channels {
+		if h.Notify.HasChannel(channel) {
+			available = append(available, channel)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"preferences":       prefs,
+		"pushTokens":        len(user.PushTokens),
+		"availableChannels": available,
+		"events":            notify.Events,
+	})
+}
+
+// UpdateNotificationPrefs replaces the logged-in user's notification preferences.
+func (h *APIHandler) UpdateNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req database.NotificationPrefs
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Channels == nil {
+		req.Channels = []string{}
+	}
+	if req.MutedEvents == nil {
+		req.MutedEvents = []string{}
+	}
+	for _, channel := range req.Channels {
+		if !containsString(notify.Channels, channel) {
+			writeJSONError(w, "Unknown channel "+channel, http.StatusBadRequest)
+			return
+		}
+	}
+	for _, event := range req.MutedEvents {
+		if !containsString(notify.Events, event) {
+			writeJSONError(w, "Unknown event "+event, http.StatusBadRequest)
+			return
+		}
+	}
+	if containsString(req.Channels, notify.ChannelWebhook) || req.WebhookURL != "" {
+		// The server posts to this URL, so only accept public HTTPS endpoints
+		u, err := url.Parse(req.WebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			http.Error(w, `{"error": "webhookUrl must be an https URL"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var before database.User
+	err := h.DB.Collection("users").FindOneAndUpdate(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"notification_prefs": req}}).Decode(&before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Failed to update notification preferences", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionUserNotificationPrefs,
+		TargetType: targetUser,
+		TargetID:   userID,
+		Before:     before.NotificationPrefs,
+		After:      req,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// AddPushToken registers an Expo push token of the mobile app for the logged-in user.
+func (h *APIHandler) AddPushToken(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(req.Token, "ExponentPushToken[") && !strings.HasPrefix(req.Token, "ExpoPushToken[") {
+		http.Error(w, `{"error": "token must be an Expo push token"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	// Re-registering a known token is a no-op; a new one is only added below the limit
+	result, err := h.DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "$or": bson.A{
+			bson.M{"push_tokens": req.Token},
+			bson.M{"$expr": bson.M{"$lt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$push_tokens", bson.A{}}}}, maxPushTokens}}},
+		}},
+		bson.M{"$addToSet": bson.M{"push_tokens": req.Token}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to register push token", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, `{"error": "Too many push tokens registered; remove one first"}`, http.StatusConflict)
+		return
+	}
+	// The token itself is left out: it is enough to send to the user's phone
+	if result.ModifiedCount > 0 {
+		h.audit(ctx, r, database.AuditEntry{Action: actionUserPushTokenAdded, TargetType: targetUser, TargetID: userID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Push token registered"})
+}
+
+// RemovePushToken unregisters a push token, for example on logout.
+func (h *APIHandler) RemovePushToken(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, `{"error": "token is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	result, err := h.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$pull": bson.M{"push_tokens": req.Token}})
+	if err != nil {
+		h.internalError(w, r, "Failed to remove push token", err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		h.audit(ctx, r, database.AuditEntry{Action: actionUserPushTokenRemoved, TargetType: targetUser, TargetID: userID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Push token removed"})
+}
+
+// enqueueNotification queues a notification without failing the request; problems are only logged.
+func (h *APIHandler) enqueueNotification(ctx context.Context, r *http.Request, userIDs []primitive.ObjectID, msg notify.Message) {
+	if err := h.Notify.Enqueue(ctx, userIDs, msg); err != nil {
+		h.logger(r).Error("Failed to queue notification", "event", msg.Event, "error", err)
+	}
+}
+
+// classStudents returns the IDs of a class's students, leaving out the auto-enrolled instructor.
+func classStudents(classroom *database.Classroom) []primitive.ObjectID {
+	students := make([]primitive.ObjectID, 0, len(classroom.StudentIDs))
+	for _, id := range classroom.StudentIDs {
+		if id != classroom.InstructorID {
+			students = append(students, id)
+		}
+	}
+	return students
+}
+
+// attendanceMarkedMessage confirms a new attendance record to the student.
+func attendanceMarkedMessage(classroom *database.Classroom, record database.AttendanceRecord) notify.Message {
+	return notify.Message{
+		Event: notify.EventAttendanceMarked,
+		Title: "Attendance marked",
+		Body:  "You were marked " + record.Status + " in " + classroom.Name + ".",
+		Data:  map[string]string{"classId": classroom.ID.Hex(), "lectureId": record.LectureID.Hex(), "status": record.Status},
+	}
+}
+
+// sessionOpenedMessage tells students that attendance is being taken.
+func sessionOpenedMessage(classroom *database.Classroom, lecture *database.Lecture) notify.Message {
+	return notify.Message{
+		Event: notify.EventSessionOpened,
+		Title: "Attendance is open",
+		Body:  "Attendance for " + classroom.Name + " is being taken now.",
+		Data:  map[string]string{"classId": classroom.ID.Hex(), "lectureId": lecture.ID.Hex()},
+	}
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
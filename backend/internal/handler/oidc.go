@@ -0,0 +1,218 @@
+// File: internal/handler/oidc.go
+
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// oidcStateCookie ties a single sign-on to the browser that started it. It holds a hash of the
+// state, so a callback URL an attacker started cannot sign a victim into the attacker's account.
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/auth/oidc"
+)
+
+// GetAuthMethods tells the app which sign-in methods this deployment offers.
+func (h *APIHandler) GetAuthMethods(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{
+		"password": !h.PasswordLoginDisabled,
+		"oidc":     h.OIDC != nil,
+	})
+}
+
+// OIDCLogin starts single sign-on: it redirects the browser to the provider with a one-time
+// state, nonce and PKCE challenge. The optional redirect parameter picks the app page the
+// session token is handed to and must be one of OIDCAppRedirectURLs.
+func (h *APIHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, `{"error": "Single sign-on is not configured"}`, http.StatusNotFound)
+		return
+	}
+	redirect := r.URL.Query().Get("redirect")
+	if redirect != "" && !containsString(h.OIDCAppRedirectURLs, redirect) {
+		http.Error(w, `{"error": "redirect is not an allowed app URL"}`, http.StatusBadRequest)
+		return
+	}
+	if redirect == "" && len(h.OIDCAppRedirectURLs) > 0 {
+		redirect = h.OIDCAppRedirectURLs[0]
+	}
+
+	state, err := auth.GenerateSecureToken(16)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate state", err)
+		return
+	}
+	nonce, err := auth.GenerateSecureToken(16)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate nonce", err)
+		return
+	}
+	verifier, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate code verifier", err)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	authURL, err := h.OIDC.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		h.logger(r).Error("Identity provider discovery failed", "issuer", h.OIDC.Issuer, "error", err)
+		writeJSONError(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	_, err = h.DB.Collection("oidc_logins").InsertOne(ctx, bson.M{
+		"_id":           state,
+		"nonce":         nonce,
+		"code_verifier": verifier,
+		"redirect":      redirect,
+		"created_at":    time.Now(),
+	})
+	if err != nil {
+		h.internalError(w, r, "Failed to start single sign-on", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    auth.HashToken(state),
+		Path:     oidcStateCookiePath,
+		MaxAge:   int(database.OIDCLoginWindow.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.OIDC.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode, // Sent on the provider's top-level redirect back
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes single sign-on. It redeems the authorization code, verifies the ID
+// token and signs in the user linked to the provider account, linking an existing account with
+// the same verified email or, with OIDCJITProvisioning, creating one. Accounts with a password
+// are linked once the user confirms with it (see LinkIdentity). The token is handed to
+// the app page chosen at login in the URL fragment, or returned as JSON without one.
+func (h *APIHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, `{"error": "Single sign-on is not configured"}`, http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	state := q.Get("state")
+	if state == "" {
+		http.Error(w, `{"error": "state is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	// The sign-in must finish in the browser that started it
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(auth.HashToken(state))) != 1 {
+		http.Error(w, `{"error": "Sign-in was started in another browser; start again"}`, http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcStateCookiePath, MaxAge: -1, HttpOnly: true})
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	// Each state is good for one callback, including ones reporting an error
+	var login struct {
+		Nonce        string `bson:"nonce"`
+		CodeVerifier string `bson:"code_verifier"`
+		Redirect     string `bson:"redirect"`
+	}
+	err = h.DB.Collection("oidc_logins").FindOneAndDelete(ctx, bson.M{"_id": state}).Decode(&login)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Unknown or expired sign-in; start again"}`, http.StatusBadRequest)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if providerErr := q.Get("error"); providerErr != "" {
+		h.logger(r).Info("Single sign-on refused by provider", "error", providerErr, "description", q.Get("error_description"))
+		http.Error(w, `{"error": "Sign-in was cancelled or refused by the identity provider"}`, http.StatusUnauthorized)
+		return
+	}
+	code := q.Get("code")
+	if code == "" {
+		http.Error(w, `{"error": "code is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := h.OIDC.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		h.logger(r).Warn("Rejected single sign-on", "issuer", h.OIDC.Issuer, "error", err)
+		http.Error(w, `{"error": "Single sign-on failed"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// Some providers omit email_verified and let users set any address, so only an explicit
+	// claim counts unless the deployment trusts its provider
+	emailVerified := h.OIDCTrustEmail
+	if claims.EmailVerified != nil {
+		emailVerified = *claims.EmailVerified
+	}
+	user, err := h.linkedUser(ctx, r, externalAccount{
+		Provider:      database.ProviderOIDC,
+		Issuer:        h.OIDC.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: emailVerified,
+		Name:          claims.DisplayName(),
+	}, h.OIDCJITProvisioning)
+	if err != nil {
+		var link *linkConfirmationError
+		if errors.As(err, &link) {
+			writeLinkConfirmation(w, r, login.Redirect, link)
+			return
+		}
+		if errors.Is(err, errNoLinkedUser) {
+			http.Error(w, `{"error": "No account exists for this user; ask an administrator to create one"}`, http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, errEmailDomainNotAllowed) {
+			http.Error(w, `{"error": "Your email domain is not allowed in any organization"}`, http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, errLinkRefused) {
+			http.Error(w, `{"error": "An account with your email already exists and cannot be linked automatically"}`, http.StatusConflict)
+			return
+		}
+		h.internalError(w, r, "Failed to sign in user", err)
+		return
+	}
+	if user.DisabledAt != nil {
+		http.Error(w, `{"error": "This account is disabled"}`, http.StatusForbidden)
+		return
+	}
+
+	token, err := auth.GenerateJWT(user.ID.Hex(), user.Name, user.OrganizationID.Hex(), h.JWT_Secret)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate token", err)
+		return
+	}
+	h.logger(r).Info("Single sign-on", "issuer", h.OIDC.Issuer, "user_id", user.ID.Hex())
+
+	if login.Redirect != "" {
+		fragment := url.Values{"token": {token}}
+		http.Redirect(w, r, login.Redirect+"#"+fragment.Encode(), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
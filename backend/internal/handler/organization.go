@@ -0,0 +1,324 @@
+// File: internal/handler/organization.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"backend/internal/database"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errUnknownOrganization   = errors.New("unknown organization")
+	errEmailDomainNotAllowed = errors.New("email domain not allowed in the organization")
+)
+
+// resolveOrganization picks the organization a new user joins: the one named by slug, or else
+// the one claiming the email's domain. The email must be allowed there.
+func (h *APIHandler) resolveOrganization(ctx context.Context, slug, email string) (*database.Organization, error) {
+	var org *database.Organization
+	if slug != "" {
+		org = &database.Organization{}
+		err := h.DB.Collection("organizations").FindOne(ctx, bson.M{"slug": slug}).Decode(org)
+		if err == mongo.ErrNoDocuments {
+			return nil, errUnknownOrganization
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if org, err = database.OrganizationForEmail(ctx, h.DB, email); err != nil {
+			return nil, err
+		}
+	}
+	if !org.AllowsEmail(email) {
+		return nil, errEmailDomainNotAllowed
+	}
+	return org, nil
+}
+
+// organizationFor is resolveOrganization for handlers: it writes the error response itself and
+// returns false when no organization can take the user.
+func (h *APIHandler) organizationFor(ctx context.Context, w http.ResponseWriter, r *http.Request, slug, email string) (*database.Organization, bool) {
+	org, err := h.resolveOrganization(ctx, slug, email)
+	switch {
+	case errors.Is(err, errUnknownOrganization):
+		http.Error(w, `{"error": "Organization not found"}`, http.StatusBadRequest)
+		return nil, false
+	case errors.Is(err, errEmailDomainNotAllowed):
+		http.Error(w, `{"error": "Your email domain is not allowed in this organization"}`, http.StatusForbidden)
+		return nil, false
+	case err != nil:
+		h.internalError(w, r, "Database error", err)
+		return nil, false
+	}
+	return org, true
+}
+
+// orgAdmin loads the signed-in user and checks they administer their organization. Otherwise it
+// writes the error response and returns false.
+func (h *APIHandler) orgAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	var user database.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID, "organization_id": orgID}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		h.internalError(w, r, "Database error", err)
+		return nil, false
+	}
+	if err == mongo.ErrNoDocuments || user.OrgRole != database.OrgRoleAdmin {
+		http.Error(w, `{"error": "Forbidden: You are not an administrator of this organization"}`, http.StatusForbidden)
+		return nil, false
+	}
+	return &user, true
+}
+
+// GetMyOrganization returns the organization of the logged-in user and its settings.
+func (h *APIHandler) GetMyOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var org database.Organization
+	err := h.DB.Collection("organizations").FindOne(ctx, bson.M{"_id": orgID}).Decode(&org)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Organization not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// UpdateOrganization changes the name and settings of the admin's organization. Omitted fields
+// are left unchanged. The required percentage applies to classes created afterwards; allowed
+// email domains restrict new members, not existing ones. Admins can only drop domains, since a
+// domain also routes new users: platform operators add them with orgctl.
+func (h *APIHandler) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name                *string  `json:"name"`
+		RequiredPercent     *int     `json:"requiredPercent"`
+		AllowedEmailDomains []string `json:"allowedEmailDomains"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	set := bson.M{}
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			http.Error(w, `{"error": "name must not be empty"}`, http.StatusBadRequest)
+			return
+		}
+		set["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.RequiredPercent != nil {
+		if *req.RequiredPercent < 0 || *req.RequiredPercent > 100 {
+			http.Error(w, `{"error": "requiredPercent must be between 0 and 100"}`, http.StatusBadRequest)
+			return
+		}
+		set["settings.required_percent"] = *req.RequiredPercent
+	}
+	if req.AllowedEmailDomains != nil {
+		domains := make([]string, 0, len(req.AllowedEmailDomains))
+		for _, domain := range req.AllowedEmailDomains {
+			domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+			if domain == "" || strings.ContainsAny(domain, "@ ") {
+				writeJSONError(w, "Invalid email domain "+domain, http.StatusBadRequest)
+				return
+			}
+			if !containsString(domains, domain) {
+				domains = append(domains, domain)
+			}
+		}
+		set["settings.allowed_email_domains"] = domains
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	admin, ok := h.orgAdmin(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	organizationsCollection := h.DB.Collection("organizations")
+	var org database.Organization
+	err := organizationsCollection.FindOne(ctx, bson.M{"_id": admin.OrganizationID}).Decode(&org)
+	if domains, ok := set["settings.allowed_email_domains"].([]string); ok && err == nil {
+		for _, domain := range domains {
+			if !containsString(org.Settings.AllowedEmailDomains, domain) {
+				writeJSONError(w, "Only platform operators can add email domains: "+domain, http.StatusForbidden)
+				return
+			}
+		}
+	}
+	if err == nil && len(set) > 0 {
+		before := snapshotFields(org, set)
+		err = organizationsCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": admin.OrganizationID},
+			bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&org)
+		if err == nil {
+			h.audit(ctx, r, database.AuditEntry{
+				Action:     actionOrganizationUpdated,
+				TargetType: targetOrganization,
+				TargetID:   org.ID,
+				Before:     before,
+				After:      set,
+			})
+		}
+	}
+	if err != nil {
+		h.internalError(w, r, "Failed to update organization", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// GetOrganizationMembers returns a page of the admin's organization members. "role" filters
+// by organization role ("admin" or "member"), "q" by a name or email prefix; results are
+// sorted by "sort" (created or name) and paginated with "limit"/"cursor".
+func (h *APIHandler) GetOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	page, err := parseListParams(r, map[string]string{"created": "_id", "name": "name"}, "name")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	admin, ok := h.orgAdmin(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"organization_id": admin.OrganizationID}
+	switch r.URL.Query().Get("role") {
+	case "":
+	case database.OrgRoleAdmin:
+		filter["org_role"] = database.OrgRoleAdmin
+	case "member":
+		filter["org_role"] = bson.M{"$exists": false}
+	default:
+		http.Error(w, `{"error": "role must be admin or member"}`, http.StatusBadRequest)
+		return
+	}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"name": prefix}, bson.M{"email": prefix}}
+	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		filter["$and"] = bson.A{keyset}
+	}
+
+	cursor, err := h.DB.Collection("users").Find(ctx, filter,
+		options.Find().
+			SetSort(page.sort()).
+			SetLimit(int64(page.Limit+1)).
+			SetProjection(bson.M{"name": 1, "email": 1, "roll_number": 1, "department": 1, "organization_id": 1, "org_role": 1}),
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch members", err)
+		return
+	}
+	members := []database.User{}
+	if err = cursor.All(ctx, &members); err != nil {
+		h.internalError(w, r, "Failed to decode members", err)
+		return
+	}
+	members = paginate(w, r, page, members, func(u database.User) (interface{}, primitive.ObjectID) {
+		if page.SortField == "name" {
+			return u.Name, u.ID
+		}
+		return u.ID, u.ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// SetOrganizationRole makes a member of the admin's organization an admin ("admin") or a
+// regular member (""). Admins cannot change their own role, so an organization always keeps
+// the admin who made the change.
+func (h *APIHandler) SetOrganizationRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Role != "" && req.Role != database.OrgRoleAdmin {
+		http.Error(w, `{"error": "role must be admin or empty"}`, http.StatusBadRequest)
+		return
+	}
+	memberID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid user ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	admin, ok := h.orgAdmin(ctx, w, r)
+	if !ok {
+		return
+	}
+	if memberID == admin.ID {
+		http.Error(w, `{"error": "You cannot change your own role"}`, http.StatusConflict)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"org_role": req.Role}}
+	if req.Role == "" {
+		update = bson.M{"$unset": bson.M{"org_role": ""}}
+	}
+	var before database.User
+	err = h.DB.Collection("users").FindOneAndUpdate(ctx, bson.M{"_id": memberID, "organization_id": admin.OrganizationID}, update).Decode(&before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Failed to update role", err)
+		return
+	}
+	if before.OrgRole != req.Role {
+		h.audit(ctx, r, database.AuditEntry{
+			Action:     actionUserOrgRoleChanged,
+			TargetType: targetUser,
+			TargetID:   memberID,
+			Before:     bson.M{"org_role": before.OrgRole},
+			After:      bson.M{"org_role": req.Role},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role updated"})
+}
@@ -0,0 +1,182 @@
+// File: internal/handler/pagination.go
+
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+
+	// NextCursorHeader carries the cursor of the next page; it is absent on the last page.
+	// The same URL is also advertised in a Link header with rel="next".
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// pageCursor marks the last item of a page: its sort key and _id as a tie-breaker.
+// It is BSON-encoded so the sort key keeps its type (date, string, number) across requests.
+type pageCursor struct {
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+// listParams are the pagination and sorting options shared by list endpoints.
+type listParams struct {
+	Limit      int
+	SortField  string // BSON field name, "_id" for creation order
+	Descending bool
+	After      *bson.RawValue // Sort key of the previous page's last item
+	AfterID    primitive.ObjectID
+}
+
+// parseListParams reads "limit", "sort" and "cursor" from the query. sorts maps the sort
+// names clients may use to BSON field names; a leading "-" on the name sorts descending.
+func parseListParams(r *http.Request, sorts map[string]string, defaultSort string) (listParams, error) {
+	q := r.URL.Query()
+	p := listParams{Limit: defaultPageSize}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		p.Limit = limit
+	}
+
+	sortParam := q.Get("sort")
+	if sortParam == "" {
+		sortParam = defaultSort
+	}
+	p.Descending = strings.HasPrefix(sortParam, "-")
+	field, ok := sorts[strings.TrimPrefix(sortParam, "-")]
+	if !ok {
+		names := make([]string, 0, len(sorts))
+		for name := range sorts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return p, fmt.Errorf("sort must be one of %s, optionally prefixed with -", strings.Join(names, ", "))
+	}
+	p.SortField = field
+
+	if v := q.Get("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return p, errors.New("invalid cursor")
+		}
+		var c struct {
+			Value bson.RawValue      `bson:"v"`
+			ID    primitive.ObjectID `bson:"id"`
+		}
+		if err := bson.Unmarshal(raw, &c); err != nil {
+			return p, errors.New("invalid cursor")
+		}
+		p.After = &c.Value
+		p.AfterID = c.ID
+	}
+	return p, nil
+}
+
+// sort returns the sort document, with _id as a tie-breaker so the order is total.
+func (p listParams) sort() bson.D {
+	dir := 1
+	if p.Descending {
+		dir = -1
+	}
+	if p.SortField == "_id" {
+		return bson.D{{Key: "_id", Value: dir}}
+	}
+	return bson.D{{Key: p.SortField, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+// keysetFilter matches the items that come after the cursor, or nil on the first page.
+func (p listParams) keysetFilter() bson.M {
+	if p.After == nil {
+		return nil
+	}
+	op := "$gt"
+	if p.Descending {
+		op = "$lt"
+	}
+	if p.SortField == "_id" {
+		return bson.M{"_id": bson.M{op: p.AfterID}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{p.SortField: bson.M{op: *p.After}},
+		bson.M{p.SortField: *p.After, "_id": bson.M{op: p.AfterID}},
+	}}
+}
+
+// paginate trims a result fetched with Limit+1 items to the page size and, when there are
+// more items, writes the next-page cursor built from the last item's sort key.
+func paginate[T any](w http.ResponseWriter, r *http.Request, p listParams, items []T, key func(T) (interface{}, primitive.ObjectID)) []T {
+	if len(items) <= p.Limit {
+		return items
+	}
+	items = items[:p.Limit]
+
+	value, id := key(items[len(items)-1])
+	raw, err := bson.Marshal(pageCursor{Value: value, ID: id})
+	if err != nil {
+		return items
+	}
+	cursor := base64.RawURLEncoding.EncodeToString(raw)
+
+	next := *r.URL
+	q := next.Query()
+	q.Set("cursor", cursor)
+	next.RawQuery = q.Encode()
+
+	w.Header().Set(NextCursorHeader, cursor)
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	return items
+}
+
+// parseTimeParam parses an RFC 3339 timestamp or a plain "2006-01-02" date (UTC midnight).
+// An empty value yields the zero time.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// timeRangeFilter reads "from" (inclusive) and "to" (exclusive) from the query into a
+// condition on a date field, or nil when neither is given.
+func timeRangeFilter(r *http.Request) (bson.M, error) {
+	from, err := parseTimeParam(r.URL.Query().Get("from"))
+	if err != nil {
+		return nil, errors.New("from must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	}
+	to, err := parseTimeParam(r.URL.Query().Get("to"))
+	if err != nil {
+		return nil, errors.New("to must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	}
+
+	cond := bson.M{}
+	if !from.IsZero() {
+		cond["$gte"] = from
+	}
+	if !to.IsZero() {
+		cond["$lt"] = to
+	}
+	if len(cond) == 0 {
+		return nil, nil
+	}
+	return cond, nil
+}
@@ -0,0 +1,527 @@
+// File: internal/handler/profile.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+	"backend/internal/notify"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// emailChangeTTL is how long an email change verification token stays valid.
+const emailChangeTTL = 24 * time.Hour
+
+// recentSignInWindow is how recently a user without a password must have signed in to delete
+// their account or change how they sign in.
+const recentSignInWindow = 5 * time.Minute
+
+// GetMe returns the profile of the logged-in user.
+func (h *APIHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var user database.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateMe changes the profile fields present in the request body.
+func (h *APIHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req struct {
+		Name       *string `json:"name"`
+		RollNumber *string `json:"rollNumber"`
+		Department *string `json:"department"`
+		AvatarURL  *string `json:"avatarUrl"`
+		Timezone   *string `json:"timezone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	set := bson.M{}
+	if req.Name != nil {
+		if *req.Name == "" {
+			http.Error(w, `{"error": "name must not be empty"}`, http.StatusBadRequest)
+			return
+		}
+		set["name"] = *req.Name
+	}
+	if req.RollNumber != nil {
+		set["roll_number"] = *req.RollNumber
+	}
+	if req.Department != nil {
+		set["department"] = *req.Department
+	}
+	if req.AvatarURL != nil {
+		set["avatar_url"] = *req.AvatarURL
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			http.Error(w, `{"error": "Invalid timezone"}`, http.StatusBadRequest)
+			return
+		}
+		set["timezone"] = *req.Timezone
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	usersCollection := h.DB.Collection("users")
+	if len(set) > 0 {
+		var before database.User
+		err := usersCollection.FindOneAndUpdate(ctx, bson.M{"_id": userID}, bson.M{"$set": set}).Decode(&before)
+		if err != nil && err != mongo.ErrNoDocuments {
+			h.internalError(w, r, "Failed to update profile", err)
+			return
+		}
+		if err == nil {
+			h.audit(ctx, r, database.AuditEntry{
+				Action:     actionUserUpdated,
+				TargetType: targetUser,
+				TargetID:   userID,
+				Before:     snapshotFields(before, set),
+				After:      set,
+			})
+		}
+	}
+
+	var user database.User
+	if err := usersCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// ChangePassword replaces the user's password after checking the current one.
+func (h *APIHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.NewPassword == "" {
+		http.Error(w, `{"error": "newPassword must not be empty"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := h.checkPassword(w, r, userID, req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		h.internalError(w, r, "Failed to hash password", err)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	_, err = h.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"password": hashedPassword}})
+	if err != nil {
+		h.internalError(w, r, "Failed to update password", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{Action: actionUserPasswordChanged, TargetType: targetUser, TargetID: user.ID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed"})
+}
+
+// RequestEmailChange starts an email change. The address only changes once the token sent
+// to the new address is confirmed with VerifyEmailChange.
+func (h *APIHandler) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req struct {
+		NewEmail string `json:"newEmail"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.NewEmail == "" {
+		http.Error(w, `{"error": "newEmail must not be empty"}`, http.StatusBadRequest)
+		return
+	}
+	// The token proves control of the new address, so it is only ever mailed there, never logged
+	if !h.Notify.Delivers(notify.ChannelEmail) {
+		http.Error(w, `{"error": "Email delivery is not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	user, ok := h.checkPassword(w, r, userID, req.Password)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var org database.Organization
+	if err := h.DB.Collection("organizations").FindOne(ctx, bson.M{"_id": user.OrganizationID}).Decode(&org); err != nil {
+		h.internalError(w, r, "Failed to load organization", err)
+		return
+	}
+	if !org.AllowsEmail(req.NewEmail) {
+		http.Error(w, `{"error": "This email domain is not allowed in your organization"}`, http.StatusForbidden)
+		return
+	}
+
+	usersCollection := h.DB.Collection("users")
+	count, err := usersCollection.CountDocuments(ctx, bson.M{"email": req.NewEmail})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count > 0 {
+		http.Error(w, `{"error": "User with this email already exists"}`, http.StatusConflict)
+		return
+	}
+
+	token, err := auth.GenerateSecureToken(16)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate verification token", err)
+		return
+	}
+	change := database.EmailChange{
+		NewEmail:  req.NewEmail,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(emailChangeTTL),
+	}
+	_, err = usersCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"email_change": change}})
+	if err != nil {
+		h.internalError(w, r, "Failed to start email change", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionUserEmailRequested,
+		TargetType: targetUser,
+		TargetID:   user.ID,
+		Before:     bson.M{"email": user.Email},
+		After:      bson.M{"new_email": req.NewEmail},
+	})
+
+	err = h.Notify.EnqueueTo(ctx, notify.ChannelEmail, req.NewEmail, notify.Message{
+		Event: notify.EventEmailVerification,
+		Title: "Confirm your new email address",
+		Body:  "Enter this code in the app to confirm your new email address: " + token + "\n\nIf you did not ask for this change, ignore this email.",
+	})
+	if err != nil {
+		h.internalError(w, r, "Failed to send verification email", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification sent to the new email address"})
+}
+
+// VerifyEmailChange completes a pending email change.
+func (h *APIHandler) VerifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var user database.User
+	usersCollection := h.DB.Collection("users")
+	err := usersCollection.FindOne(ctx, bson.M{
+		"_id":                     userID,
+		"email_change.token_hash": auth.HashToken(req.Token),
+		"email_change.expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Invalid or expired verification token"}`, http.StatusBadRequest)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	// The address may have been taken since the change was requested
+	count, err := usersCollection.CountDocuments(ctx, bson.M{"email": user.EmailChange.NewEmail})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count > 0 {
+		http.Error(w, `{"error": "User with this email already exists"}`, http.StatusConflict)
+		return
+	}
+
+	_, err = usersCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set":   bson.M{"email": user.EmailChange.NewEmail},
+			"$unset": bson.M{"email_change": ""},
+		},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to update email", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:     actionUserEmailChanged,
+		TargetType: targetUser,
+		TargetID:   userID,
+		Before:     bson.M{"email": user.Email},
+		After:      bson.M{"email": user.EmailChange.NewEmail},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email changed"})
+}
+
+// ExportMe returns everything stored about the logged-in user.
+func (h *APIHandler) ExportMe(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var user database.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	classrooms := []database.Classroom{}
+	cursor, err := h.DB.Collection("classrooms").Find(ctx, bson.M{"student_ids": userID})
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch classrooms", err)
+		return
+	}
+	if err = cursor.All(ctx, &classrooms); err != nil {
+		h.internalError(w, r, "Failed to decode classrooms", err)
+		return
+	}
+
+	records := []database.AttendanceRecord{}
+	cursor, err = h.DB.Collection("attendance_records").Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch attendance records", err)
+		return
+	}
+	if err = cursor.All(ctx, &records); err != nil {
+		h.internalError(w, r, "Failed to decode attendance records", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="account-export.json"`)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"exportedAt":        time.Now(),
+		"profile":           user,
+		"classrooms":        classrooms,
+		"attendanceRecords": records,
+	})
+}
+
+// DeleteMe permanently deletes the logged-in user's account. Attendance records are kept for
+// class statistics but re-keyed to a random ID so they can no longer be tied to the person.
+// Users still instructing classes must transfer or delete them first.
+func (h *APIHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	if refuseImpersonation(w, r) {
+		return
+	}
+
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := h.checkPassword(w, r, userID, req.Password)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroomsCollection := h.DB.Collection("classrooms")
+	count, err := classroomsCollection.CountDocuments(ctx, bson.M{"instructor_id": user.ID, "deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count > 0 {
+		http.Error(w, `{"error": "Transfer or delete the classes you instruct before deleting your account"}`, http.StatusConflict)
+		return
+	}
+
+	// The user's records and audit entries keep counting under an ID that belongs to nobody
+	pseudonym := primitive.NewObjectID()
+	_, err = h.DB.Collection("attendance_records").UpdateMany(
+		ctx,
+		bson.M{"user_id": user.ID},
+		bson.M{"$set": bson.M{"user_id": pseudonym}, "$unset": bson.M{"device_id": "", "ip": "", "location": ""}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to anonymize attendance records", err)
+		return
+	}
+
+	_, err = classroomsCollection.UpdateMany(
+		ctx,
+		bson.M{"student_ids": user.ID},
+		bson.M{"$pull": bson.M{"student_ids": user.ID}, "$unset": bson.M{"joined_at." + user.ID.Hex(): ""}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to remove user from classrooms", err)
+		return
+	}
+
+	if _, err = h.DB.Collection("api_keys").DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		h.internalError(w, r, "Failed to delete API keys", err)
+		return
+	}
+
+	if _, err = h.DB.Collection("users").DeleteOne(ctx, bson.M{"_id": user.ID}); err != nil {
+		h.internalError(w, r, "Failed to delete user", err)
+		return
+	}
+	// No snapshot: the account's personal data is gone on purpose
+	h.audit(ctx, r, database.AuditEntry{Action: actionUserDeleted, ActorID: pseudonym, TargetType: targetUser, TargetID: pseudonym})
+	if err = h.pseudonymizeAudit(ctx, user.ID, pseudonym); err != nil {
+		h.internalError(w, r, "Failed to anonymize audit log", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pseudonymizeAudit replaces a deleted user's ID in the audit log with pseudonym and removes the
+// client details of their requests and the snapshots of their account.
+func (h *APIHandler) pseudonymizeAudit(ctx context.Context, userID, pseudonym primitive.ObjectID) error {
+	auditCollection := h.DB.Collection("audit_log")
+	_, err := auditCollection.UpdateMany(
+		ctx,
+		bson.M{"actor_id": bson.M{"$in": []primitive.ObjectID{userID, pseudonym}}},
+		bson.M{"$set": bson.M{"actor_id": pseudonym}, "$unset": bson.M{"ip": "", "user_agent": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = auditCollection.UpdateMany(
+		ctx,
+		bson.M{"target_id": userID},
+		bson.M{"$set": bson.M{"target_id": pseudonym}, "$unset": bson.M{"before": "", "after": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	// Attendance record snapshots name the student
+	_, err = auditCollection.UpdateMany(ctx, bson.M{"after.user_id": userID}, bson.M{"$set": bson.M{"after.user_id": pseudonym}})
+	return err
+}
+
+// checkPassword loads the user and verifies their password, or for accounts without one that
+// they signed in within recentSignInWindow, writing the error response itself when the check fails.
+func (h *APIHandler) checkPassword(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID, password string) (*database.User, bool) {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var user database.User
+	err := h.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+			return nil, false
+		}
+		h.internalError(w, r, "Database error", err)
+		return nil, false
+	}
+	// Accounts created through single sign-on or an LMS have no password to confirm; they must
+	// have signed in recently instead, so a stolen token is not enough
+	if user.Password == "" {
+		authTime, _ := r.Context().Value(AuthTimeContextKey).(time.Time)
+		if authTime.IsZero() || time.Since(authTime) > recentSignInWindow {
+			http.Error(w, `{"error": "Sign in again to confirm this change"}`, http.StatusUnauthorized)
+			return nil, false
+		}
+		return &user, true
+	}
+	if !auth.CheckPasswordHash(password, user.Password) {
+		http.Error(w, `{"error": "Current password is incorrect"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+	return &user, true
+}
@@ -0,0 +1,141 @@
+// File: internal/handler/schedule.go
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/internal/database"
+	"backend/internal/schedule"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UpdateClassSchedule replaces the timetable of a classroom.
+func (h *APIHandler) UpdateClassSchedule(w http.ResponseWriter, r *http.Request) {
+	instructorIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	instructorID, _ := primitive.ObjectIDFromHex(instructorIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req database.ClassSchedule
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Holidays == nil {
+		req.Holidays = []string{}
+	}
+	if req.Rules == nil {
+		req.Rules = []database.ScheduleRule{}
+	}
+	if err := schedule.Validate(&req); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var classroom database.Classroom
+	classroomsCollection := h.DB.Collection("classrooms")
+	err = classroomsCollection.FindOne(ctx, bson.M{"_id": classID, "instructor_id": instructorID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Forbidden: You are not the instructor of this class"}`, http.StatusForbidden)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if classroom.ArchivedAt != nil {
+		http.Error(w, `{"error": "This classroom is archived"}`, http.StatusConflict)
+		return
+	}
+
+	_, err = classroomsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": classID},
+		bson.M{"$set": bson.M{"schedule": req}},
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to update schedule", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionClassScheduleUpdated,
+		TargetType:  targetClassroom,
+		TargetID:    classID,
+		ClassroomID: &classID,
+		Before:      classroom.Schedule,
+		After:       req,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// GetUpcomingMeetings lists the scheduled meetings of a class for the next few days.
+// The look-ahead defaults to 7 days and can be changed with the "days" query parameter.
+func (h *APIHandler) GetUpcomingMeetings(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	classIDHex := chi.URLParam(r, "classID")
+	classID, err := primitive.ObjectIDFromHex(classIDHex)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid classroom ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 || days > 366 {
+			http.Error(w, `{"error": "days must be between 1 and 366"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	var classroom database.Classroom
+	classroomsCollection := h.DB.Collection("classrooms")
+	err = classroomsCollection.FindOne(ctx, bson.M{"_id": classID, "student_ids": userID, "organization_id": orgID, "deleted_at": bson.M{"$exists": false}}).Decode(&classroom)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Forbidden: You are not enrolled in this class"}`, http.StatusForbidden)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if classroom.Schedule == nil {
+		json.NewEncoder(w).Encode([]database.Meeting{})
+		return
+	}
+
+	now := time.Now()
+	meetings, err := schedule.Meetings(classroom.Schedule, now, now.AddDate(0, 0, days))
+	if err != nil {
+		h.internalError(w, r, "Failed to expand schedule", err)
+		return
+	}
+	json.NewEncoder(w).Encode(meetings)
+}
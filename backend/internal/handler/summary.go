@@ -0,0 +1,110 @@
+// File: internal/handler/summary.go
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetMyAttendanceSummary reports, for each class the user is enrolled in as a student, how many
+// lectures were held and attended, the current attendance streak and how many more lectures can
+// be missed before falling under the class's required percentage. Records that predate lectures
+// are not counted, nor are lectures still under way or held before the student joined.
+func (h *APIHandler) GetMyAttendanceSummary(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	classroomsCollection := h.DB.Collection("classrooms")
+	classFilter := bson.M{
+		"student_ids":     userID,
+		"instructor_id":   bson.M{"$ne": userID},
+		"organization_id": orgID,
+		"deleted_at":      bson.M{"$exists": false},
+	}
+	cursor, err := classroomsCollection.Find(ctx, classFilter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch classes", err)
+		return
+	}
+	var classrooms []database.Classroom
+	if err = cursor.All(ctx, &classrooms); err != nil {
+		h.internalError(w, r, "Failed to decode classes", err)
+		return
+	}
+
+	summaries := []database.StudentClassSummary{}
+	if len(classrooms) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summaries)
+		return
+	}
+	classIDs := make([]primitive.ObjectID, len(classrooms))
+	for i, classroom := range classrooms {
+		classIDs[i] = classroom.ID
+	}
+
+	// Newest first, so the streak can be counted from the front
+	lecturesCollection := h.DB.Collection("lectures")
+	now := time.Now()
+	cursor, err = lecturesCollection.Find(ctx,
+		bson.M{"classroom_id": bson.M{"$in": classIDs}, "started_at": bson.M{"$lte": now}},
+		options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}}).SetMaxTime(h.AggregateTimeout),
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch lectures", err)
+		return
+	}
+	var lectures []database.Lecture
+	if err = cursor.All(ctx, &lectures); err != nil {
+		h.internalError(w, r, "Failed to decode lectures", err)
+		return
+	}
+
+	attendanceCollection := h.DB.Collection("attendance_records")
+	cursor, err = attendanceCollection.Find(ctx,
+		bson.M{"user_id": userID, "classroom_id": bson.M{"$in": classIDs}, "lecture_id": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"lecture_id": 1, "status": 1}).SetMaxTime(h.AggregateTimeout),
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch attendance", err)
+		return
+	}
+	var records []database.AttendanceRecord
+	if err = cursor.All(ctx, &records); err != nil {
+		h.internalError(w, r, "Failed to decode attendance", err)
+		return
+	}
+	statusByLecture := make(map[primitive.ObjectID]string, len(records))
+	for _, record := range records {
+		status := record.Status
+		if status == "" {
+			status = database.StatusPresent
+		}
+		statusByLecture[record.LectureID] = status
+	}
+
+	lecturesByClass := make(map[primitive.ObjectID][]database.Lecture)
+	for _, lecture := range lectures {
+		lecturesByClass[lecture.ClassroomID] = append(lecturesByClass[lecture.ClassroomID], lecture)
+	}
+
+	for _, classroom := range classrooms {
+		summary := database.SummarizeAttendance(classroom, userID, lecturesByClass[classroom.ID], statusByLecture, now)
+		summaries = append(summaries, summary)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
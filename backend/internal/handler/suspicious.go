@@ -0,0 +1,138 @@
+// File: internal/handler/suspicious.go
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"backend/internal/database"
+	"backend/internal/fraud"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// suspiciousDefaultRange is how far back the report looks when no "from" is given.
+const suspiciousDefaultRange = 30 * 24 * time.Hour
+
+// GetSuspiciousAttendance runs the fraud checks over a class's attendance and lists what they
+// found, with the students involved. It accepts "from"/"to" on the scan time; by default the
+// last 30 days are analysed.
+func (h *APIHandler) GetSuspiciousAttendance(w http.ResponseWriter, r *http.Request) {
+	timeRange, err := timeRangeFilter(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeRange == nil {
+		timeRange = bson.M{}
+	}
+	if _, ok := timeRange["$gte"]; !ok {
+		timeRange["$gte"] = time.Now().Add(-suspiciousDefaultRange)
+	}
+
+	ctx, cancel := h.aggregateContext(r)
+	defer cancel()
+
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	attendanceCollection := h.DB.Collection("attendance_records")
+	notExcused := bson.M{"$ne": database.StatusExcused}
+	cursor, err := attendanceCollection.Find(ctx,
+		bson.M{"classroom_id": classroom.ID, "status": notExcused, "timestamp": timeRange},
+		options.Find().SetSort(bson.M{"timestamp": 1}).SetMaxTime(h.AggregateTimeout),
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch attendance", err)
+		return
+	}
+	var records []database.AttendanceRecord
+	if err = cursor.All(ctx, &records); err != nil {
+		h.internalError(w, r, "Failed to decode attendance", err)
+		return
+	}
+
+	// The same students' marks in other classrooms around the analysed period
+	var others []database.AttendanceRecord
+	if len(records) > 0 {
+		userIDs := make([]primitive.ObjectID, 0, len(records))
+		for _, record := range records {
+			userIDs = append(userIDs, record.UserID)
+		}
+		window := fraud.DefaultOptions.ConcurrencyWindow
+		cursor, err = attendanceCollection.Find(ctx,
+			bson.M{
+				"user_id":      bson.M{"$in": userIDs},
+				"classroom_id": bson.M{"$ne": classroom.ID},
+				"status":       notExcused,
+				"timestamp": bson.M{
+					"$gte": records[0].Timestamp.Add(-window),
+					"$lte": records[len(records)-1].Timestamp.Add(window),
+				},
+			},
+			options.Find().SetMaxTime(h.AggregateTimeout),
+		)
+		if err != nil {
+			h.internalError(w, r, "Failed to fetch attendance in other classes", err)
+			return
+		}
+		if err = cursor.All(ctx, &others); err != nil {
+			h.internalError(w, r, "Failed to decode attendance in other classes", err)
+			return
+		}
+	}
+
+	findings := fraud.Analyze(records, others, fraud.Options{Geofence: classroom.Settings.Geofence})
+	if findings == nil {
+		findings = []fraud.Finding{}
+	}
+
+	involved := map[primitive.ObjectID]bool{}
+	for _, finding := range findings {
+		for _, userID := range finding.UserIDs {
+			involved[userID] = true
+		}
+	}
+	students := []database.User{}
+	if len(involved) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(involved))
+		for id := range involved {
+			ids = append(ids, id)
+		}
+		cursor, err = h.DB.Collection("users").Find(ctx,
+			bson.M{"_id": bson.M{"$in": ids}},
+			options.Find().SetProjection(bson.M{"name": 1, "email": 1, "roll_number": 1}).SetSort(bson.M{"name": 1}),
+		)
+		if err != nil {
+			h.internalError(w, r, "Failed to fetch students", err)
+			return
+		}
+		if err = cursor.All(ctx, &students); err != nil {
+			h.internalError(w, r, "Failed to decode students", err)
+			return
+		}
+	}
+
+	type studentRef struct {
+		ID         primitive.ObjectID `json:"id"`
+		Name       string             `json:"name"`
+		Email      string             `json:"email"`
+		RollNumber string             `json:"rollNumber,omitempty"`
+	}
+	refs := make([]studentRef, len(students))
+	for i, student := range students {
+		refs[i] = studentRef{ID: student.ID, Name: student.Name, Email: student.Email, RollNumber: student.RollNumber}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"findings": findings,
+		"students": refs,
+	})
+}
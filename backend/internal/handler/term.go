@@ -0,0 +1,101 @@
+// File: internal/handler/term.go
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateTerm creates an academic term that classrooms can be attached to.
+func (h *APIHandler) CreateTerm(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	var req struct {
+		Name  string    `json:"name"`
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body, dates must be RFC 3339"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" || req.Start.IsZero() || req.End.IsZero() {
+		http.Error(w, `{"error": "name, start and end are required"}`, http.StatusBadRequest)
+		return
+	}
+	if !req.End.After(req.Start) {
+		http.Error(w, `{"error": "end must be after start"}`, http.StatusBadRequest)
+		return
+	}
+
+	newTerm := database.Term{
+		ID:             primitive.NewObjectID(),
+		Name:           req.Name,
+		Start:          req.Start,
+		End:            req.End,
+		CreatedBy:      userID,
+		OrganizationID: orgID,
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	termsCollection := h.DB.Collection("terms")
+	if _, err := termsCollection.InsertOne(ctx, newTerm); err != nil {
+		h.internalError(w, r, "Failed to create term", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{Action: actionTermCreated, TargetType: targetTerm, TargetID: newTerm.ID, After: newTerm})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newTerm)
+}
+
+// GetTerms lists the terms the user created or is enrolled in a class of, newest first.
+func (h *APIHandler) GetTerms(w http.ResponseWriter, r *http.Request) {
+	userIDHex, _ := r.Context().Value(UserIDContextKey).(string)
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	orgID, _ := r.Context().Value(OrgIDContextKey).(primitive.ObjectID)
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	classroomsCollection := h.DB.Collection("classrooms")
+	termIDs, err := classroomsCollection.Distinct(ctx, "term_id", bson.M{"student_ids": userID, "organization_id": orgID})
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch terms", err)
+		return
+	}
+
+	termsCollection := h.DB.Collection("terms")
+	filter := bson.M{"organization_id": orgID, "$or": bson.A{
+		bson.M{"created_by": userID},
+		bson.M{"_id": bson.M{"$in": termIDs}},
+	}}
+	cursor, err := termsCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"start": -1}))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch terms", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	terms := []database.Term{}
+	if err = cursor.All(ctx, &terms); err != nil {
+		h.internalError(w, r, "Failed to decode terms", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(terms)
+}
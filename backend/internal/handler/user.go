@@ -3,12 +3,18 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"backend/internal/auth"
 	"backend/internal/database"
+	"backend/internal/lti"
+	"backend/internal/notify"
+	"backend/internal/oidc"
+	"backend/internal/webhook"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,14 +25,54 @@ import (
 type APIHandler struct {
 	DB         *mongo.Database
 	JWT_Secret string
+	Logger     *slog.Logger
+	Version    string    // Build version reported by Readyz
+	StartedAt  time.Time // Process start, for uptime reporting
+
+	// Deadlines for store calls made while serving a request
+	QueryTimeout     time.Duration
+	AggregateTimeout time.Duration
+
+	// Device binding, see config.Config
+	MaxDevices      int
+	RequireDeviceID bool
+
+	Notify   *notify.Service     // Queues user notifications; may be nil
+	Webhooks *webhook.Dispatcher // Queues class events for webhooks; may be nil
+
+	// LTI 1.3 tool; nil when no platform is registered. PublicURL is the externally visible base
+	// URL of this server, LTIRedirectURL the app page launches hand the session token to.
+	LTI            *lti.Tool
+	PublicURL      string
+	LTIRedirectURL string
+
+	// OpenID Connect single sign-on; nil when no issuer is configured. OIDCAppRedirectURLs are
+	// the app pages a login may hand the session token to. OIDCTrustEmail counts emails as
+	// verified when the provider does not say.
+	OIDC                *oidc.Provider
+	OIDCAppRedirectURLs []string
+	OIDCJITProvisioning bool
+	OIDCTrustEmail      bool
+
+	PasswordLoginDisabled bool // Refuse email and password registration and login
+
+	AuditRetention time.Duration // How long audit log entries are kept; 0 keeps them forever
+
+	shuttingDown atomic.Bool
 }
 
 // Register handles user registration.
 func (h *APIHandler) Register(w http.ResponseWriter, r *http.Request) {
+	if h.PasswordLoginDisabled {
+		http.Error(w, `{"error": "Password sign-in is disabled; use single sign-on"}`, http.StatusForbidden)
+		return
+	}
 	var req struct {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// Slug of the organization to join; by default the one claiming the email's domain
+		Organization string `json:"organization"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -36,15 +82,18 @@ func (h *APIHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to hash password", err)
 		return
 	}
 
 	usersCollection := h.DB.Collection("users")
 
-	count, err := usersCollection.CountDocuments(context.TODO(), bson.M{"email": req.Email})
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	count, err := usersCollection.CountDocuments(ctx, bson.M{"email": req.Email})
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		h.internalError(w, r, "Database error", err)
 		return
 	}
 	if count > 0 {
@@ -52,19 +101,33 @@ func (h *APIHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	org, ok := h.organizationFor(ctx, w, r, req.Organization, req.Email)
+	if !ok {
+		return
+	}
+
 	newUser := database.User{
-		ID:           primitive.NewObjectID(),
-		Name:         req.Name,
-		Email:        req.Email,
-		Password:     hashedPassword,
-		ClassroomIDs: []primitive.ObjectID{},
+		ID:             primitive.NewObjectID(),
+		Name:           req.Name,
+		Email:          req.Email,
+		Password:       hashedPassword,
+		ClassroomIDs:   []primitive.ObjectID{},
+		OrganizationID: org.ID,
 	}
 
-	_, err = usersCollection.InsertOne(context.TODO(), newUser)
+	_, err = usersCollection.InsertOne(ctx, newUser)
 	if err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to create user", err)
 		return
 	}
+	h.audit(ctx, r, database.AuditEntry{
+		ActorID:        newUser.ID,
+		OrganizationID: newUser.OrganizationID,
+		Action:         actionUserRegistered,
+		TargetType:     targetUser,
+		TargetID:       newUser.ID,
+		After:          userSnapshot(&newUser),
+	})
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"message": "User created successfully"})
@@ -72,6 +135,10 @@ func (h *APIHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 // Login handles user login and token generation.
 func (h *APIHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if h.PasswordLoginDisabled {
+		http.Error(w, `{"error": "Password sign-in is disabled; use single sign-on"}`, http.StatusForbidden)
+		return
+	}
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -85,13 +152,16 @@ func (h *APIHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var user database.User
 	usersCollection := h.DB.Collection("users")
 
-	err := usersCollection.FindOne(context.TODO(), bson.M{"email": req.Email}).Decode(&user)
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	err := usersCollection.FindOne(ctx, bson.M{"email": req.Email}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		h.internalError(w, r, "Database error", err)
 		return
 	}
 
@@ -99,11 +169,15 @@ func (h *APIHandler) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if user.DisabledAt != nil {
+		http.Error(w, `{"error": "This account is disabled"}`, http.StatusForbidden)
+		return
+	}
 
 	// MODIFIED: Pass user.Name to the GenerateJWT function
-	tokenString, err := auth.GenerateJWT(user.ID.Hex(), user.Name, h.JWT_Secret)
+	tokenString, err := auth.GenerateJWT(user.ID.Hex(), user.Name, user.OrganizationID.Hex(), h.JWT_Secret)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		h.internalError(w, r, "Failed to generate token", err)
 		return
 	}
 
@@ -0,0 +1,428 @@
+// File: internal/handler/webhook.go
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+	"backend/internal/webhook"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxWebhooksPerScope bounds the endpoints a class or an organization sends its events to.
+const maxWebhooksPerScope = 10
+
+// webhookScope is the owner of the webhooks a request manages: one class, or an organization
+// whose webhooks receive the events of all its classes.
+type webhookScope struct {
+	ClassroomID    primitive.ObjectID
+	OrganizationID primitive.ObjectID
+}
+
+// filter matches the webhooks of the scope.
+func (s webhookScope) filter() bson.M {
+	if s.ClassroomID.IsZero() {
+		return bson.M{"organization_id": s.OrganizationID}
+	}
+	return bson.M{"classroom_id": s.ClassroomID}
+}
+
+// auditClassroom is the class audit entries about the scope's webhooks belong to, if any.
+func (s webhookScope) auditClassroom() *primitive.ObjectID {
+	if s.ClassroomID.IsZero() {
+		return nil
+	}
+	return &s.ClassroomID
+}
+
+// webhookSnapshot is the part of a webhook recorded in the audit log, leaving out its secret.
+func webhookSnapshot(hook *database.Webhook) bson.M {
+	return bson.M{"url": hook.URL, "events": hook.Events, "active": hook.Active}
+}
+
+// webhookScope resolves whose webhooks the request manages: the instructor's class under
+// /classes/{classID}/webhooks, the organization under /org/webhooks, which only its admins may
+// manage. Otherwise it writes the error response and returns false.
+func (h *APIHandler) webhookScope(ctx context.Context, w http.ResponseWriter, r *http.Request) (webhookScope, bool) {
+	if chi.URLParam(r, "classID") == "" {
+		admin, ok := h.orgAdmin(ctx, w, r)
+		if !ok {
+			return webhookScope{}, false
+		}
+		return webhookScope{OrganizationID: admin.OrganizationID}, true
+	}
+	classroom, ok := h.instructedClassroom(ctx, w, r)
+	if !ok {
+		return webhookScope{}, false
+	}
+	return webhookScope{ClassroomID: classroom.ID}, true
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// validate checks the endpoint and events of a webhook.
+func (req *webhookRequest) validate() string {
+	// The server posts to this URL, so only accept HTTPS endpoints
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "url must be an https URL"
+	}
+	if len(req.Events) == 0 {
+		return "events must list at least one event"
+	}
+	for _, event := range req.Events {
+		if !containsString(webhook.Events, event) {
+			return "Unknown event " + event
+		}
+	}
+	return ""
+}
+
+// writeWebhooksDisabled refuses work that needs webhook delivery when it is turned off, reporting
+// whether it did.
+func (h *APIHandler) writeWebhooksDisabled(w http.ResponseWriter) bool {
+	if h.Webhooks != nil {
+		return false
+	}
+	http.Error(w, `{"error": "Webhook delivery is disabled"}`, http.StatusServiceUnavailable)
+	return true
+}
+
+// CreateWebhook registers an endpoint for events of the instructor's class or of the admin's
+// organization. The response holds the signing secret, which is not shown again.
+func (h *APIHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.writeWebhooksDisabled(w) {
+		return
+	}
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	scope, ok := h.webhookScope(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	webhooksCollection := h.DB.Collection("webhooks")
+	count, err := webhooksCollection.CountDocuments(ctx, scope.filter())
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+	if count >= maxWebhooksPerScope {
+		http.Error(w, `{"error": "Too many webhooks; remove one first"}`, http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		h.internalError(w, r, "Failed to generate webhook secret", err)
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(r.Context().Value(UserIDContextKey).(string))
+	hook := database.Webhook{
+		ID:             primitive.NewObjectID(),
+		ClassroomID:    scope.ClassroomID,
+		OrganizationID: scope.OrganizationID,
+		URL:            req.URL,
+		Events:         req.Events,
+		Secret:         secret,
+		Active:         req.Active == nil || *req.Active,
+		CreatedBy:      userID,
+		CreatedAt:      time.Now(),
+	}
+	if _, err := webhooksCollection.InsertOne(ctx, hook); err != nil {
+		h.internalError(w, r, "Failed to create webhook", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionWebhookCreated,
+		TargetType:  targetWebhook,
+		TargetID:    hook.ID,
+		ClassroomID: scope.auditClassroom(),
+		After:       webhookSnapshot(&hook),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"webhook": hook,
+		"secret":  secret,
+	})
+}
+
+// GetWebhooks lists the webhooks of the class or organization and the events they can subscribe to.
+func (h *APIHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	scope, ok := h.webhookScope(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	cursor, err := h.DB.Collection("webhooks").Find(ctx, scope.filter(), options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch webhooks", err)
+		return
+	}
+	hooks := []database.Webhook{}
+	if err = cursor.All(ctx, &hooks); err != nil {
+		h.internalError(w, r, "Failed to decode webhooks", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"webhooks": hooks,
+		"events":   webhook.Events,
+	})
+}
+
+// UpdateWebhook replaces the URL and events of a webhook, and enables or disables it.
+// Deliveries already queued keep their payload but are sent to the new URL.
+func (h *APIHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	scope, ok := h.webhookScope(ctx, w, r)
+	if !ok {
+		return
+	}
+	webhookID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "webhookID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid webhook ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	set := bson.M{"url": req.URL, "events": req.Events}
+	if req.Active != nil {
+		set["active"] = *req.Active
+	}
+	filter := scope.filter()
+	filter["_id"] = webhookID
+	var hook database.Webhook
+	err = h.DB.Collection("webhooks").FindOneAndUpdate(ctx, filter, bson.M{"$set": set}).Decode(&hook)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Webhook not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Failed to update webhook", err)
+		return
+	}
+	before := webhookSnapshot(&hook)
+	hook.URL, hook.Events = req.URL, req.Events
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionWebhookUpdated,
+		TargetType:  targetWebhook,
+		TargetID:    hook.ID,
+		ClassroomID: scope.auditClassroom(),
+		Before:      before,
+		After:       webhookSnapshot(&hook),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hook)
+}
+
+// DeleteWebhook removes a webhook together with its delivery log.
+func (h *APIHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	scope, ok := h.webhookScope(ctx, w, r)
+	if !ok {
+		return
+	}
+	webhookID, ok := h.scopedWebhookID(ctx, w, r, scope)
+	if !ok {
+		return
+	}
+
+	var hook database.Webhook
+	if err := h.DB.Collection("webhooks").FindOneAndDelete(ctx, bson.M{"_id": webhookID}).Decode(&hook); err != nil && err != mongo.ErrNoDocuments {
+		h.internalError(w, r, "Failed to delete webhook", err)
+		return
+	}
+	if _, err := h.DB.Collection("webhook_deliveries").DeleteMany(ctx, bson.M{"webhook_id": webhookID}); err != nil {
+		h.internalError(w, r, "Failed to delete webhook deliveries", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionWebhookDeleted,
+		TargetType:  targetWebhook,
+		TargetID:    webhookID,
+		ClassroomID: scope.auditClassroom(),
+		Before:      webhookSnapshot(&hook),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Webhook deleted"})
+}
+
+// GetWebhookDeliveries returns a page of a webhook's delivery log, newest first. It accepts a
+// "status" filter and the shared "limit"/"cursor" pagination parameters.
+func (h *APIHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	page, err := parseListParams(r, map[string]string{"createdAt": "_id"}, "-createdAt")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	scope, ok := h.webhookScope(ctx, w, r)
+	if !ok {
+		return
+	}
+	webhookID, ok := h.scopedWebhookID(ctx, w, r, scope)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"webhook_id": webhookID}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+	if keyset := page.keysetFilter(); keyset != nil {
+		filter = bson.M{"$and": bson.A{filter, keyset}}
+	}
+	cursor, err := h.DB.Collection("webhook_deliveries").Find(ctx, filter,
+		options.Find().SetSort(page.sort()).SetLimit(int64(page.Limit+1)),
+	)
+	if err != nil {
+		h.internalError(w, r, "Failed to fetch webhook deliveries", err)
+		return
+	}
+	deliveries := []database.WebhookDelivery{}
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		h.internalError(w, r, "Failed to decode webhook deliveries", err)
+		return
+	}
+	deliveries = paginate(w, r, page, deliveries, func(d database.WebhookDelivery) (interface{}, primitive.ObjectID) {
+		return d.ID, d.ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// ReplayWebhookDelivery sends a logged delivery's payload again, as a new delivery. The payload
+// keeps its event id, so receivers can recognise events they already processed.
+func (h *APIHandler) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	if h.writeWebhooksDisabled(w) {
+		return
+	}
+	ctx, cancel := h.queryContext(r)
+	defer cancel()
+
+	scope, ok := h.webhookScope(ctx, w, r)
+	if !ok {
+		return
+	}
+	webhookID, ok := h.scopedWebhookID(ctx, w, r, scope)
+	if !ok {
+		return
+	}
+	deliveryID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid delivery ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var original database.WebhookDelivery
+	err = h.DB.Collection("webhook_deliveries").FindOne(ctx, bson.M{"_id": deliveryID, "webhook_id": webhookID}).Decode(&original)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"error": "Delivery not found"}`, http.StatusNotFound)
+			return
+		}
+		h.internalError(w, r, "Database error", err)
+		return
+	}
+
+	delivery, err := h.Webhooks.Replay(ctx, &original)
+	if err != nil {
+		h.internalError(w, r, "Failed to queue replay", err)
+		return
+	}
+	h.audit(ctx, r, database.AuditEntry{
+		Action:      actionWebhookReplayed,
+		TargetType:  targetWebhook,
+		TargetID:    webhookID,
+		ClassroomID: scope.auditClassroom(),
+		After:       bson.M{"delivery_id": delivery.ID, "replay_of": original.ID, "event": original.Event},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// scopedWebhookID parses the webhook in the URL and checks it belongs to the scope. Otherwise it
+// writes the error response and returns false.
+func (h *APIHandler) scopedWebhookID(ctx context.Context, w http.ResponseWriter, r *http.Request, scope webhookScope) (primitive.ObjectID, bool) {
+	webhookID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "webhookID"))
+	if err != nil {
+		http.Error(w, `{"error": "Invalid webhook ID"}`, http.StatusBadRequest)
+		return webhookID, false
+	}
+	filter := scope.filter()
+	filter["_id"] = webhookID
+	count, err := h.DB.Collection("webhooks").CountDocuments(ctx, filter)
+	if err != nil {
+		h.internalError(w, r, "Database error", err)
+		return webhookID, false
+	}
+	if count == 0 {
+		http.Error(w, `{"error": "Webhook not found"}`, http.StatusNotFound)
+		return webhookID, false
+	}
+	return webhookID, true
+}
+
+// emitWebhook queues a class event for its webhooks without failing the request; problems are only logged.
+func (h *APIHandler) emitWebhook(ctx context.Context, r *http.Request, event webhook.Event) {
+	if err := h.Webhooks.Emit(ctx, event); err != nil {
+		h.logger(r).Error("Failed to queue webhook event", "event", event.Type, "error", err)
+	}
+}
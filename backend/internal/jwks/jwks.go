@@ -0,0 +1,156 @@
+// File: internal/jwks/jwks.go
+
+// Package jwks fetches and caches the JSON Web Key Sets identity providers and LMS platforms
+// sign their tokens with.
+package jwks
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// ttl is how long a key set is cached; unknown key IDs refetch sooner.
+	ttl = time.Hour
+	// refetchInterval limits refetches triggered by unknown key IDs.
+	refetchInterval = time.Minute
+)
+
+// JWK is a JSON Web Key; only RSA signing keys are used.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// Set is a JSON Web Key Set.
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// FromRSA builds the JWK of an RSA public key, for publishing a signing key.
+func FromRSA(pub *rsa.PublicKey, kid string) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		Algorithm: "RS256",
+		Use:       "sig",
+		KeyID:     kid,
+		N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+// PublicKey decodes an RSA JWK.
+func (j JWK) PublicKey() (*rsa.PublicKey, error) {
+	if j.KeyType != "RSA" {
+		return nil, fmt.Errorf("jwks: unsupported key type %q", j.KeyType)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: invalid key modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("jwks: invalid key exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// Fetch downloads a key set.
+func Fetch(ctx context.Context, client *http.Client, url string) (*Set, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: %s returned %d", url, resp.StatusCode)
+	}
+	var set Set
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("jwks: invalid key set: %w", err)
+	}
+	return &set, nil
+}
+
+// Cache holds the RSA signing keys of several key sets by URL.
+type Cache struct {
+	Client *http.Client
+
+	mu   sync.Mutex
+	sets map[string]*cachedSet
+}
+
+type cachedSet struct {
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewCache creates an empty cache fetching with client.
+func NewCache(client *http.Client) *Cache {
+	return &Cache{Client: client, sets: map[string]*cachedSet{}}
+}
+
+// Key returns the key with the given ID from the key set at url. The set is fetched when it is
+// not cached, stale, or does not hold the key (issuers rotate keys).
+func (c *Cache) Key(ctx context.Context, url, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	cached := c.sets[url]
+	c.mu.Unlock()
+
+	if cached != nil && time.Since(cached.fetchedAt) < ttl {
+		if key := lookup(cached.keys, kid); key != nil {
+			return key, nil
+		}
+		if time.Since(cached.fetchedAt) < refetchInterval {
+			return nil, fmt.Errorf("jwks: unknown key %q", kid)
+		}
+	}
+
+	set, err := Fetch(ctx, c.Client, url)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	c.mu.Lock()
+	c.sets[url] = &cachedSet{keys: keys, fetchedAt: time.Now()}
+	c.mu.Unlock()
+
+	if key := lookup(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("jwks: unknown key %q", kid)
+}
+
+// lookup finds a key by ID; tokens without a kid match a key set holding a single key.
+func lookup(keys map[string]*rsa.PublicKey, kid string) *rsa.PublicKey {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return keys[kid]
+}
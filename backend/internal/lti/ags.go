@@ -0,0 +1,185 @@
+// File: internal/lti/ags.go
+
+package lti
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Media types of the Assignment and Grade Services.
+const (
+	mediaLineItem = "application/vnd.ims.lis.v2.lineitem+json"
+	mediaScore    = "application/vnd.ims.lis.v1.score+json"
+)
+
+// LineItem is a gradebook column.
+type LineItem struct {
+	ID           string  `json:"id,omitempty"`
+	Label        string  `json:"label"`
+	ScoreMaximum float64 `json:"scoreMaximum"`
+	ResourceID   string  `json:"resourceId,omitempty"`
+	Tag          string  `json:"tag,omitempty"`
+}
+
+// Score is a result posted to a line item for one user.
+type Score struct {
+	UserID           string    `json:"userId"`
+	ScoreGiven       float64   `json:"scoreGiven"`
+	ScoreMaximum     float64   `json:"scoreMaximum"`
+	Comment          string    `json:"comment,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+	ActivityProgress string    `json:"activityProgress"`
+	GradingProgress  string    `json:"gradingProgress"`
+}
+
+type cachedToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// AccessToken obtains an OAuth 2 token for the given AGS scopes with the client credentials
+// grant, authenticating with a JWT signed by the tool's key. Tokens are reused until shortly
+// before they expire.
+func (t *Tool) AccessToken(ctx context.Context, p *Platform, scopes ...string) (string, error) {
+	if p.AuthTokenURL == "" {
+		return "", errors.New("lti: platform has no token URL")
+	}
+	scope := strings.Join(scopes, " ")
+	cacheKey := p.AuthTokenURL + " " + p.ClientID + " " + scope
+
+	t.mu.Lock()
+	cached := t.tokens[cacheKey]
+	t.mu.Unlock()
+	if cached != nil && time.Until(cached.expiresAt) > time.Minute {
+		return cached.value, nil
+	}
+
+	jti, err := auth.GenerateSecureToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    p.ClientID,
+		Subject:   p.ClientID,
+		Audience:  jwt.ClaimStrings{p.AuthTokenURL},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		ID:        jti,
+	})
+	assertion.Header["kid"] = t.Key.ID
+	signed, err := assertion.SignedString(t.Key.Private)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	form.Set("client_assertion", signed)
+	form.Set("scope", scope)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.AuthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := t.do(req, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("lti: token response without access_token")
+	}
+	if token.ExpiresIn <= 0 {
+		token.ExpiresIn = 3600
+	}
+
+	t.mu.Lock()
+	t.tokens[cacheKey] = &cachedToken{value: token.AccessToken, expiresAt: now.Add(time.Duration(token.ExpiresIn) * time.Second)}
+	t.mu.Unlock()
+	return token.AccessToken, nil
+}
+
+// CreateLineItem adds a line item to the container URL and returns it with its ID (its URL).
+func (t *Tool) CreateLineItem(ctx context.Context, accessToken, lineItemsURL string, item LineItem) (*LineItem, error) {
+	body, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lineItemsURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", mediaLineItem)
+	req.Header.Set("Accept", mediaLineItem)
+
+	var created LineItem
+	if err := t.do(req, &created); err != nil {
+		return nil, err
+	}
+	if created.ID == "" {
+		return nil, errors.New("lti: created line item has no id")
+	}
+	return &created, nil
+}
+
+// PostScore publishes a score to a line item.
+func (t *Tool) PostScore(ctx context.Context, accessToken, lineItemURL string, score Score) error {
+	body, err := json.Marshal(score)
+	if err != nil {
+		return err
+	}
+	// The scores endpoint is the line item URL with /scores appended to its path
+	u, err := url.Parse(lineItemURL)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/scores"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", mediaScore)
+	return t.do(req, nil)
+}
+
+// do sends req and decodes a JSON response into out, if given. Non-2xx responses are errors.
+func (t *Tool) do(req *http.Request, out interface{}) error {
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("lti: %s %s returned %d: %s", req.Method, req.URL.Redacted(), resp.StatusCode, bytes.TrimSpace(body))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("lti: invalid response from %s: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
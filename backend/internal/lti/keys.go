@@ -0,0 +1,68 @@
+// File: internal/lti/keys.go
+
+package lti
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"backend/internal/jwks"
+)
+
+// Key is the RSA key the tool signs its client assertions with.
+type Key struct {
+	ID      string
+	Private *rsa.PrivateKey
+}
+
+// LoadKey reads a PEM-encoded RSA private key (PKCS #1 or PKCS #8). The key ID is derived from
+// the public key, so it changes when the key is rotated.
+func LoadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("lti: no PEM block in private key file")
+	}
+	var private *rsa.PrivateKey
+	if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		parsed, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("lti: parse private key: %w", err)
+		}
+		var ok bool
+		if private, ok = parsed.(*rsa.PrivateKey); !ok {
+			return nil, errors.New("lti: private key is not an RSA key")
+		}
+	}
+	return newKey(private), nil
+}
+
+// GenerateKey creates a throwaway key. Platforms cache the tool's keys, so a key that changes
+// on every restart is only suitable for development.
+func GenerateKey() (*Key, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return newKey(private), nil
+}
+
+func newKey(private *rsa.PrivateKey) *Key {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(&private.PublicKey))
+	return &Key{ID: hex.EncodeToString(sum[:8]), Private: private}
+}
+
+// JWKS returns the public part of the key, as served to platforms.
+func (k *Key) JWKS() jwks.Set {
+	return jwks.Set{Keys: []jwks.JWK{jwks.FromRSA(&k.Private.PublicKey, k.ID)}}
+}
@@ -0,0 +1,250 @@
+// File: internal/lti/lti.go
+
+// Package lti implements the tool side of LTI 1.3: the OIDC third-party login, validation of
+// launch tokens and the Assignment and Grade Services used to post attendance as a grade.
+package lti
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/internal/jwks"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claim names defined by the LTI 1.3 core and AGS specifications.
+const (
+	ClaimMessageType  = "https://purl.imsglobal.org/spec/lti/claim/message_type"
+	ClaimVersion      = "https://purl.imsglobal.org/spec/lti/claim/version"
+	ClaimDeploymentID = "https://purl.imsglobal.org/spec/lti/claim/deployment_id"
+	ClaimTargetLink   = "https://purl.imsglobal.org/spec/lti/claim/target_link_uri"
+	ClaimRoles        = "https://purl.imsglobal.org/spec/lti/claim/roles"
+	ClaimContext      = "https://purl.imsglobal.org/spec/lti/claim/context"
+	ClaimResourceLink = "https://purl.imsglobal.org/spec/lti/claim/resource_link"
+	ClaimAGSEndpoint  = "https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"
+
+	MessageResourceLink = "LtiResourceLinkRequest"
+
+	ScopeLineItem = "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"
+	ScopeScore    = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+
+	RoleInstructor = "http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"
+	RoleLearner    = "http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"
+)
+
+// Platform is an LMS registered with this tool.
+type Platform struct {
+	Name          string   `json:"name"`
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"clientId"`
+	DeploymentIDs []string `json:"deploymentIds"`
+	AuthLoginURL  string   `json:"authLoginUrl"` // OIDC authorization endpoint
+	AuthTokenURL  string   `json:"authTokenUrl"` // OAuth 2 token endpoint for AGS
+	KeysetURL     string   `json:"keysetUrl"`    // Platform JWKS
+	Organization  string   `json:"organization"` // Slug of the organization its users join; by default their email's
+}
+
+// LoadPlatforms reads the registered platforms from a JSON file holding an array of Platform.
+func LoadPlatforms(path string) ([]Platform, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var platforms []Platform
+	if err := json.Unmarshal(data, &platforms); err != nil {
+		return nil, fmt.Errorf("invalid LTI platforms file: %w", err)
+	}
+	for _, p := range platforms {
+		if p.Issuer == "" || p.ClientID == "" || p.AuthLoginURL == "" || p.KeysetURL == "" {
+			return nil, fmt.Errorf("LTI platform %q needs issuer, clientId, authLoginUrl and keysetUrl", p.Name)
+		}
+	}
+	return platforms, nil
+}
+
+// Context is the LMS course a launch comes from.
+type Context struct {
+	ID    string   `json:"id"`
+	Label string   `json:"label"`
+	Title string   `json:"title"`
+	Type  []string `json:"type"`
+}
+
+// ResourceLink is the placement of the tool inside the course.
+type ResourceLink struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// AGSEndpoint lists the grade services the platform granted for a launch.
+type AGSEndpoint struct {
+	Scope     []string `json:"scope"`
+	LineItems string   `json:"lineitems"`
+	LineItem  string   `json:"lineitem"`
+}
+
+// LaunchClaims are the claims of a resource link launch's id_token.
+type LaunchClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string        `json:"nonce"`
+	AuthorizedParty string        `json:"azp"`
+	Name            string        `json:"name"`
+	GivenName       string        `json:"given_name"`
+	FamilyName      string        `json:"family_name"`
+	Email           string        `json:"email"`
+	MessageType     string        `json:"https://purl.imsglobal.org/spec/lti/claim/message_type"`
+	Version         string        `json:"https://purl.imsglobal.org/spec/lti/claim/version"`
+	DeploymentID    string        `json:"https://purl.imsglobal.org/spec/lti/claim/deployment_id"`
+	TargetLinkURI   string        `json:"https://purl.imsglobal.org/spec/lti/claim/target_link_uri"`
+	Roles           []string      `json:"https://purl.imsglobal.org/spec/lti/claim/roles"`
+	Context         *Context      `json:"https://purl.imsglobal.org/spec/lti/claim/context,omitempty"`
+	ResourceLink    *ResourceLink `json:"https://purl.imsglobal.org/spec/lti/claim/resource_link,omitempty"`
+	Endpoint        *AGSEndpoint  `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint,omitempty"`
+}
+
+// IsInstructor reports whether the launching user teaches the context.
+func (c *LaunchClaims) IsInstructor() bool {
+	return hasRole(c.Roles, "#Instructor") || hasRole(c.Roles, "#Administrator")
+}
+
+// IsLearner reports whether the launching user is a student of the context.
+func (c *LaunchClaims) IsLearner() bool {
+	return hasRole(c.Roles, "#Learner")
+}
+
+// hasRole matches context (membership) roles by their fragment, so that both the full URIs and
+// the short forms some platforms send are recognised. Sub-roles such as
+// "membership/Instructor#TeachingAssistant" do not match.
+func hasRole(roles []string, fragment string) bool {
+	for _, role := range roles {
+		if role == strings.TrimPrefix(fragment, "#") || (strings.HasSuffix(role, fragment) && strings.Contains(role, "membership")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tool holds the registered platforms and this tool's signing key.
+type Tool struct {
+	Platforms []Platform
+	Key       *Key
+	Client    *http.Client
+
+	keys   *jwks.Cache
+	mu     sync.Mutex
+	tokens map[string]*cachedToken // By token URL, client ID and scopes
+}
+
+// NewTool creates a Tool for the given platforms.
+func NewTool(platforms []Platform, key *Key, client *http.Client) *Tool {
+	return &Tool{
+		Platforms: platforms,
+		Key:       key,
+		Client:    client,
+		keys:      jwks.NewCache(client),
+		tokens:    map[string]*cachedToken{},
+	}
+}
+
+// ErrUnknownPlatform is returned for launches from platforms that are not registered.
+var ErrUnknownPlatform = errors.New("lti: platform not registered")
+
+// Platform finds a registration by issuer and client ID. The client ID may be empty when the
+// issuer has a single registration.
+func (t *Tool) Platform(issuer, clientID string) (*Platform, error) {
+	var found *Platform
+	for i := range t.Platforms {
+		p := &t.Platforms[i]
+		if p.Issuer != issuer || (clientID != "" && p.ClientID != clientID) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("lti: several registrations for %s, client_id required", issuer)
+		}
+		found = p
+	}
+	if found == nil {
+		return nil, ErrUnknownPlatform
+	}
+	return found, nil
+}
+
+// AuthRequestURL builds the OIDC authorization request that answers a login initiation.
+func (p *Platform) AuthRequestURL(redirectURI, loginHint, messageHint, state, nonce string) string {
+	q := url.Values{}
+	q.Set("scope", "openid")
+	q.Set("response_type", "id_token")
+	q.Set("response_mode", "form_post")
+	q.Set("prompt", "none")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("login_hint", loginHint)
+	q.Set("lti_message_hint", messageHint)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	sep := "?"
+	if strings.Contains(p.AuthLoginURL, "?") {
+		sep = "&"
+	}
+	return p.AuthLoginURL + sep + q.Encode()
+}
+
+// VerifyLaunch validates a launch's id_token: the platform's signature, issuer, audience,
+// expiry, nonce, deployment and message type.
+func (t *Tool) VerifyLaunch(p *Platform, idToken, nonce string) (*LaunchClaims, error) {
+	var claims LaunchClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return t.keys.Key(ctx, p.KeysetURL, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("lti: invalid id_token: %w", err)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, errors.New("lti: azp does not match the client ID")
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("lti: nonce mismatch")
+	}
+	if claims.Version != "1.3.0" {
+		return nil, fmt.Errorf("lti: unsupported version %q", claims.Version)
+	}
+	if claims.MessageType != MessageResourceLink {
+		return nil, fmt.Errorf("lti: unsupported message type %q", claims.MessageType)
+	}
+	if !contains(p.DeploymentIDs, claims.DeploymentID) {
+		return nil, fmt.Errorf("lti: deployment %q not registered", claims.DeploymentID)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("lti: anonymous launches are not supported")
+	}
+	return &claims, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+// File: internal/metrics/metrics.go
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Outcomes of MarkAttendance, used as the "outcome" label of AttendanceMarks.
+const (
+	MarkSuccess        = "success"
+	MarkInvalidRequest = "invalid_request"
+	MarkExpiredToken   = "expired_token"
+	MarkNotEnrolled    = "not_enrolled"
+	MarkDeviceRejected = "device_rejected"
+	MarkRateLimited    = "rate_limited"
+	MarkDuplicate      = "duplicate"
+	MarkError          = "error"
+)
+
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by chi route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	AttendanceMarks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "attendance_marks_total",
+		Help: "MarkAttendance requests by outcome.",
+	}, []string{"outcome"})
+
+	MongoCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongo_command_duration_seconds",
+		Help:    "MongoDB command latency by command name and result.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"command", "status"})
+
+	NotificationDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notification_deliveries_total",
+		Help: "Notification delivery attempts by channel and result (sent, retry, failed).",
+	}, []string{"channel", "result"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_deliveries_total",
+		Help: "Webhook delivery attempts by result (delivered, retry, failed).",
+	}, []string{"result"})
+
+	BcryptDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bcrypt_duration_seconds",
+		Help:    "Time spent hashing or comparing passwords with bcrypt.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2, 4},
+	}, []string{"operation"})
+)
+
+// Middleware records HTTP latency labelled with the matched route pattern rather than the raw
+// path, so IDs in URLs do not explode the label cardinality.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		HTTPRequestDuration.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// MongoMonitor returns a command monitor that feeds MongoCommandDuration.
+func MongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			MongoCommandDuration.WithLabelValues(e.CommandName, "success").Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			MongoCommandDuration.WithLabelValues(e.CommandName, "failure").Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+// RegisterActiveSessions exposes the number of attendance sessions currently accepting scans.
+// The count is taken from MongoDB on every scrape.
+func RegisterActiveSessions(db *mongo.Database) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "attendance_active_sessions",
+		Help: "Attendance sessions that have not expired yet.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		count, err := db.Collection("attendance_sessions").CountDocuments(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}})
+		if err != nil {
+			return -1
+		}
+		return float64(count)
+	})
+}
@@ -0,0 +1,69 @@
+// File: internal/notify/expo.go
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ExpoPushURL is Expo's push API endpoint.
+const ExpoPushURL = "https://exp.host/--/api/v2/push/send"
+
+// ExpoNotifier sends push notifications to Expo push tokens registered by the mobile app.
+type ExpoNotifier struct {
+	URL         string
+	AccessToken string // Optional; required when enhanced push security is enabled for the project
+	Client      *http.Client
+}
+
+func (n *ExpoNotifier) Send(ctx context.Context, to string, msg Message) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"to":    to,
+		"title": msg.Title,
+		"body":  msg.Body,
+		"data":  msg.Data,
+		"sound": "default",
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if n.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+n.AccessToken)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expo push: unexpected status %d", resp.StatusCode)
+	}
+
+	// Expo answers 200 with a per-message ticket that may still report an error
+	var result struct {
+		Data struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("expo push: %w", err)
+	}
+	if result.Data.Status != "ok" {
+		return errors.New("expo push: " + result.Data.Message)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+// File: internal/notify/log.go
+
+package notify
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogNotifier writes notifications to the log instead of delivering them, for development and tests.
+// Bodies of email verifications are left out, since they carry a secret.
+type LogNotifier struct {
+	Logger  *slog.Logger
+	Channel string
+}
+
+func (n *LogNotifier) Send(ctx context.Context, to string, msg Message) error {
+	body := msg.Body
+	if msg.Event == EventEmailVerification {
+		body = "[redacted]"
+	}
+	n.Logger.InfoContext(ctx, "Notification", "channel", n.Channel, "to", to, "event", msg.Event, "title", msg.Title, "body", body)
+	return nil
+}
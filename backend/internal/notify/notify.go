@@ -0,0 +1,172 @@
+// File: internal/notify/notify.go
+
+// Package notify delivers notifications to users over pluggable channels. Notifications are
+// written to a persistent outbox first and delivered by Service.Run, which retries failures.
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Channels a notification can be delivered over.
+const (
+	ChannelEmail   = "email"
+	ChannelPush    = "push"
+	ChannelWebhook = "webhook"
+)
+
+// Channels lists every channel, in the order preferences are shown.
+var Channels = []string{ChannelEmail, ChannelPush, ChannelWebhook}
+
+// DefaultChannels are used for users who never saved preferences.
+var DefaultChannels = []string{ChannelEmail, ChannelPush}
+
+// Events users can be notified about.
+const (
+	EventSessionOpened     = "session.opened"
+	EventAttendanceMarked  = "attendance.marked"
+	EventAtRisk            = "attendance.at_risk"
+	EventAbsenceExcused    = "absence.excused"
+	EventEmailVerification = "account.email_verification" // Transactional, not subject to preferences
+)
+
+// Events lists the events users can mute.
+var Events = []string{EventSessionOpened, EventAttendanceMarked, EventAtRisk, EventAbsenceExcused}
+
+// Message is the channel-independent content of a notification.
+type Message struct {
+	Event string            `bson:"event" json:"event"`
+	Title string            `bson:"title" json:"title"`
+	Body  string            `bson:"body" json:"body"`
+	Data  map[string]string `bson:"data,omitempty" json:"data,omitempty"`
+}
+
+// Notifier sends a message to one address of its channel: an email address, an Expo push
+// token or a webhook URL.
+type Notifier interface {
+	Send(ctx context.Context, to string, msg Message) error
+}
+
+// Service queues notifications in the outbox and delivers them with the configured notifiers.
+// Channels without a notifier are skipped when queueing. A nil *Service queues nothing.
+type Service struct {
+	DB        *mongo.Database
+	Logger    *slog.Logger
+	Notifiers map[string]Notifier
+	Interval  time.Duration // How often Run polls the outbox
+}
+
+// New creates a Service polling the outbox every few seconds.
+func New(db *mongo.Database, logger *slog.Logger, notifiers map[string]Notifier) *Service {
+	return &Service{
+		DB:        db,
+		Logger:    logger.With("component", "notify"),
+		Notifiers: notifiers,
+		Interval:  5 * time.Second,
+	}
+}
+
+// HasChannel reports whether a notifier is configured for channel.
+func (s *Service) HasChannel(channel string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.Notifiers[channel]
+	return ok
+}
+
+// Delivers reports whether messages on channel reach their recipient, rather than only the log.
+func (s *Service) Delivers(channel string) bool {
+	if s == nil {
+		return false
+	}
+	notifier, ok := s.Notifiers[channel]
+	if _, logged := notifier.(*LogNotifier); logged {
+		return false
+	}
+	return ok
+}
+
+// Enqueue queues msg for each user on the channels their preferences allow, unless they muted
+// the event.
+func (s *Service) Enqueue(ctx context.Context, userIDs []primitive.ObjectID, msg Message) error {
+	if s == nil || len(userIDs) == 0 {
+		return nil
+	}
+
+	cursor, err := s.DB.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": userIDs}},
+		options.Find().SetProjection(bson.M{"email": 1, "push_tokens": 1, "notification_prefs": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var users []database.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var docs []interface{}
+	for _, user := range users {
+		prefs := user.NotificationPrefs
+		if prefs == nil {
+			prefs = &database.NotificationPrefs{Channels: DefaultChannels}
+		}
+		if contains(prefs.MutedEvents, msg.Event) {
+			continue
+		}
+		for _, channel := range prefs.Channels {
+			if !s.HasChannel(channel) {
+				continue
+			}
+			var addresses []string
+			switch channel {
+			case ChannelEmail:
+				addresses = []string{user.Email}
+			case ChannelPush:
+				addresses = user.PushTokens
+			case ChannelWebhook:
+				if prefs.WebhookURL != "" {
+					addresses = []string{prefs.WebhookURL}
+				}
+			}
+			for _, to := range addresses {
+				docs = append(docs, newOutboxMessage(user.ID, channel, to, msg, now))
+			}
+		}
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err = s.DB.Collection(outboxCollection).InsertMany(ctx, docs)
+	return err
+}
+
+// EnqueueTo queues msg to a single address regardless of preferences, for transactional
+// messages such as verification codes.
+func (s *Service) EnqueueTo(ctx context.Context, channel, to string, msg Message) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.DB.Collection(outboxCollection).InsertOne(ctx, newOutboxMessage(primitive.NilObjectID, channel, to, msg, time.Now()))
+	return err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+// File: internal/notify/outbox.go
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/internal/metrics"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	outboxCollection = "notification_outbox"
+
+	// Outbox message states
+	statusPending = "pending"
+	statusSending = "sending"
+	statusSent    = "sent"
+	statusFailed  = "failed"
+
+	maxAttempts = 8
+	batchSize   = 50
+	// sendLease is how long a claimed message is left alone before another replica may retry it
+	sendLease   = 2 * time.Minute
+	sendTimeout = 30 * time.Second
+)
+
+// outboxMessage is one delivery of a Message to one address.
+type outboxMessage struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	UserID        primitive.ObjectID `bson:"user_id,omitempty"` // Empty for transactional messages
+	Channel       string             `bson:"channel"`
+	To            string             `bson:"to"`
+	Message       Message            `bson:"message"`
+	Status        string             `bson:"status"`
+	Attempts      int                `bson:"attempts"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at"`
+	LockedUntil   *time.Time         `bson:"locked_until,omitempty"`
+	LastError     string             `bson:"last_error,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	SentAt        *time.Time         `bson:"sent_at,omitempty"` // Sent messages are removed by a TTL index
+}
+
+func newOutboxMessage(userID primitive.ObjectID, channel, to string, msg Message, now time.Time) outboxMessage {
+	return outboxMessage{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		Channel:       channel,
+		To:            to,
+		Message:       msg,
+		Status:        statusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// Pending counts the outbox messages still to be delivered. A nil *Service has none.
+func (s *Service) Pending(ctx context.Context) (int64, error) {
+	if s == nil {
+		return 0, nil
+	}
+	return s.DB.Collection(outboxCollection).CountDocuments(ctx, bson.M{"status": bson.M{"$in": bson.A{statusPending, statusSending}}})
+}
+
+// Run delivers due outbox messages until ctx is cancelled. Several replicas may run it: each
+// message is claimed before it is sent.
+func (s *Service) Run(ctx context.Context) {
+	s.Logger.Info("Notification delivery started", "channels", len(s.Notifiers), "interval", s.Interval)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) deliverDue(ctx context.Context) {
+	for i := 0; i < batchSize; i++ {
+		msg, err := s.claim(ctx, time.Now())
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) && ctx.Err() == nil {
+				s.Logger.Error("Failed to claim outbox message", "error", err)
+			}
+			return
+		}
+		s.deliver(ctx, msg)
+	}
+}
+
+// claim takes the oldest due message, including ones whose previous sender died mid-delivery.
+func (s *Service) claim(ctx context.Context, now time.Time) (*outboxMessage, error) {
+	var msg outboxMessage
+	err := s.DB.Collection(outboxCollection).FindOneAndUpdate(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"status": statusPending, "next_attempt_at": bson.M{"$lte": now}},
+			bson.M{"status": statusSending, "locked_until": bson.M{"$lt": now}},
+		}},
+		bson.M{"$set": bson.M{"status": statusSending, "locked_until": now.Add(sendLease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.M{"next_attempt_at": 1}).
+			SetReturnDocument(options.After),
+	).Decode(&msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *Service) deliver(ctx context.Context, msg *outboxMessage) {
+	var err error
+	notifier, ok := s.Notifiers[msg.Channel]
+	if !ok {
+		err = errors.New("channel not configured")
+	} else {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err = notifier.Send(sendCtx, msg.To, msg.Message)
+		cancel()
+	}
+
+	now := time.Now()
+	attempts := msg.Attempts + 1
+	update := bson.M{
+		"$set":   bson.M{"attempts": attempts},
+		"$unset": bson.M{"locked_until": ""},
+	}
+	set := update["$set"].(bson.M)
+	switch {
+	case err == nil:
+		set["status"] = statusSent
+		set["sent_at"] = now
+		metrics.NotificationDeliveries.WithLabelValues(msg.Channel, statusSent).Inc()
+	case attempts >= maxAttempts || !ok:
+		set["status"] = statusFailed
+		set["last_error"] = err.Error()
+		metrics.NotificationDeliveries.WithLabelValues(msg.Channel, statusFailed).Inc()
+		s.Logger.Warn("Giving up on notification", "id", msg.ID.Hex(), "channel", msg.Channel, "event", msg.Message.Event, "attempts", attempts, "error", err)
+	default:
+		set["status"] = statusPending
+		set["last_error"] = err.Error()
+		set["next_attempt_at"] = now.Add(retryDelay(attempts))
+		metrics.NotificationDeliveries.WithLabelValues(msg.Channel, "retry").Inc()
+		s.Logger.Info("Notification delivery failed, will retry", "id", msg.ID.Hex(), "channel", msg.Channel, "attempts", attempts, "error", err)
+	}
+
+	if _, err := s.DB.Collection(outboxCollection).UpdateOne(ctx, bson.M{"_id": msg.ID}, update); err != nil {
+		s.Logger.Error("Failed to update outbox message", "id", msg.ID.Hex(), "error", err)
+	}
+}
+
+// retryDelay doubles from 30 seconds after each failed attempt, up to an hour.
+func retryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
@@ -0,0 +1,47 @@
+// File: internal/notify/webhook.go
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookNotifier posts notifications as JSON to a URL the user configured, for example a chat
+// integration. Client should refuse internal addresses and redirects; see safehttp.NewClient.
+type WebhookNotifier struct {
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Send(ctx context.Context, to string, msg Message) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":  msg.Event,
+		"title":  msg.Title,
+		"body":   msg.Body,
+		"data":   msg.Data,
+		"sentAt": time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// File: internal/safehttp/safehttp.go
+
+// Package safehttp builds HTTP clients for calling URLs that users supply, such as webhooks,
+// which must not reach the server's own network or cloud metadata endpoints.
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrForbiddenAddress is returned when a URL resolves to an address the client refuses.
+	ErrForbiddenAddress = errors.New("safehttp: destination address is not allowed")
+	// ErrRedirect is returned when a server answers with a redirect, which is not followed.
+	ErrRedirect = errors.New("safehttp: redirects are not followed")
+)
+
+// Ranges not covered by the net.IP predicates checked in allowed.
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",     // "This network"
+	"100.64.0.0/10", // Carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // Benchmarking
+	"240.0.0.0/4",   // Reserved, including broadcast
+	"64:ff9b::/96",  // NAT64, which can embed any IPv4 address
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// allowed reports whether ip is a public unicast address.
+func allowed(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// control runs after the host name is resolved and before each connection is made, so the
+// address checked is the one actually dialled, whatever DNS answers later.
+func control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !allowed(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// NewClient returns a client that only connects to public addresses and does not follow
+// redirects. It ignores proxy settings, since a proxy would make the connection on its behalf.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: control}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return ErrRedirect
+		},
+	}
+}
@@ -0,0 +1,124 @@
+// File: internal/schedule/schedule.go
+
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"backend/internal/database"
+)
+
+const (
+	DateLayout  = "2006-01-02"
+	ClockLayout = "15:04"
+)
+
+// Validate checks that a schedule can be expanded into meetings.
+func Validate(s *database.ClassSchedule) error {
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", s.Timezone)
+	}
+	start, err := time.Parse(DateLayout, s.TermStart)
+	if err != nil {
+		return fmt.Errorf("invalid termStart %q", s.TermStart)
+	}
+	end, err := time.Parse(DateLayout, s.TermEnd)
+	if err != nil {
+		return fmt.Errorf("invalid termEnd %q", s.TermEnd)
+	}
+	if end.Before(start) {
+		return errors.New("termEnd must not be before termStart")
+	}
+	for _, h := range s.Holidays {
+		if _, err := time.Parse(DateLayout, h); err != nil {
+			return fmt.Errorf("invalid holiday %q", h)
+		}
+	}
+	for i, rule := range s.Rules {
+		if rule.Weekday < time.Sunday || rule.Weekday > time.Saturday {
+			return fmt.Errorf("rule %d: weekday must be between 0 (Sunday) and 6 (Saturday)", i)
+		}
+		from, err := time.Parse(ClockLayout, rule.StartTime)
+		if err != nil {
+			return fmt.Errorf("rule %d: invalid startTime %q", i, rule.StartTime)
+		}
+		to, err := time.Parse(ClockLayout, rule.EndTime)
+		if err != nil {
+			return fmt.Errorf("rule %d: invalid endTime %q", i, rule.EndTime)
+		}
+		if !to.After(from) {
+			return fmt.Errorf("rule %d: endTime must be after startTime", i)
+		}
+	}
+	return nil
+}
+
+// Meetings expands the schedule into every meeting overlapping [from, to), sorted by start time.
+func Meetings(s *database.ClassSchedule, from, to time.Time) ([]database.Meeting, error) {
+	if err := Validate(s); err != nil {
+		return nil, err
+	}
+	loc, _ := time.LoadLocation(s.Timezone)
+	termStart, _ := time.ParseInLocation(DateLayout, s.TermStart, loc)
+	termEnd, _ := time.ParseInLocation(DateLayout, s.TermEnd, loc)
+
+	holidays := make(map[string]bool, len(s.Holidays))
+	for _, h := range s.Holidays {
+		holidays[h] = true
+	}
+
+	// Start a day early so a meeting that began yesterday and is still running is not missed.
+	day := startOfDay(from.In(loc)).AddDate(0, 0, -1)
+	if day.Before(termStart) {
+		day = termStart
+	}
+	last := startOfDay(to.In(loc))
+	if last.After(termEnd) {
+		last = termEnd
+	}
+
+	meetings := []database.Meeting{}
+	for ; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if holidays[day.Format(DateLayout)] {
+			continue
+		}
+		for _, rule := range s.Rules {
+			if rule.Weekday != day.Weekday() {
+				continue
+			}
+			m := database.Meeting{
+				Start: atClock(day, rule.StartTime),
+				End:   atClock(day, rule.EndTime),
+				Room:  rule.Room,
+			}
+			if m.Start.Before(to) && m.End.After(from) {
+				meetings = append(meetings, m)
+			}
+		}
+	}
+
+	sort.Slice(meetings, func(i, j int) bool { return meetings[i].Start.Before(meetings[j].Start) })
+	return meetings, nil
+}
+
+// MeetingAt returns the scheduled meeting in progress at t, or nil if there is none.
+func MeetingAt(s *database.ClassSchedule, t time.Time) (*database.Meeting, error) {
+	meetings, err := Meetings(s, t, t.Add(time.Nanosecond))
+	if err != nil || len(meetings) == 0 {
+		return nil, err
+	}
+	return &meetings[0], nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// atClock combines a day with a validated "15:04" clock string.
+func atClock(day time.Time, clock string) time.Time {
+	c, _ := time.Parse(ClockLayout, clock)
+	return time.Date(day.Year(), day.Month(), day.Day(), c.Hour(), c.Minute(), 0, 0, day.Location())
+}
@@ -0,0 +1,366 @@
+// File: internal/scheduler/scheduler.go
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/database"
+	"backend/internal/notify"
+	"backend/internal/schedule"
+	"backend/internal/webhook"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lockID is the _id of the document in the "locks" collection that elects the leader.
+const lockID = "session-scheduler"
+
+// atRiskInterval is how often students' standing is checked against their classes' required percentage.
+const atRiskInterval = time.Hour
+
+// Scheduler opens attendance sessions at the start of scheduled meetings when AutoSessions is
+// set, closes lectures that have ended, warns students who fall under their class's required
+// attendance and purges classrooms whose restore window has run out.
+// Only the replica holding the Mongo lock does any work on a given tick.
+type Scheduler struct {
+	DB           *mongo.Database
+	Logger       *slog.Logger
+	Interval     time.Duration       // How often to check for due meetings
+	AutoSessions bool                // Whether to open sessions from class timetables
+	Window       time.Duration       // How long an automatic session stays open after the meeting starts
+	Notify       *notify.Service     // Optional; nil disables notifications
+	Webhooks     *webhook.Dispatcher // Optional; nil disables webhooks
+	instanceID   string
+
+	lastAtRiskCheck time.Time
+}
+
+// New creates a Scheduler with a unique identity for leader election.
+func New(db *mongo.Database, logger *slog.Logger, interval, window time.Duration) *Scheduler {
+	host, _ := os.Hostname()
+	suffix, _ := auth.GenerateSecureToken(4)
+	return &Scheduler{
+		DB:         db,
+		Logger:     logger.With("component", "scheduler"),
+		Interval:   interval,
+		Window:     window,
+		instanceID: host + "-" + suffix,
+	}
+}
+
+// Run ticks until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.Logger.Info("Session scheduler started", "instance", s.instanceID, "interval", s.Interval, "window", s.Window, "auto_sessions", s.AutoSessions)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	// A tick must finish before the next one is due
+	ctx, cancel := context.WithTimeout(ctx, s.Interval)
+	defer cancel()
+
+	leader, err := s.acquireLock(ctx)
+	if err != nil {
+		s.Logger.Error("Failed to acquire lock", "error", err)
+		return
+	}
+	if !leader {
+		return
+	}
+	if s.AutoSessions {
+		if err := s.openDueSessions(ctx, time.Now()); err != nil {
+			s.Logger.Error("Failed to open sessions", "error", err)
+		}
+	}
+	if err := s.closeEndedLectures(ctx, time.Now()); err != nil {
+		s.Logger.Error("Failed to close lectures", "error", err)
+	}
+	if err := s.purgeDeletedClassrooms(ctx, time.Now()); err != nil {
+		s.Logger.Error("Failed to purge deleted classrooms", "error", err)
+	}
+	if s.Notify != nil && time.Since(s.lastAtRiskCheck) >= atRiskInterval {
+		if err := s.notifyAtRisk(ctx); err != nil {
+			s.Logger.Error("Failed to check at-risk students", "error", err)
+		} else {
+			s.lastAtRiskCheck = time.Now()
+		}
+	}
+}
+
+// acquireLock takes or renews the leader lease. The lease outlives a few missed ticks so that
+// leadership only moves when the current leader has actually stopped.
+func (s *Scheduler) acquireLock(ctx context.Context) (bool, error) {
+	now := time.Now()
+	locksCollection := s.DB.Collection("locks")
+	_, err := locksCollection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": lockID,
+			"$or": bson.A{
+				bson.M{"owner": s.instanceID},
+				bson.M{"expires_at": bson.M{"$lt": now}},
+			},
+		},
+		bson.M{"$set": bson.M{"owner": s.instanceID, "expires_at": now.Add(3 * s.Interval)}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		// The upsert collides with the existing lock when another replica holds it
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// openDueSessions creates a session for every meeting that started within the last Window.
+// The unique meeting index makes this idempotent across ticks and replicas.
+func (s *Scheduler) openDueSessions(ctx context.Context, now time.Time) error {
+	classroomsCollection := s.DB.Collection("classrooms")
+	sessionsCollection := s.DB.Collection("attendance_sessions")
+
+	cursor, err := classroomsCollection.Find(ctx, bson.M{
+		"schedule":    bson.M{"$exists": true},
+		"archived_at": bson.M{"$exists": false},
+		"deleted_at":  bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var classrooms []database.Classroom
+	if err := cursor.All(ctx, &classrooms); err != nil {
+		return err
+	}
+
+	for _, classroom := range classrooms {
+		meetings, err := schedule.Meetings(classroom.Schedule, now.Add(-s.Window), now.Add(time.Nanosecond))
+		if err != nil {
+			s.Logger.Warn("Skipping classroom with invalid schedule", "classroom_id", classroom.ID.Hex(), "error", err)
+			continue
+		}
+		for _, m := range meetings {
+			closesAt := m.Start.Add(s.Window)
+			if m.Start.After(now) || !closesAt.After(now) {
+				continue
+			}
+
+			token, err := auth.GenerateSecureToken(16)
+			if err != nil {
+				return err
+			}
+			meeting := m
+			lecture, created, err := database.OpenLecture(ctx, s.DB, classroom.ID, &meeting, now)
+			if err != nil {
+				return err
+			}
+			if created {
+				s.notifySessionOpened(ctx, &classroom, lecture)
+				if err := s.Webhooks.Emit(ctx, webhook.SessionOpened(lecture)); err != nil {
+					s.Logger.Error("Failed to queue webhook event", "event", webhook.EventSessionOpened, "error", err)
+				}
+			}
+			session := database.AttendanceSession{
+				ID:          primitive.NewObjectID(),
+				Token:       token,
+				ClassroomID: classroom.ID,
+				CreatedAt:   now,
+				ExpiresAt:   closesAt,
+				Meeting:     &meeting,
+				Automatic:   true,
+				LectureID:   lecture.ID,
+				LectureAt:   lecture.StartedAt,
+			}
+			if _, err := sessionsCollection.InsertOne(ctx, session); err != nil {
+				if mongo.IsDuplicateKeyError(err) {
+					continue // Already opened on an earlier tick
+				}
+				return err
+			}
+			s.Logger.Info("Opened automatic session", "classroom_id", classroom.ID.Hex(), "closes_at", closesAt)
+		}
+	}
+	return nil
+}
+
+// closeEndedLectures closes lectures that no further session can join: their meeting, if any,
+// is over, no session is open and the last one was opened more than database.LectureGap ago.
+// Each lecture is closed once, which emits session.closed to webhooks.
+func (s *Scheduler) closeEndedLectures(ctx context.Context, now time.Time) error {
+	lecturesCollection := s.DB.Collection("lectures")
+	cursor, err := lecturesCollection.Find(ctx, bson.M{
+		"closed_at":        bson.M{"$exists": false},
+		"last_activity_at": bson.M{"$gte": now.Add(-database.LectureCloseLookback), "$lt": now.Add(-database.LectureGap)},
+	})
+	if err != nil {
+		return err
+	}
+	var lectures []database.Lecture
+	if err := cursor.All(ctx, &lectures); err != nil {
+		return err
+	}
+
+	for _, lecture := range lectures {
+		if lecture.Meeting != nil && lecture.Meeting.End.After(now) {
+			continue
+		}
+		open, err := s.DB.Collection("attendance_sessions").CountDocuments(ctx, bson.M{"lecture_id": lecture.ID, "expires_at": bson.M{"$gt": now}})
+		if err != nil {
+			return err
+		}
+		if open > 0 {
+			continue
+		}
+
+		result, err := lecturesCollection.UpdateOne(ctx,
+			bson.M{"_id": lecture.ID, "closed_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"closed_at": now}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+		lecture.ClosedAt = &now
+
+		attended, err := s.DB.Collection("attendance_records").CountDocuments(ctx, bson.M{"lecture_id": lecture.ID})
+		if err != nil {
+			return err
+		}
+		if err := s.Webhooks.Emit(ctx, webhook.SessionClosed(&lecture, int(attended))); err != nil {
+			s.Logger.Error("Failed to queue webhook event", "event", webhook.EventSessionClosed, "error", err)
+		}
+	}
+	return nil
+}
+
+// purgeDeletedClassrooms permanently removes soft-deleted classrooms past their restore window,
+// together with their attendance records, lectures and webhooks, and unpairs their kiosks.
+func (s *Scheduler) purgeDeletedClassrooms(ctx context.Context, now time.Time) error {
+	classroomsCollection := s.DB.Collection("classrooms")
+	filter := bson.M{"deleted_at": bson.M{"$lte": now.Add(-database.ClassroomRestoreWindow)}}
+
+	classIDs, err := classroomsCollection.Distinct(ctx, "_id", filter)
+	if err != nil || len(classIDs) == 0 {
+		return err
+	}
+
+	byClass := bson.M{"classroom_id": bson.M{"$in": classIDs}}
+	for _, collection := range []string{"attendance_records", "lectures", "at_risk", "webhooks", "webhook_deliveries"} {
+		if _, err := s.DB.Collection(collection).DeleteMany(ctx, byClass); err != nil {
+			return err
+		}
+	}
+	// Kiosks keep their credential and can be paired again, as after UnpairKiosk
+	_, err = s.DB.Collection("kiosks").UpdateMany(ctx, byClass,
+		bson.M{"$unset": bson.M{"classroom_id": "", "organization_id": "", "room": "", "paired_by": "", "paired_at": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := classroomsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": classIDs}}); err != nil {
+		return err
+	}
+	s.Logger.Info("Purged deleted classrooms", "count", len(classIDs))
+	return nil
+}
+
+// notifySessionOpened tells a class's students that an automatic session has opened.
+func (s *Scheduler) notifySessionOpened(ctx context.Context, classroom *database.Classroom, lecture *database.Lecture) {
+	students := make([]primitive.ObjectID, 0, len(classroom.StudentIDs))
+	for _, id := range classroom.StudentIDs {
+		if id != classroom.InstructorID {
+			students = append(students, id)
+		}
+	}
+	err := s.Notify.Enqueue(ctx, students, notify.Message{
+		Event: notify.EventSessionOpened,
+		Title: "Attendance is open",
+		Body:  "Attendance for " + classroom.Name + " is being taken now.",
+		Data:  map[string]string{"classId": classroom.ID.Hex(), "lectureId": lecture.ID.Hex()},
+	})
+	if err != nil {
+		s.Logger.Error("Failed to queue notification", "event", notify.EventSessionOpened, "error", err)
+	}
+}
+
+// notifyAtRisk warns students who have fallen under their class's required percentage. A marker
+// in the "at_risk" collection keeps the warning from repeating; it is removed once the student
+// is back above the requirement, so a later drop warns again.
+func (s *Scheduler) notifyAtRisk(ctx context.Context) error {
+	cursor, err := s.DB.Collection("classrooms").Find(ctx, bson.M{
+		"settings.required_percent": bson.M{"$gt": 0},
+		"archived_at":               bson.M{"$exists": false},
+		"deleted_at":                bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	var classrooms []database.Classroom
+	if err := cursor.All(ctx, &classrooms); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	atRiskCollection := s.DB.Collection("at_risk")
+	for _, classroom := range classrooms {
+		summaries, err := database.SummarizeClass(ctx, s.DB, classroom, now)
+		if err != nil {
+			return err
+		}
+		if summaries == nil {
+			continue
+		}
+
+		for studentID, summary := range summaries {
+			marker := bson.M{"classroom_id": classroom.ID, "user_id": studentID}
+			if !summary.AtRisk() {
+				if _, err := atRiskCollection.DeleteOne(ctx, marker); err != nil {
+					return err
+				}
+				continue
+			}
+
+			_, err := atRiskCollection.InsertOne(ctx, bson.M{"classroom_id": classroom.ID, "user_id": studentID, "since": now})
+			if err != nil {
+				if mongo.IsDuplicateKeyError(err) {
+					continue // Already warned
+				}
+				return err
+			}
+			err = s.Notify.Enqueue(ctx, []primitive.ObjectID{studentID}, notify.Message{
+				Event: notify.EventAtRisk,
+				Title: "Attendance below requirement",
+				Body: fmt.Sprintf("Your attendance in %s is %.1f%%, under the required %d%%.",
+					classroom.Name, *summary.Percentage, classroom.Settings.RequiredPercent),
+				Data: map[string]string{"classId": classroom.ID.Hex()},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// File: internal/webhook/delivery.go
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"backend/internal/database"
+	"backend/internal/metrics"
+	"backend/internal/safehttp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	deliveriesCollection = "webhook_deliveries"
+
+	maxAttempts = 8
+	batchSize   = 50
+	// sendLease is how long a claimed delivery is left alone before another replica may retry it
+	sendLease   = 2 * time.Minute
+	sendTimeout = 15 * time.Second
+)
+
+// Errors recorded on failed deliveries, which the delivery log shows. Response bodies and
+// transport errors are not kept: they could reveal what an endpoint, or the network behind it,
+// returned.
+var (
+	errAddressRefused = errors.New("destination address is not allowed")
+	errRedirect       = errors.New("redirect not followed")
+	errTimeout        = errors.New("request timed out")
+	errConnection     = errors.New("connection failed")
+)
+
+// Run sends due deliveries until ctx is cancelled. Several replicas may run it: each delivery
+// is claimed before it is sent.
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.Logger.Info("Webhook delivery started", "interval", d.Interval)
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for i := 0; i < batchSize; i++ {
+		delivery, err := d.claim(ctx, time.Now())
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) && ctx.Err() == nil {
+				d.Logger.Error("Failed to claim webhook delivery", "error", err)
+			}
+			return
+		}
+		d.deliver(ctx, delivery)
+	}
+}
+
+// claim takes the oldest due delivery, including ones whose previous sender died mid-delivery.
+func (d *Dispatcher) claim(ctx context.Context, now time.Time) (*database.WebhookDelivery, error) {
+	var delivery database.WebhookDelivery
+	err := d.DB.Collection(deliveriesCollection).FindOneAndUpdate(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"status": database.DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+			bson.M{"status": database.DeliverySending, "locked_until": bson.M{"$lt": now}},
+		}},
+		bson.M{"$set": bson.M{"status": database.DeliverySending, "locked_until": now.Add(sendLease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.M{"next_attempt_at": 1}).
+			SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *database.WebhookDelivery) {
+	var hook database.Webhook
+	err := d.DB.Collection("webhooks").FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&hook)
+	permanent := false
+	if err == mongo.ErrNoDocuments || (err == nil && !hook.Active) {
+		err = errors.New("webhook removed or disabled")
+		permanent = true
+	}
+	responseStatus := 0
+	if err == nil {
+		responseStatus, err = d.send(ctx, &hook, delivery)
+	}
+
+	now := time.Now()
+	attempts := delivery.Attempts + 1
+	set := bson.M{"attempts": attempts}
+	if responseStatus != 0 {
+		set["response_status"] = responseStatus
+	}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"locked_until": ""},
+	}
+	switch {
+	case err == nil:
+		set["status"] = database.DeliveryDelivered
+		set["delivered_at"] = now
+		update["$unset"] = bson.M{"locked_until": "", "last_error": ""}
+		metrics.WebhookDeliveries.WithLabelValues(database.DeliveryDelivered).Inc()
+	case attempts >= maxAttempts || permanent:
+		set["status"] = database.DeliveryFailed
+		set["last_error"] = err.Error()
+		metrics.WebhookDeliveries.WithLabelValues(database.DeliveryFailed).Inc()
+		d.Logger.Warn("Giving up on webhook delivery", "id", delivery.ID.Hex(), "webhook_id", delivery.WebhookID.Hex(), "event", delivery.Event, "attempts", attempts, "error", err)
+	default:
+		set["status"] = database.DeliveryPending
+		set["last_error"] = err.Error()
+		set["next_attempt_at"] = now.Add(retryDelay(attempts))
+		metrics.WebhookDeliveries.WithLabelValues("retry").Inc()
+		d.Logger.Info("Webhook delivery failed, will retry", "id", delivery.ID.Hex(), "webhook_id", delivery.WebhookID.Hex(), "attempts", attempts, "error", err)
+	}
+
+	if _, err := d.DB.Collection(deliveriesCollection).UpdateOne(ctx, bson.M{"_id": delivery.ID}, update); err != nil {
+		d.Logger.Error("Failed to update webhook delivery", "id", delivery.ID.Hex(), "error", err)
+	}
+}
+
+// send posts the signed payload and returns the response status. Any 2xx counts as delivered.
+func (d *Dispatcher) send(ctx context.Context, hook *database.Webhook, delivery *database.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, time.Now(), payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		d.Logger.Info("Webhook request failed", "webhook_id", hook.ID.Hex(), "error", err)
+		return 0, sendError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return resp.StatusCode, errRedirect
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// sendError maps a failed request to one of the fixed errors the delivery log may show.
+func sendError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, safehttp.ErrForbiddenAddress):
+		return errAddressRefused
+	case errors.Is(err, safehttp.ErrRedirect):
+		return errRedirect
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout
+	default:
+		return errConnection
+	}
+}
+
+// retryDelay doubles from 30 seconds after each failed attempt, up to an hour.
+func retryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
@@ -0,0 +1,238 @@
+// File: internal/webhook/webhook.go
+
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Events webhooks can subscribe to.
+const (
+	EventAttendanceMarked = "attendance.marked"
+	EventSessionOpened    = "session.opened"
+	EventSessionClosed    = "session.closed"
+	EventStudentJoined    = "student.joined"
+)
+
+// Events lists the events a webhook can subscribe to.
+var Events = []string{EventAttendanceMarked, EventSessionOpened, EventSessionClosed, EventStudentJoined}
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Event is something that happened in a classroom. When StudentID is set, the student's name,
+// email and roll number are added to Data as "student".
+type Event struct {
+	Type        string
+	ClassroomID primitive.ObjectID
+	StudentID   primitive.ObjectID
+	Data        map[string]interface{}
+}
+
+// Dispatcher queues events for the webhooks subscribed to them and delivers them. A nil
+// *Dispatcher queues nothing.
+type Dispatcher struct {
+	DB       *mongo.Database
+	Logger   *slog.Logger
+	Client   *http.Client
+	Interval time.Duration // How often Run polls for due deliveries
+}
+
+// New creates a Dispatcher polling for due deliveries every few seconds.
+func New(db *mongo.Database, logger *slog.Logger, client *http.Client) *Dispatcher {
+	return &Dispatcher{
+		DB:       db,
+		Logger:   logger.With("component", "webhook"),
+		Client:   client,
+		Interval: 5 * time.Second,
+	}
+}
+
+// Emit queues a delivery of event to each active webhook subscribed to it, of its classroom or
+// of the classroom's organization.
+func (d *Dispatcher) Emit(ctx context.Context, event Event) error {
+	if d == nil {
+		return nil
+	}
+
+	var classroom database.Classroom
+	err := d.DB.Collection("classrooms").FindOne(ctx,
+		bson.M{"_id": event.ClassroomID},
+		options.FindOne().SetProjection(bson.M{"organization_id": 1}),
+	).Decode(&classroom)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	owners := bson.A{bson.M{"classroom_id": event.ClassroomID}}
+	if !classroom.OrganizationID.IsZero() {
+		owners = append(owners, bson.M{"organization_id": classroom.OrganizationID})
+	}
+
+	cursor, err := d.DB.Collection("webhooks").Find(ctx, bson.M{
+		"$or":    owners,
+		"active": true,
+		"events": event.Type,
+	})
+	if err != nil {
+		return err
+	}
+	var hooks []database.Webhook
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	data := event.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if !event.StudentID.IsZero() {
+		var student database.User
+		err := d.DB.Collection("users").FindOne(ctx,
+			bson.M{"_id": event.StudentID},
+			options.FindOne().SetProjection(bson.M{"name": 1, "email": 1, "roll_number": 1}),
+		).Decode(&student)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		data["student"] = map[string]interface{}{
+			"id":         event.StudentID,
+			"name":       student.Name,
+			"email":      student.Email,
+			"rollNumber": student.RollNumber,
+		}
+	}
+
+	now := time.Now()
+	eventID := primitive.NewObjectID()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":         eventID,
+		"event":      event.Type,
+		"classId":    event.ClassroomID,
+		"occurredAt": now.UTC(),
+		"data":       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	docs := make([]interface{}, len(hooks))
+	for i, hook := range hooks {
+		docs[i] = database.WebhookDelivery{
+			ID:            primitive.NewObjectID(),
+			WebhookID:     hook.ID,
+			ClassroomID:   event.ClassroomID,
+			EventID:       eventID,
+			Event:         event.Type,
+			Payload:       string(payload),
+			Status:        database.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+	}
+	_, err = d.DB.Collection("webhook_deliveries").InsertMany(ctx, docs)
+	return err
+}
+
+// AttendanceMarked describes a new attendance record.
+func AttendanceMarked(record database.AttendanceRecord) Event {
+	return Event{
+		Type:        EventAttendanceMarked,
+		ClassroomID: record.ClassroomID,
+		StudentID:   record.UserID,
+		Data: map[string]interface{}{
+			"recordId":  record.ID,
+			"lectureId": record.LectureID,
+			"status":    record.Status,
+			"method":    record.Method,
+			"timestamp": record.Timestamp,
+		},
+	}
+}
+
+// SessionOpened describes a lecture whose first attendance session has opened.
+func SessionOpened(lecture *database.Lecture) Event {
+	return Event{
+		Type:        EventSessionOpened,
+		ClassroomID: lecture.ClassroomID,
+		Data: map[string]interface{}{
+			"lectureId": lecture.ID,
+			"startedAt": lecture.StartedAt,
+			"meeting":   lecture.Meeting,
+		},
+	}
+}
+
+// SessionClosed describes a lecture that no further attendance session can join.
+func SessionClosed(lecture *database.Lecture, attended int) Event {
+	return Event{
+		Type:        EventSessionClosed,
+		ClassroomID: lecture.ClassroomID,
+		Data: map[string]interface{}{
+			"lectureId": lecture.ID,
+			"startedAt": lecture.StartedAt,
+			"closedAt":  lecture.ClosedAt,
+			"meeting":   lecture.Meeting,
+			"attended":  attended,
+		},
+	}
+}
+
+// StudentJoined describes a student enrolling in a class.
+func StudentJoined(classroomID, studentID primitive.ObjectID) Event {
+	return Event{Type: EventStudentJoined, ClassroomID: classroomID, StudentID: studentID}
+}
+
+// Replay queues a new delivery of a logged delivery's payload to its webhook.
+func (d *Dispatcher) Replay(ctx context.Context, original *database.WebhookDelivery) (*database.WebhookDelivery, error) {
+	now := time.Now()
+	delivery := database.WebhookDelivery{
+		ID:            primitive.NewObjectID(),
+		WebhookID:     original.WebhookID,
+		ClassroomID:   original.ClassroomID,
+		EventID:       original.EventID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        database.DeliveryPending,
+		NextAttemptAt: now,
+		ReplayOf:      &original.ID,
+		CreatedAt:     now,
+	}
+	if _, err := d.DB.Collection("webhook_deliveries").InsertOne(ctx, delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Sign computes the signature header for a payload sent at t: "t=<unix seconds>,v1=<hex>",
+// where the hex is the HMAC-SHA256, keyed with the webhook secret, of "<unix seconds>.<payload>".
+// Receivers should recompute it and reject old timestamps to prevent replay by third parties.
+func Sign(secret string, t time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}